@@ -0,0 +1,427 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"smsclient"
+)
+
+// commands maps the command names to their implementation
+var commands = map[string]command{
+	"domain create": {args: "<domain>", help: "Create a secret domain", run: domainCreate},
+	"domain list":   {args: "", help: "List secret domains", run: domainList},
+	"domain delete": {args: "<domain>", help: "Delete a secret domain and its secrets", run: domainDelete},
+	"secret get":    {args: "<domain> <secret>", help: "Show the values of a secret", run: secretGet, flags: secretGetFlags},
+	"secret put": {args: "<domain> <secret> [key[:type]=value ...]",
+		help: "Create a secret from arguments or a JSON file", run: secretPut, flags: secretPutFlags},
+	"secret list":   {args: "<domain>", help: "List the secrets in a domain", run: secretList},
+	"secret delete": {args: "<domain> <secret>", help: "Delete a secret", run: secretDelete},
+	"status":        {args: "", help: "Show the seal status of SMS", run: status},
+	"unseal":        {args: "[shard|-]", help: "Send an unseal shard to SMS", run: unseal, flags: unsealFlags},
+}
+
+// Command specific flags
+var (
+	getKey     string
+	putFile    string
+	unsealFile string
+)
+
+func secretGetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&getKey, "field", "", "Print only the value of this key")
+}
+
+func secretPutFlags(fs *flag.FlagSet) {
+	fs.StringVar(&putFile, "file", "", "JSON file with the secret values. Use - for stdin")
+}
+
+func unsealFlags(fs *flag.FlagSet) {
+	fs.StringVar(&unsealFile, "file", "", "File containing the unseal shard. Use - for stdin")
+}
+
+// checkArgs validates the number of positional arguments
+func checkArgs(fs *flag.FlagSet, min int, max int) error {
+	n := fs.NArg()
+	if n < min || (max >= 0 && n > max) {
+		fs.Usage()
+		return errors.New("Wrong number of arguments")
+	}
+	return nil
+}
+
+// readInput reads a file, or stdin when name is "-"
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func domainCreate(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	dom, err := c.CreateDomain(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch o.output {
+	case "json":
+		return printJSON(dom)
+	case "table":
+		return printTable([]string{"NAME", "UUID"}, [][]string{{dom.Name, dom.UUID}})
+	}
+	return errors.New("Output format " + o.output + " is not supported for this command")
+}
+
+func domainList(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 0, 0)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	names, err := c.ListDomains(ctx)
+	if err != nil {
+		return err
+	}
+	return printList(o.output, "DOMAIN", "domainnames", names)
+}
+
+func domainDelete(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	return c.DeleteDomain(ctx, fs.Arg(0))
+}
+
+func secretGet(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 2, 2)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	sec, err := c.GetSecret(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	if getKey != "" {
+		v, ok := sec.Values[getKey]
+		if !ok {
+			return errors.New("Key " + getKey + " not found in secret " + sec.Name)
+		}
+		if o.output == "json" {
+			return printJSON(v)
+		}
+		// Binary values are written as they are, without a newline,
+		// so that they can be redirected to a file
+		if sec.Types[getKey] == smsclient.ValueBinary {
+			data, err := sec.Binary(getKey)
+			if err != nil {
+				return err
+			}
+			_, err = stdout.Write(data)
+			return err
+		}
+		fmt.Fprintln(stdout, valueString(v))
+		return nil
+	}
+
+	switch o.output {
+	case "json":
+		return printJSON(sec)
+	case "env":
+		return printEnv(sec.Values)
+	case "table":
+		rows := [][]string{}
+		for _, k := range sortedKeys(sec.Values) {
+			rows = append(rows, []string{k, valueString(sec.Values[k])})
+		}
+		return printTable([]string{"KEY", "VALUE"}, rows)
+	}
+	return errors.New("Output format " + o.output + " is not supported for this command")
+}
+
+// parseValues builds the secret values from key=value arguments.
+// A value of the form @path is read from the file at path. The type
+// of a value can be given as key:type=value, see parseTypedValue
+func parseValues(args []string) (map[string]interface{}, map[string]string, error) {
+	values := make(map[string]interface{})
+	types := make(map[string]string)
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, nil, errors.New("Invalid argument " + a + ". Expected key=value")
+		}
+		key, typ := kv[0], ""
+		if i := strings.LastIndex(key, ":"); i > 0 && valueTypes[key[i+1:]] {
+			key, typ = key[:i], key[i+1:]
+		}
+
+		data := []byte(kv[1])
+		if strings.HasPrefix(kv[1], "@") {
+			var err error
+			data, err = readInput(kv[1][1:])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		val, err := parseTypedValue(typ, data)
+		if err != nil {
+			return nil, nil, errors.New("Invalid value for " + key + ": " + err.Error())
+		}
+		values[key] = val
+		if typ != "" {
+			types[key] = typ
+		}
+	}
+	return values, types, nil
+}
+
+// valueTypes are the types that can be given in key:type=value
+var valueTypes = map[string]bool{
+	smsclient.ValueString: true,
+	smsclient.ValueNumber: true,
+	smsclient.ValueBool:   true,
+	smsclient.ValueBinary: true,
+	smsclient.ValueJSON:   true,
+}
+
+// parseTypedValue converts an argument to a value of the given type.
+// Binary data is base64 encoded, number, bool and json values are
+// parsed as JSON and anything else is kept as a string
+func parseTypedValue(typ string, data []byte) (interface{}, error) {
+	switch typ {
+	case smsclient.ValueBinary:
+		return base64.StdEncoding.EncodeToString(data), nil
+	case smsclient.ValueNumber, smsclient.ValueBool, smsclient.ValueJSON:
+		var v interface{}
+		err := json.Unmarshal(data, &v)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return string(data), nil
+}
+
+// readValuesFile reads secret values from a JSON file. Both a plain
+// object of values and the {"name": .., "values": {..}, "types": {..}}
+// form are accepted
+func readValuesFile(name string) (map[string]interface{}, map[string]string, error) {
+	data, err := readInput(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sec smsclient.Secret
+	err = json.Unmarshal(data, &sec)
+	if err == nil && sec.Values != nil {
+		return sec.Values, sec.Types, nil
+	}
+
+	values := make(map[string]interface{})
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, nil, errors.New("Parsing " + name + ": " + err.Error())
+	}
+	return values, nil, nil
+}
+
+func secretPut(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	types := make(map[string]string)
+	if putFile != "" {
+		var fileTypes map[string]string
+		values, fileTypes, err = readValuesFile(putFile)
+		if err != nil {
+			return err
+		}
+		for k, t := range fileTypes {
+			types[k] = t
+		}
+	}
+	argValues, argTypes, err := parseValues(fs.Args()[2:])
+	if err != nil {
+		return err
+	}
+	// Arguments replace values from the file together with their type
+	for k, v := range argValues {
+		values[k] = v
+		delete(types, k)
+	}
+	for k, t := range argTypes {
+		types[k] = t
+	}
+	if len(types) == 0 {
+		types = nil
+	}
+	if len(values) == 0 {
+		return errors.New("No values given for secret " + fs.Arg(1))
+	}
+
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	return c.CreateSecret(ctx, fs.Arg(0), smsclient.Secret{
+		Name:   fs.Arg(1),
+		Values: values,
+		Types:  types,
+	})
+}
+
+func secretList(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	names, err := c.ListSecrets(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printList(o.output, "SECRET", "secretnames", names)
+}
+
+func secretDelete(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 2, 2)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	return c.DeleteSecret(ctx, fs.Arg(0), fs.Arg(1))
+}
+
+func status(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 0, 0)
+	if err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	st, err := c.GetStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch o.output {
+	case "json":
+		return printJSON(st)
+	case "table":
+		return printTable([]string{"SEALED"}, [][]string{{strconv.FormatBool(st.Sealed)}})
+	}
+	return errors.New("Output format " + o.output + " is not supported for this command")
+}
+
+func unseal(o *options, fs *flag.FlagSet) error {
+	err := checkArgs(fs, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	shard := fs.Arg(0)
+	src := unsealFile
+	if shard == "-" {
+		src = "-"
+	}
+	if src != "" {
+		data, err := readInput(src)
+		if err != nil {
+			return err
+		}
+		shard = string(data)
+	}
+	shard = strings.TrimSpace(shard)
+	if shard == "" {
+		return errors.New("No unseal shard given")
+	}
+
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	return c.Unseal(ctx, shard)
+}
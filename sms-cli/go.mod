@@ -0,0 +1,5 @@
+module smscli
+
+require smsclient v0.0.0
+
+replace smsclient => ../sms-client/go
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// printJSON writes v as indented JSON
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(out))
+	return nil
+}
+
+// printTable writes rows as aligned columns under the header
+func printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+var envNameRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// envName converts a secret key into a shell variable name
+func envName(key string) string {
+	name := strings.ToUpper(envNameRe.ReplaceAllString(key, "_"))
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// printEnv writes values as shell assignments that can be sourced
+func printEnv(values map[string]interface{}) error {
+	for _, k := range sortedKeys(values) {
+		v := strings.Replace(valueString(values[k]), "'", `'\''`, -1)
+		fmt.Fprintf(stdout, "%s='%s'\n", envName(k), v)
+	}
+	return nil
+}
+
+// printList writes a list of names in the selected format
+func printList(format string, header string, key string, names []string) error {
+	sort.Strings(names)
+	switch format {
+	case "json":
+		return printJSON(map[string][]string{key: names})
+	case "table":
+		rows := make([][]string, len(names))
+		for i, n := range names {
+			rows[i] = []string{n}
+		}
+		return printTable([]string{header}, rows)
+	}
+	return errors.New("Output format " + format + " is not supported for this command")
+}
+
+// valueString formats a secret value for table and env output.
+// Strings are printed as is, everything else as JSON
+func valueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"smsclient"
+)
+
+// Streams used by the commands. Replaced in tests
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// profile stores the connection settings for an SMS instance.
+// Field names match the quorum client configuration
+type profile struct {
+	URL        string `json:"url"`
+	CAFile     string `json:"cafile"`
+	ClientCert string `json:"clientcert"`
+	ClientKey  string `json:"clientkey"`
+	ServerName string `json:"servername"`
+}
+
+// profilesFile is the format of the CLI configuration file
+type profilesFile struct {
+	Default  string             `json:"default"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// options are the flags shared by all commands
+type options struct {
+	configFile string
+	profile    string
+	output     string
+	timeout    time.Duration
+	conn       profile
+}
+
+// command is a CLI sub command such as "secret get"
+type command struct {
+	args  string
+	help  string
+	run   func(o *options, fs *flag.FlagSet) error
+	flags func(fs *flag.FlagSet)
+}
+
+func defaultConfigFile() string {
+	if f := os.Getenv("SMS_CLI_CONFIG"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sms", "config.json")
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", defaultConfigFile(), "Path to the CLI profiles file")
+	fs.StringVar(&o.profile, "profile", os.Getenv("SMS_PROFILE"), "Profile to use from the profiles file")
+	fs.StringVar(&o.output, "o", "table", "Output format: json, table or env")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "Timeout for the command")
+	fs.StringVar(&o.conn.URL, "url", "", "URL of the SMS service")
+	fs.StringVar(&o.conn.CAFile, "cacert", "", "Path to the CA Certificate file")
+	fs.StringVar(&o.conn.ClientCert, "cert", "", "Path to the client certificate")
+	fs.StringVar(&o.conn.ClientKey, "key", "", "Path to the client key")
+	fs.StringVar(&o.conn.ServerName, "servername", "", "Server name used to verify the SMS certificate")
+}
+
+// loadProfile reads the selected profile. Values given on the
+// command line take precedence over the profile
+func (o *options) loadProfile() (profile, error) {
+
+	p := profile{}
+	data, err := os.ReadFile(o.configFile)
+	if err != nil {
+		if o.profile != "" || !os.IsNotExist(err) {
+			return p, err
+		}
+	} else {
+		var pf profilesFile
+		err = json.Unmarshal(data, &pf)
+		if err != nil {
+			return p, errors.New("Parsing " + o.configFile + ": " + err.Error())
+		}
+
+		name := o.profile
+		if name == "" {
+			name = pf.Default
+		}
+		if name != "" {
+			var ok bool
+			p, ok = pf.Profiles[name]
+			if !ok {
+				return p, errors.New("Profile " + name + " not found in " + o.configFile)
+			}
+		}
+	}
+
+	override := func(dst *string, val string) {
+		if val != "" {
+			*dst = val
+		}
+	}
+	override(&p.URL, o.conn.URL)
+	override(&p.CAFile, o.conn.CAFile)
+	override(&p.ClientCert, o.conn.ClientCert)
+	override(&p.ClientKey, o.conn.ClientKey)
+	override(&p.ServerName, o.conn.ServerName)
+
+	if p.URL == "" {
+		return p, errors.New("No SMS URL configured. Use --url or a profile")
+	}
+	return p, nil
+}
+
+func (o *options) newClient() (*smsclient.Client, error) {
+
+	p, err := o.loadProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	return smsclient.NewClient(smsclient.Config{
+		URL:        p.URL,
+		CAFile:     p.CAFile,
+		ClientCert: p.ClientCert,
+		ClientKey:  p.ClientKey,
+		ServerName: p.ServerName,
+	})
+}
+
+func (o *options) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), o.timeout)
+}
+
+func usage() {
+	fmt.Fprintln(stderr, "Usage: sms <command> [flags] [arguments]")
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(stderr, 0, 4, 2, ' ', 0)
+	for _, n := range names {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", n, commands[n].args, commands[n].help)
+	}
+	tw.Flush()
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "Run 'sms <command> -h' for the flags of a command")
+}
+
+// run looks up the command from the first one or two arguments
+// and executes it. It returns the process exit code
+func run(args []string) int {
+
+	var name string
+	var cmd command
+	var ok bool
+	if len(args) >= 2 {
+		name = args[0] + " " + args[1]
+		cmd, ok = commands[name]
+		if ok {
+			args = args[2:]
+		}
+	}
+	if !ok && len(args) >= 1 {
+		name = args[0]
+		cmd, ok = commands[name]
+		args = args[1:]
+	}
+	if !ok {
+		usage()
+		return 2
+	}
+
+	o := &options{}
+	fs := flag.NewFlagSet("sms "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: sms %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	o.register(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	err = cmd.run(o, fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
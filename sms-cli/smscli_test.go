@@ -0,0 +1,218 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSMS records the requests made by the CLI and returns canned responses
+type fakeSMS struct {
+	secrets map[string]map[string]interface{}
+	types   map[string]map[string]string
+	shard   string
+}
+
+func (f *fakeSMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/sms/quorum/status":
+		w.Write([]byte(`{"sealstatus":true}`))
+	case r.URL.Path == "/v1/sms/quorum/unseal":
+		var body struct {
+			Shard string `json:"unsealshard"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.shard = body.Shard
+	case r.URL.Path == "/v1/sms/domain" && r.Method == "GET":
+		w.Write([]byte(`{"domainnames":["dom2","dom1"]}`))
+	case r.URL.Path == "/v1/sms/domain/dom1/secret" && r.Method == "POST":
+		var sec struct {
+			Name   string                 `json:"name"`
+			Values map[string]interface{} `json:"values"`
+			Types  map[string]string      `json:"types"`
+		}
+		json.NewDecoder(r.Body).Decode(&sec)
+		f.secrets[sec.Name] = sec.Values
+		if f.types != nil {
+			f.types[sec.Name] = sec.Types
+		}
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, "/v1/sms/domain/dom1/secret/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1/sms/domain/dom1/secret/")
+		vals, ok := f.secrets[name]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "values": vals, "types": f.types[name]})
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func runCLI(t *testing.T, input string, args ...string) (string, int) {
+	out := &bytes.Buffer{}
+	stdin = strings.NewReader(input)
+	stdout = out
+	stderr = ioutil.Discard
+	code := run(args)
+	return out.String(), code
+}
+
+func TestSecretPutGet(t *testing.T) {
+	f := &fakeSMS{secrets: make(map[string]map[string]interface{})}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	dir, _ := ioutil.TempDir("", "smscli")
+	defer os.RemoveAll(dir)
+	pwFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(pwFile, []byte("it's secret"), 0600)
+
+	_, code := runCLI(t, `{"port":"5432"}`, "secret", "put", "-url", srv.URL,
+		"-file", "-", "dom1", "db", "user=admin", "password=@"+pwFile)
+	if code != 0 {
+		t.Fatal("SecretPut: Unexpected exit code", code)
+	}
+	if f.secrets["db"]["port"] != "5432" || f.secrets["db"]["password"] != "it's secret" {
+		t.Fatal("SecretPut: Unexpected values stored", f.secrets["db"])
+	}
+
+	out, code := runCLI(t, "", "secret", "get", "-url", srv.URL, "-o", "env", "dom1", "db")
+	if code != 0 {
+		t.Fatal("SecretGet: Unexpected exit code", code)
+	}
+	expected := "PASSWORD='it'\\''s secret'\nPORT='5432'\nUSER='admin'\n"
+	if out != expected {
+		t.Fatal("SecretGet: Unexpected env output", out)
+	}
+
+	out, _ = runCLI(t, "", "secret", "get", "-url", srv.URL, "-field", "user", "dom1", "db")
+	if out != "admin\n" {
+		t.Fatal("SecretGet: Unexpected field output", out)
+	}
+
+	_, code = runCLI(t, "", "secret", "get", "-url", srv.URL, "dom1", "missing")
+	if code != 1 {
+		t.Fatal("SecretGet: Expected failure for missing secret")
+	}
+}
+
+func TestSecretPutTyped(t *testing.T) {
+	f := &fakeSMS{
+		secrets: make(map[string]map[string]interface{}),
+		types:   make(map[string]map[string]string),
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	dir, _ := ioutil.TempDir("", "smscli")
+	defer os.RemoveAll(dir)
+	jks := filepath.Join(dir, "truststore.jks")
+	ioutil.WriteFile(jks, []byte{0x00, 0xfe, 0xed}, 0600)
+
+	_, code := runCLI(t, "", "secret", "put", "-url", srv.URL, "dom1", "ks",
+		"store:binary=@"+jks, "port:number=8443", "enabled:bool=true", "conf:json={\"a\":1}", "url=a:b")
+	if code != 0 {
+		t.Fatal("SecretPut: Unexpected exit code", code)
+	}
+	vals := f.secrets["ks"]
+	if vals["store"] != "AP7t" || vals["port"] != 8443.0 || vals["enabled"] != true || vals["url"] != "a:b" {
+		t.Fatal("SecretPut: Unexpected values stored", vals)
+	}
+	if len(f.types["ks"]) != 4 || f.types["ks"]["store"] != "binary" {
+		t.Fatal("SecretPut: Unexpected types stored", f.types["ks"])
+	}
+
+	out, _ := runCLI(t, "", "secret", "get", "-url", srv.URL, "-field", "store", "dom1", "ks")
+	if out != "\x00\xfe\xed" {
+		t.Fatalf("SecretGet: Unexpected binary output %q", out)
+	}
+
+	_, code = runCLI(t, "", "secret", "put", "-url", srv.URL, "dom1", "ks", "port:number=abc")
+	if code != 1 {
+		t.Fatal("SecretPut: Expected failure for invalid number")
+	}
+}
+
+func TestDomainListProfile(t *testing.T) {
+	srv := httptest.NewServer(&fakeSMS{})
+	defer srv.Close()
+
+	dir, _ := ioutil.TempDir("", "smscli")
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(conf, []byte(`{"default": "bad",
+		"profiles": {"bad": {"url": "http://127.0.0.1:1"}, "test": {"url": "`+srv.URL+`"}}}`), 0600)
+
+	out, code := runCLI(t, "", "domain", "list", "-config", conf, "-profile", "test", "-o", "json")
+	if code != 0 {
+		t.Fatal("DomainList: Unexpected exit code", code)
+	}
+	var res struct {
+		Names []string `json:"domainnames"`
+	}
+	json.Unmarshal([]byte(out), &res)
+	if len(res.Names) != 2 || res.Names[0] != "dom1" {
+		t.Fatal("DomainList: Unexpected output", out)
+	}
+
+	// The url flag takes precedence over the default profile
+	_, code = runCLI(t, "", "domain", "list", "-config", conf, "-url", srv.URL)
+	if code != 0 {
+		t.Fatal("DomainList: Expected url flag to override profile")
+	}
+
+	_, code = runCLI(t, "", "domain", "list", "-config", conf, "-profile", "none")
+	if code != 1 {
+		t.Fatal("DomainList: Expected failure for unknown profile")
+	}
+}
+
+func TestStatusUnseal(t *testing.T) {
+	f := &fakeSMS{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	out, code := runCLI(t, "", "status", "-url", srv.URL)
+	if code != 0 || !strings.Contains(out, "true") {
+		t.Fatal("Status: Unexpected output", out)
+	}
+
+	_, code = runCLI(t, "myshard\n", "unseal", "-url", srv.URL, "-")
+	if code != 0 || f.shard != "myshard" {
+		t.Fatal("Unseal: Shard not read from stdin", f.shard)
+	}
+
+	_, code = runCLI(t, "", "unseal", "-url", srv.URL)
+	if code != 1 {
+		t.Fatal("Unseal: Expected failure without a shard")
+	}
+
+	_, code = runCLI(t, "", "bogus")
+	if code != 2 {
+		t.Fatal("Run: Expected usage error for unknown command")
+	}
+}
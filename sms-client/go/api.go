@@ -0,0 +1,326 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smsclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SecretDomain is where Secrets are stored.
+// It mirrors backend.SecretDomain in the SMS service
+type SecretDomain struct {
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// Metadata describes a secret or domain. Created, Updated and
+// ModifiedBy are set by SMS and ignored when sent. It mirrors
+// backend.Metadata in the SMS service
+type Metadata struct {
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Created     string            `json:"created,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	ModifiedBy  string            `json:"modifiedby,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
+}
+
+// Value types that can be declared in Secret.Types
+const (
+	ValueString = "string"
+	ValueNumber = "number"
+	ValueBool   = "bool"
+	ValueBinary = "binary"
+	ValueJSON   = "json"
+)
+
+// Secret consists of a name and map containing key value pairs.
+// Types optionally declares the type of a value. It mirrors
+// backend.Secret in the SMS service
+type Secret struct {
+	Name     string                 `json:"name"`
+	Values   map[string]interface{} `json:"values"`
+	Types    map[string]string      `json:"types,omitempty"`
+	Metadata *Metadata              `json:"metadata,omitempty"`
+}
+
+// SetBinary stores data as a binary value. It is sent base64 encoded
+func (s *Secret) SetBinary(key string, data []byte) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	if s.Types == nil {
+		s.Types = make(map[string]string)
+	}
+	s.Values[key] = base64.StdEncoding.EncodeToString(data)
+	s.Types[key] = ValueBinary
+}
+
+// Binary returns the decoded data of a binary value
+func (s Secret) Binary(key string) ([]byte, error) {
+	if s.Types[key] != ValueBinary {
+		return nil, errors.New("Value of " + key + " is not binary")
+	}
+	str, _ := s.Values[key].(string)
+	return base64.StdEncoding.DecodeString(str)
+}
+
+// Status is the seal status of the SMS backend
+type Status struct {
+	Sealed bool `json:"sealstatus"`
+}
+
+// versionHeader returns the If-Match header for a conditional request.
+// No header is sent when version is empty
+func versionHeader(version string) http.Header {
+	if version == "" {
+		return nil
+	}
+	return http.Header{"If-Match": {`"` + version + `"`}}
+}
+
+// responseVersion returns the version of a secret from the ETag header
+func responseVersion(header http.Header) string {
+	v := strings.TrimPrefix(header.Get("ETag"), "W/")
+	return strings.Trim(v, `"`)
+}
+
+func domainPath(dom string) string {
+	return "/v1/sms/domain/" + url.PathEscape(strings.TrimSpace(dom))
+}
+
+func secretPath(dom string, sec string) string {
+	return domainPath(dom) + "/secret/" + url.PathEscape(sec)
+}
+
+// GetStatus returns the seal status of the backend
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
+	var s Status
+	err := c.do(ctx, "GET", "/v1/sms/quorum/status", nil, &s)
+	return s, err
+}
+
+// Unseal sends an unseal shard to SMS. It is used by quorum clients
+func (c *Client) Unseal(ctx context.Context, shard string) error {
+	body := map[string]string{"unsealshard": shard}
+	return c.do(ctx, "POST", "/v1/sms/quorum/unseal", body, nil)
+}
+
+// RegisterQuorum registers a quorum client's PGP public key and
+// returns the shard encrypted with that key
+func (c *Client) RegisterQuorum(ctx context.Context, pgpKey string, quorumID string) (string, error) {
+	body := map[string]string{"pgpkey": pgpKey, "quorumid": quorumID}
+	var out struct {
+		Shard string `json:"shard"`
+	}
+	err := c.do(ctx, "POST", "/v1/sms/quorum/register", body, &out)
+	return out.Shard, err
+}
+
+// HealthCheck returns nil if SMS and its backend are ready for operations
+func (c *Client) HealthCheck(ctx context.Context) error {
+	return c.do(ctx, "GET", "/v1/sms/healthcheck", nil, nil)
+}
+
+// CreateDomain creates a secret domain with the given name
+func (c *Client) CreateDomain(ctx context.Context, name string) (SecretDomain, error) {
+	return c.CreateDomainWithMetadata(ctx, name, nil)
+}
+
+// CreateDomainWithMetadata creates a secret domain that is described
+// by meta
+func (c *Client) CreateDomainWithMetadata(ctx context.Context, name string, meta *Metadata) (SecretDomain, error) {
+	var d SecretDomain
+	err := c.do(ctx, "POST", "/v1/sms/domain", SecretDomain{Name: name, Metadata: meta}, &d)
+	return d, err
+}
+
+// ListDomains returns the names of all secret domains
+func (c *Client) ListDomains(ctx context.Context) ([]string, error) {
+	var out struct {
+		DomainNames []string `json:"domainnames"`
+	}
+	err := c.do(ctx, "GET", "/v1/sms/domain", nil, &out)
+	return out.DomainNames, err
+}
+
+// ListDomainsWithMetadata returns the domains that have all the given
+// labels, with their metadata. A label is given as key=value or as key
+// to select domains that have the label with any value
+func (c *Client) ListDomainsWithMetadata(ctx context.Context, labels ...string) ([]SecretDomain, error) {
+	query := url.Values{"metadata": {"true"}}
+	for _, l := range labels {
+		query.Add("label", l)
+	}
+	var out struct {
+		Domains []SecretDomain `json:"domains"`
+	}
+	err := c.do(ctx, "GET", "/v1/sms/domain?"+query.Encode(), nil, &out)
+	return out.Domains, err
+}
+
+// GetDomain returns a secret domain with its metadata
+func (c *Client) GetDomain(ctx context.Context, name string) (SecretDomain, error) {
+	var d SecretDomain
+	err := c.do(ctx, "GET", domainPath(name), nil, &d)
+	return d, err
+}
+
+// UpdateDomainMetadata replaces the metadata of a domain and returns
+// the updated domain
+func (c *Client) UpdateDomainMetadata(ctx context.Context, name string, meta Metadata) (SecretDomain, error) {
+	var d SecretDomain
+	err := c.do(ctx, "PATCH", domainPath(name), SecretDomain{Metadata: &meta}, &d)
+	return d, err
+}
+
+// DeleteDomain deletes a secret domain and all the secrets in it
+func (c *Client) DeleteDomain(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", domainPath(name), nil, nil)
+}
+
+// ExportDomain returns the secrets and metadata of a domain as a
+// bundle encrypted to pgpKey, a base64 encoded PGP public key
+func (c *Client) ExportDomain(ctx context.Context, name string, pgpKey string) (string, error) {
+	var out struct {
+		Bundle string `json:"bundle"`
+	}
+	query := url.Values{"pgpkey": {pgpKey}}
+	err := c.do(ctx, "GET", domainPath(name)+"/export?"+query.Encode(), nil, &out)
+	return out.Bundle, err
+}
+
+// ImportDomain stores the secrets of an exported bundle in a domain,
+// which is created if needed, and returns their number. The bundle
+// must be encrypted to the PGP key configured in SMS. Without
+// overwrite an error for which IsSecretExists is true is returned
+// when the domain already holds one of the secrets
+func (c *Client) ImportDomain(ctx context.Context, name string, bundle string, overwrite bool) (int, error) {
+	var out struct {
+		Imported int `json:"imported"`
+	}
+	path := domainPath(name) + "/import"
+	if overwrite {
+		path += "?overwrite=true"
+	}
+	err := c.do(ctx, "POST", path, map[string]string{"bundle": bundle}, &out)
+	return out.Imported, err
+}
+
+// CreateSecret stores a secret in the domain. An existing secret
+// with the same name is replaced
+func (c *Client) CreateSecret(ctx context.Context, dom string, sec Secret) error {
+	return c.do(ctx, "POST", domainPath(dom)+"/secret", sec, nil)
+}
+
+// ReplaceSecret stores a secret like CreateSecret and returns its new
+// version. When version is set the secret is only replaced if it is
+// still at that version; otherwise an error for which
+// IsVersionMismatch is true is returned
+func (c *Client) ReplaceSecret(ctx context.Context, dom string, sec Secret, version string) (string, error) {
+	header, err := c.send(ctx, "POST", domainPath(dom)+"/secret", versionHeader(version), sec, nil)
+	return responseVersion(header), err
+}
+
+// PatchSecret merges the values of patch into a secret. A value set to
+// nil removes the key. Metadata, when set, replaces the metadata of the
+// secret. version makes the update conditional like in ReplaceSecret.
+// The updated secret and its new version are returned
+func (c *Client) PatchSecret(ctx context.Context, dom string, name string,
+	patch Secret, version string) (Secret, string, error) {
+
+	var s Secret
+	header, err := c.send(ctx, "PATCH", secretPath(dom, name), versionHeader(version), patch, &s)
+	return s, responseVersion(header), err
+}
+
+// ListSecrets returns the names of all secrets in the domain
+func (c *Client) ListSecrets(ctx context.Context, dom string) ([]string, error) {
+	var out struct {
+		SecretNames []string `json:"secretnames"`
+	}
+	err := c.do(ctx, "GET", domainPath(dom)+"/secret", nil, &out)
+	return out.SecretNames, err
+}
+
+// GetSecret returns a secret with all its values
+func (c *Client) GetSecret(ctx context.Context, dom string, name string) (Secret, error) {
+	var s Secret
+	err := c.do(ctx, "GET", secretPath(dom, name), nil, &s)
+	return s, err
+}
+
+// GetSecretVersion returns a secret and its current version, which
+// can be passed to the conditional requests
+func (c *Client) GetSecretVersion(ctx context.Context, dom string, name string) (Secret, string, error) {
+	var s Secret
+	header, err := c.send(ctx, "GET", secretPath(dom, name), nil, nil, &s)
+	return s, responseVersion(header), err
+}
+
+// GetSecretKeys returns a secret with only the given keys. An error
+// for which IsNotFound is true is returned if any of them is missing
+func (c *Client) GetSecretKeys(ctx context.Context, dom string, name string, keys ...string) (Secret, error) {
+	var s Secret
+	query := url.Values{"keys": {strings.Join(keys, ",")}}
+	err := c.do(ctx, "GET", secretPath(dom, name)+"?"+query.Encode(), nil, &s)
+	return s, err
+}
+
+// GetSecretValue returns a single value of a secret
+func (c *Client) GetSecretValue(ctx context.Context, dom string, name string, key string) (interface{}, error) {
+	var out struct {
+		Value interface{} `json:"value"`
+	}
+	err := c.do(ctx, "GET", secretPath(dom, name)+"/key/"+url.PathEscape(key), nil, &out)
+	return out.Value, err
+}
+
+// DeleteSecret deletes a secret from the domain
+func (c *Client) DeleteSecret(ctx context.Context, dom string, name string) error {
+	return c.DeleteSecretVersion(ctx, dom, name, "")
+}
+
+// DeleteSecretFolder deletes every secret below a folder such as
+// db/primary and returns the names of the deleted secrets. They are
+// also returned when only some of the secrets could be deleted
+func (c *Client) DeleteSecretFolder(ctx context.Context, dom string, folder string) ([]string, error) {
+	var out struct {
+		Deleted []string `json:"deleted"`
+	}
+	err := c.do(ctx, "DELETE", secretPath(dom, folder)+"?recursive=true", nil, &out)
+	if apiErr, ok := err.(*APIError); ok {
+		// A partial failure reports the deleted secrets in the body
+		json.Unmarshal([]byte(apiErr.Message), &out)
+	}
+	return out.Deleted, err
+}
+
+// DeleteSecretVersion deletes a secret. version makes the delete
+// conditional like in ReplaceSecret
+func (c *Client) DeleteSecretVersion(ctx context.Context, dom string, name string, version string) error {
+	_, err := c.send(ctx, "DELETE", secretPath(dom, name), versionHeader(version), nil, nil)
+	return err
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smsclient
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// BatchResult is the outcome of one item of a batch request. Status
+// is the HTTP status the item would have had as a single request.
+// Version and Secret are set for secrets that were read or created
+// and Domain for created domains
+type BatchResult struct {
+	Name    string        `json:"name"`
+	Status  int           `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Version string        `json:"version,omitempty"`
+	Secret  *Secret       `json:"secret,omitempty"`
+	Domain  *SecretDomain `json:"domain,omitempty"`
+}
+
+// BatchResponse holds one result per requested item in request order.
+// Failed counts the items that did not succeed. RolledBack is set when
+// an atomic request failed and its changes were undone
+type BatchResponse struct {
+	Results    []BatchResult `json:"results"`
+	Failed     int           `json:"failed"`
+	RolledBack bool          `json:"rolledback"`
+}
+
+// batchRequest is the body of the batch endpoints
+type batchRequest struct {
+	Action  string   `json:"action"`
+	Atomic  bool     `json:"atomic,omitempty"`
+	Names   []string `json:"names,omitempty"`
+	Secrets []Secret `json:"secrets,omitempty"`
+}
+
+func batchSecretPath(dom string) string {
+	return "/v1/sms/batch/domain/" + url.PathEscape(strings.TrimSpace(dom)) + "/secret"
+}
+
+func (c *Client) batch(ctx context.Context, path string, req batchRequest) (BatchResponse, error) {
+	var out BatchResponse
+	err := c.do(ctx, "POST", path, req, &out)
+	return out, err
+}
+
+// CreateDomains creates many domains in one request. When atomic is
+// set either all domains are created or none
+func (c *Client) CreateDomains(ctx context.Context, names []string, atomic bool) (BatchResponse, error) {
+	return c.batch(ctx, "/v1/sms/batch/domain",
+		batchRequest{Action: "create", Atomic: atomic, Names: names})
+}
+
+// DeleteDomains deletes many domains and their secrets in one request.
+// When atomic is set either all domains are deleted or none
+func (c *Client) DeleteDomains(ctx context.Context, names []string, atomic bool) (BatchResponse, error) {
+	return c.batch(ctx, "/v1/sms/batch/domain",
+		batchRequest{Action: "delete", Atomic: atomic, Names: names})
+}
+
+// CreateSecrets stores many secrets of a domain in one request. When
+// atomic is set either all secrets are stored or none
+func (c *Client) CreateSecrets(ctx context.Context, dom string, secrets []Secret, atomic bool) (BatchResponse, error) {
+	return c.batch(ctx, batchSecretPath(dom),
+		batchRequest{Action: "create", Atomic: atomic, Secrets: secrets})
+}
+
+// GetSecrets reads many secrets of a domain in one request
+func (c *Client) GetSecrets(ctx context.Context, dom string, names []string) (BatchResponse, error) {
+	return c.batch(ctx, batchSecretPath(dom),
+		batchRequest{Action: "get", Names: names})
+}
+
+// DeleteSecrets deletes many secrets of a domain in one request. When
+// atomic is set either all secrets are deleted or none
+func (c *Client) DeleteSecrets(ctx context.Context, dom string, names []string, atomic bool) (BatchResponse, error) {
+	return c.batch(ctx, batchSecretPath(dom),
+		batchRequest{Action: "delete", Atomic: atomic, Names: names})
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package smsclient is a Go client for the Secret Management Service
+package smsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default values used when Config does not specify them
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultRetryCount   = 3
+	DefaultRetryWait    = 500 * time.Millisecond
+	DefaultMaxRetryWait = 10 * time.Second
+)
+
+// Config holds the settings used to create a Client
+type Config struct {
+	// URL of the SMS service such as https://aaf-sms.onap:10443
+	URL string
+	// CAFile is used to verify the server certificate.
+	// The system roots are used when it is empty
+	CAFile string
+	// ClientCert and ClientKey are presented to SMS for mutual TLS
+	ClientCert string
+	ClientKey  string
+	// ServerName overrides the name used to verify the server
+	// certificate, for example when connecting through a k8s service
+	ServerName string
+	// InsecureSkipVerify disables verification of the server
+	// certificate. Only use it for testing
+	InsecureSkipVerify bool
+
+	// Timeout for a single request attempt
+	Timeout time.Duration
+	// RetryCount is the number of retries after the first attempt.
+	// Use a negative value to disable retries
+	RetryCount int
+	// RetryWait is the wait before the first retry. It doubles on
+	// every retry up to MaxRetryWait
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+}
+
+// Client sends requests to the SMS REST API.
+// It is safe for concurrent use
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	retryCount   int
+	retryWait    time.Duration
+	maxRetryWait time.Duration
+}
+
+// APIError is returned when SMS responds with an error status
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s failed with %d %s: %s", e.Method, e.Path,
+		e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsDomainExists reports whether err was returned because the
+// domain being created already exists
+func IsDomainExists(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && strings.Contains(apiErr.Message, "existing domain")
+}
+
+// IsNotFound reports whether err was returned because the domain
+// or secret does not exist
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusNotFound ||
+		strings.Contains(strings.ToLower(apiErr.Message), "not found")
+}
+
+// IsSecretExists reports whether err was returned because an import
+// would replace existing secrets
+func IsSecretExists(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusConflict
+}
+
+// IsVersionMismatch reports whether err was returned because the
+// secret changed since the version given to a conditional request
+func IsVersionMismatch(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusPreconditionFailed
+}
+
+// NewClient creates a Client from cfg. Certificate files are read
+// immediately and an error is returned if any of them are unusable
+func NewClient(cfg Config) (*Client, error) {
+
+	baseURL, err := url.Parse(strings.TrimSpace(cfg.URL))
+	if err != nil {
+		return nil, err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, errors.New("URL must use http or https: " + cfg.URL)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+
+	if baseURL.Scheme == "https" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	c := &Client{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+		retryCount:   cfg.RetryCount,
+		retryWait:    cfg.RetryWait,
+		maxRetryWait: cfg.MaxRetryWait,
+	}
+
+	if c.httpClient.Timeout == 0 {
+		c.httpClient.Timeout = DefaultTimeout
+	}
+	if c.retryCount == 0 {
+		c.retryCount = DefaultRetryCount
+	} else if c.retryCount < 0 {
+		c.retryCount = 0
+	}
+	if c.retryWait == 0 {
+		c.retryWait = DefaultRetryWait
+	}
+	if c.maxRetryWait == 0 {
+		c.maxRetryWait = DefaultMaxRetryWait
+	}
+
+	return c, nil
+}
+
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("No certificates found in " + cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// isRetryable reports whether a response status indicates a
+// temporary problem with SMS or a proxy in front of it
+func isRetryable(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out. path must already be escaped.
+// Connection errors and temporary failures are retried with
+// exponential backoff until ctx is done
+func (c *Client) do(ctx context.Context, method string, path string,
+	body interface{}, out interface{}) error {
+
+	_, err := c.send(ctx, method, path, nil, body, out)
+	return err
+}
+
+// send works like do. It also sets the given request headers and
+// returns the headers of the response
+func (c *Client) send(ctx context.Context, method string, path string,
+	header http.Header, body interface{}, out interface{}) (http.Header, error) {
+
+	u, err := url.Parse(strings.TrimRight(c.baseURL.String(), "/") + path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wait := c.retryWait
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retryCount; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+			if wait > c.maxRetryWait {
+				wait = c.maxRetryWait
+			}
+		}
+
+		var reader io.Reader
+		if data != nil {
+			reader = bytes.NewReader(data)
+		}
+
+		req, err := http.NewRequest(method, u.String(), reader)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Accept", "application/json")
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		lastErr = c.handleResponse(req, resp, out)
+		if apiErr, ok := lastErr.(*APIError); ok && isRetryable(apiErr.StatusCode) {
+			continue
+		}
+		return resp.Header, lastErr
+	}
+
+	return nil, lastErr
+}
+
+func (c *Client) handleResponse(req *http.Request, resp *http.Response, out interface{}) error {
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,746 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smsclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMS is an in memory implementation of the SMS REST API
+type fakeSMS struct {
+	sync.Mutex
+	domains map[string]map[string]Secret
+}
+
+func newFakeSMS() *fakeSMS {
+	return &fakeSMS{domains: make(map[string]map[string]Secret)}
+}
+
+func (f *fakeSMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/sms/"), "/")
+	switch {
+	case r.URL.Path == "/v1/sms/quorum/status":
+		json.NewEncoder(w).Encode(Status{Sealed: false})
+	case r.URL.Path == "/v1/sms/healthcheck":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/v1/sms/quorum/register":
+		json.NewEncoder(w).Encode(map[string]string{"shard": "myshard"})
+	case r.URL.Path == "/v1/sms/quorum/unseal":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/v1/sms/domain" && r.Method == "POST":
+		var d SecretDomain
+		json.NewDecoder(r.Body).Decode(&d)
+		if _, ok := f.domains[d.Name]; ok {
+			http.Error(w, "existing domain", http.StatusInternalServerError)
+			return
+		}
+		f.domains[d.Name] = make(map[string]Secret)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SecretDomain{UUID: "123e4567-e89b-12d3-a456-426655440000", Name: d.Name})
+	case r.URL.Path == "/v1/sms/domain" && r.Method == "GET":
+		names := []string{}
+		for n := range f.domains {
+			names = append(names, n)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"domainnames": names})
+	case len(parts) == 2 && r.Method == "DELETE":
+		delete(f.domains, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && r.Method == "POST":
+		var s Secret
+		json.NewDecoder(r.Body).Decode(&s)
+		f.domains[parts[1]][s.Name] = s
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 3 && r.Method == "GET":
+		names := []string{}
+		for n := range f.domains[parts[1]] {
+			names = append(names, n)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"secretnames": names})
+	case len(parts) == 4 && r.Method == "GET":
+		s, ok := f.domains[parts[1]][parts[3]]
+		if !ok {
+			http.Error(w, "Secret not found at the provided path", http.StatusInternalServerError)
+			return
+		}
+		if keys := r.URL.Query().Get("keys"); keys != "" {
+			filtered := Secret{Name: s.Name, Values: map[string]interface{}{}}
+			for _, k := range strings.Split(keys, ",") {
+				v, ok := s.Values[k]
+				if !ok {
+					http.Error(w, "Keys not found in secret: "+k, http.StatusNotFound)
+					return
+				}
+				filtered.Values[k] = v
+			}
+			s = filtered
+		}
+		json.NewEncoder(w).Encode(s)
+	case len(parts) == 6 && parts[4] == "key" && r.Method == "GET":
+		v, ok := f.domains[parts[1]][parts[3]].Values[parts[5]]
+		if !ok {
+			http.Error(w, "Key not found in secret", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"key": parts[5], "value": v})
+	case len(parts) == 4 && r.Method == "DELETE":
+		delete(f.domains[parts[1]], parts[3])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	c, err := NewClient(Config{URL: url, RetryWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSecretLifecycle(t *testing.T) {
+	server := httptest.NewServer(newFakeSMS())
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	d, err := c.CreateDomain(ctx, "testdomain")
+	if err != nil || d.Name != "testdomain" || d.UUID == "" {
+		t.Fatalf("CreateDomain: Unexpected result %v %v", d, err)
+	}
+
+	_, err = c.CreateDomain(ctx, "testdomain")
+	if !IsDomainExists(err) {
+		t.Fatalf("CreateDomain: Expected existing domain error, got %v", err)
+	}
+
+	domains, err := c.ListDomains(ctx)
+	if err != nil || !reflect.DeepEqual(domains, []string{"testdomain"}) {
+		t.Fatalf("ListDomains: Unexpected result %v %v", domains, err)
+	}
+
+	sec := Secret{
+		Name:   "testsecret",
+		Values: map[string]interface{}{"name": "john", "isadmin": true},
+	}
+	err = c.CreateSecret(ctx, "testdomain", sec)
+	if err != nil {
+		t.Fatal("CreateSecret: Returned error: " + err.Error())
+	}
+
+	names, err := c.ListSecrets(ctx, "testdomain")
+	if err != nil || !reflect.DeepEqual(names, []string{"testsecret"}) {
+		t.Fatalf("ListSecrets: Unexpected result %v %v", names, err)
+	}
+
+	got, err := c.GetSecret(ctx, "testdomain", "testsecret")
+	if err != nil || !reflect.DeepEqual(got, sec) {
+		t.Fatalf("GetSecret: Unexpected result %v %v", got, err)
+	}
+
+	bin := Secret{Name: "binsecret"}
+	bin.SetBinary("jks", []byte{0x00, 0xfe, 0xed})
+	err = c.CreateSecret(ctx, "testdomain", bin)
+	if err != nil {
+		t.Fatal("CreateSecret: Returned error: " + err.Error())
+	}
+	got, err = c.GetSecret(ctx, "testdomain", "binsecret")
+	data, berr := got.Binary("jks")
+	if err != nil || berr != nil || !reflect.DeepEqual(data, []byte{0x00, 0xfe, 0xed}) {
+		t.Fatalf("Binary: Unexpected result %v %v %v", data, err, berr)
+	}
+	if _, err = got.Binary("missing"); err == nil {
+		t.Fatal("Binary: Expected error for value that is not binary")
+	}
+
+	val, err := c.GetSecretValue(ctx, "testdomain", "testsecret", "name")
+	if err != nil || val != "john" {
+		t.Fatalf("GetSecretValue: Unexpected result %v %v", val, err)
+	}
+
+	got, err = c.GetSecretKeys(ctx, "testdomain", "testsecret", "name")
+	if err != nil || !reflect.DeepEqual(got.Values, map[string]interface{}{"name": "john"}) {
+		t.Fatalf("GetSecretKeys: Unexpected result %v %v", got, err)
+	}
+
+	_, err = c.GetSecretKeys(ctx, "testdomain", "testsecret", "name", "missing")
+	if !IsNotFound(err) {
+		t.Fatalf("GetSecretKeys: Expected not found error, got %v", err)
+	}
+
+	_, err = c.GetSecretValue(ctx, "testdomain", "testsecret", "missing")
+	if !IsNotFound(err) {
+		t.Fatalf("GetSecretValue: Expected not found error, got %v", err)
+	}
+
+	err = c.DeleteSecret(ctx, "testdomain", "testsecret")
+	if err != nil {
+		t.Fatal("DeleteSecret: Returned error: " + err.Error())
+	}
+
+	_, err = c.GetSecret(ctx, "testdomain", "testsecret")
+	if !IsNotFound(err) {
+		t.Fatalf("GetSecret: Expected not found error, got %v", err)
+	}
+
+	err = c.DeleteDomain(ctx, "testdomain")
+	if err != nil {
+		t.Fatal("DeleteDomain: Returned error: " + err.Error())
+	}
+}
+
+func TestQuorumAPIs(t *testing.T) {
+	server := httptest.NewServer(newFakeSMS())
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	s, err := c.GetStatus(ctx)
+	if err != nil || s.Sealed {
+		t.Fatalf("GetStatus: Unexpected result %v %v", s, err)
+	}
+
+	shard, err := c.RegisterQuorum(ctx, "pgpkey", "123e4567-e89b-12d3-a456-426655440000")
+	if err != nil || shard != "myshard" {
+		t.Fatalf("RegisterQuorum: Unexpected result %v %v", shard, err)
+	}
+
+	err = c.Unseal(ctx, "myshard")
+	if err != nil {
+		t.Fatal("Unseal: Returned error: " + err.Error())
+	}
+
+	err = c.HealthCheck(ctx)
+	if err != nil {
+		t.Fatal("HealthCheck: Returned error: " + err.Error())
+	}
+}
+
+func TestRetryOnUnavailable(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, "sealed", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	err := c.HealthCheck(context.Background())
+	if err != nil {
+		t.Fatal("HealthCheck: Expected success after retries, got " + err.Error())
+	}
+	if calls != 3 {
+		t.Fatalf("HealthCheck: Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestNoRetryOnServerError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "Unable to create Secret Domain", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	_, err := c.CreateDomain(context.Background(), "testdomain")
+
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("CreateDomain: Expected APIError, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("CreateDomain: Expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "sealed", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(Config{URL: server.URL, RetryCount: 100, RetryWait: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = c.HealthCheck(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("HealthCheck: Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestPathEscaping(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL+"/prefix/")
+	c.DeleteSecret(context.Background(), "my domain", "a/b")
+
+	if gotPath != "/prefix/v1/sms/domain/my%20domain/secret/a%2Fb" {
+		t.Fatalf("DeleteSecret: Unexpected path %s", gotPath)
+	}
+}
+
+func TestNewClientErrors(t *testing.T) {
+	_, err := NewClient(Config{URL: "ftp://aaf-sms.onap"})
+	if err == nil {
+		t.Fatal("NewClient: Expected error for invalid scheme")
+	}
+
+	_, err = NewClient(Config{URL: "https://aaf-sms.onap:10443", CAFile: "filedoesnotexist.pem"})
+	if err == nil {
+		t.Fatal("NewClient: Expected error for missing CA file")
+	}
+}
+
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smsclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sms test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "aaf-sms.onap"},
+		DNSNames:     []string{"aaf-sms.onap"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "onap-component"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(newFakeSMS())
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	c, err := NewClient(Config{
+		URL:        server.URL,
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+		ServerName: "aaf-sms.onap",
+		RetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.HealthCheck(context.Background())
+	if err != nil {
+		t.Fatal("HealthCheck: mTLS request failed: " + err.Error())
+	}
+
+	noCert, err := NewClient(Config{
+		URL:        server.URL,
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		ServerName: "aaf-sms.onap",
+		RetryCount: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = noCert.HealthCheck(context.Background())
+	if err == nil {
+		t.Fatal("HealthCheck: Expected failure without client certificate")
+	}
+
+	insecure, err := NewClient(Config{
+		URL:                server.URL,
+		ClientCert:         filepath.Join(dir, "client.pem"),
+		ClientKey:          filepath.Join(dir, "client.key"),
+		InsecureSkipVerify: true,
+		RetryCount:         -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = insecure.HealthCheck(context.Background())
+	if err != nil {
+		t.Fatal("HealthCheck: Request without server verification failed: " + err.Error())
+	}
+}
+
+func TestDomainMetadata(t *testing.T) {
+	var gotMethod, gotQuery string
+	var gotBody SecretDomain
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotQuery = r.Method, r.URL.RawQuery
+		gotBody = SecretDomain{}
+		json.NewDecoder(r.Body).Decode(&gotBody)
+
+		d := SecretDomain{UUID: "123e4567-e89b-12d3-a456-426655440000", Name: "testdomain",
+			Metadata: &Metadata{Owner: "team-a", ModifiedBy: "onap-component"}}
+		if r.URL.Path == "/v1/sms/domain" && r.Method == "GET" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"domainnames": []string{d.Name}, "domains": []SecretDomain{d}})
+			return
+		}
+		json.NewEncoder(w).Encode(d)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	_, err := c.CreateDomainWithMetadata(ctx, "testdomain", &Metadata{Owner: "team-a"})
+	if err != nil || gotBody.Metadata == nil || gotBody.Metadata.Owner != "team-a" {
+		t.Fatalf("CreateDomainWithMetadata: Metadata not sent %v %v", gotBody, err)
+	}
+
+	d, err := c.GetDomain(ctx, "testdomain")
+	if err != nil || gotMethod != "GET" || d.Metadata == nil || d.Metadata.ModifiedBy != "onap-component" {
+		t.Fatalf("GetDomain: Unexpected result %v %v", d, err)
+	}
+
+	_, err = c.UpdateDomainMetadata(ctx, "testdomain", Metadata{Owner: "team-b"})
+	if err != nil || gotMethod != "PATCH" || gotBody.Metadata.Owner != "team-b" {
+		t.Fatalf("UpdateDomainMetadata: Unexpected request %s %v %v", gotMethod, gotBody, err)
+	}
+
+	doms, err := c.ListDomainsWithMetadata(ctx, "env=prod", "team")
+	if err != nil || len(doms) != 1 || doms[0].Metadata.Owner != "team-a" {
+		t.Fatalf("ListDomainsWithMetadata: Unexpected result %v %v", doms, err)
+	}
+	if gotQuery != "label=env%3Dprod&label=team&metadata=true" {
+		t.Fatalf("ListDomainsWithMetadata: Unexpected query %s", gotQuery)
+	}
+}
+
+func TestConditionalUpdates(t *testing.T) {
+	version := "v1"
+	var gotIfMatch []string
+	var gotPatch map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch := r.Header.Get("If-Match")
+		gotIfMatch = append(gotIfMatch, ifMatch)
+		if ifMatch != "" && ifMatch != `"`+version+`"` {
+			http.Error(w, "Secret version does not match", http.StatusPreconditionFailed)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("ETag", `"`+version+`"`)
+			json.NewEncoder(w).Encode(Secret{Name: "testsecret", Values: map[string]interface{}{"a": "1"}})
+		case "PATCH":
+			json.NewDecoder(r.Body).Decode(&gotPatch)
+			version = "v2"
+			w.Header().Set("ETag", `"`+version+`"`)
+			json.NewEncoder(w).Encode(Secret{Name: "testsecret", Values: map[string]interface{}{"b": "2"}})
+		case "POST":
+			version = "v3"
+			w.Header().Set("ETag", `W/"`+version+`"`)
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	_, v, err := c.GetSecretVersion(ctx, "testdomain", "testsecret")
+	if err != nil || v != "v1" {
+		t.Fatalf("GetSecretVersion: Unexpected result %s %v", v, err)
+	}
+
+	patch := Secret{Values: map[string]interface{}{"a": nil, "b": "2"}}
+	sec, v, err := c.PatchSecret(ctx, "testdomain", "testsecret", patch, v)
+	if err != nil || v != "v2" || sec.Values["b"] != "2" {
+		t.Fatalf("PatchSecret: Unexpected result %v %s %v", sec, v, err)
+	}
+	if a, ok := gotPatch["values"].(map[string]interface{})["a"]; !ok || a != nil {
+		t.Fatalf("PatchSecret: Removed key not sent as null %v", gotPatch)
+	}
+
+	_, _, err = c.PatchSecret(ctx, "testdomain", "testsecret", patch, "v1")
+	if !IsVersionMismatch(err) {
+		t.Fatalf("PatchSecret: Expected version mismatch, got %v", err)
+	}
+
+	v, err = c.ReplaceSecret(ctx, "testdomain", Secret{Name: "testsecret"}, "v2")
+	if err != nil || v != "v3" {
+		t.Fatalf("ReplaceSecret: Unexpected result %s %v", v, err)
+	}
+
+	err = c.DeleteSecretVersion(ctx, "testdomain", "testsecret", "v2")
+	if !IsVersionMismatch(err) {
+		t.Fatalf("DeleteSecretVersion: Expected version mismatch, got %v", err)
+	}
+
+	gotIfMatch = nil
+	err = c.DeleteSecret(ctx, "testdomain", "testsecret")
+	if err != nil || gotIfMatch[0] != "" {
+		t.Fatalf("DeleteSecret: Unexpected conditional delete %v %v", gotIfMatch, err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var gotPath string
+	var gotReq batchRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotReq = batchRequest{}
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(BatchResponse{
+			Results: []BatchResult{
+				{Name: "a", Status: http.StatusOK, Secret: &Secret{Name: "a"}},
+				{Name: "b", Status: http.StatusNotFound, Error: "Secret not found at the provided path"},
+			},
+			Failed: 1,
+		})
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	res, err := c.GetSecrets(ctx, "my domain", []string{"a", "b"})
+	if err != nil || res.Failed != 1 || res.Results[0].Secret == nil || res.Results[1].Status != http.StatusNotFound {
+		t.Fatalf("GetSecrets: Unexpected result %v %v", res, err)
+	}
+	if gotPath != "/v1/sms/batch/domain/my%20domain/secret" || gotReq.Action != "get" ||
+		!reflect.DeepEqual(gotReq.Names, []string{"a", "b"}) {
+		t.Fatalf("GetSecrets: Unexpected request %s %v", gotPath, gotReq)
+	}
+
+	_, err = c.CreateSecrets(ctx, "dom", []Secret{{Name: "a"}}, true)
+	if err != nil || gotReq.Action != "create" || !gotReq.Atomic || len(gotReq.Secrets) != 1 {
+		t.Fatalf("CreateSecrets: Unexpected request %v %v", gotReq, err)
+	}
+
+	_, err = c.DeleteSecrets(ctx, "dom", []string{"a"}, false)
+	if err != nil || gotReq.Action != "delete" || gotReq.Atomic {
+		t.Fatalf("DeleteSecrets: Unexpected request %v %v", gotReq, err)
+	}
+
+	_, err = c.CreateDomains(ctx, []string{"d1", "d2"}, true)
+	if err != nil || gotPath != "/v1/sms/batch/domain" || gotReq.Action != "create" {
+		t.Fatalf("CreateDomains: Unexpected request %s %v %v", gotPath, gotReq, err)
+	}
+
+	_, err = c.DeleteDomains(ctx, []string{"d1"}, false)
+	if err != nil || gotReq.Action != "delete" {
+		t.Fatalf("DeleteDomains: Unexpected request %v %v", gotReq, err)
+	}
+}
+
+func TestListSecretsPage(t *testing.T) {
+	var gotQueries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		gotQueries = append(gotQueries, q)
+		if q.Get("cursor") == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"secretnames": []string{"a", "b"},
+				"secrets":     []SecretInfo{{Name: "a", Owner: "team-a"}, {Name: "b"}},
+				"next":        "page2",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"secretnames": []string{"c"}})
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	opts := ListOptions{Prefix: "db", Labels: []string{"env=prod", "team"},
+		Sort: "-" + SortUpdated, Limit: 2, Metadata: true}
+	page, err := c.ListSecretsPage(ctx, "testdomain", opts)
+	if err != nil || !reflect.DeepEqual(page.Names, []string{"a", "b"}) ||
+		page.Secrets[0].Owner != "team-a" || page.Next != "page2" {
+		t.Fatalf("ListSecretsPage: Unexpected result %v %v", page, err)
+	}
+	expected := url.Values{"prefix": {"db"}, "label": {"env=prod", "team"},
+		"sort": {"-updated"}, "limit": {"2"}, "metadata": {"true"}}
+	if !reflect.DeepEqual(gotQueries[0], expected) {
+		t.Fatalf("ListSecretsPage: Unexpected query %v", gotQueries[0])
+	}
+
+	opts.Cursor = page.Next
+	page, err = c.ListSecretsPage(ctx, "testdomain", opts)
+	if err != nil || !reflect.DeepEqual(page.Names, []string{"c"}) || page.Next != "" {
+		t.Fatalf("ListSecretsPage: Unexpected last page %v %v", page, err)
+	}
+	if gotQueries[1].Get("cursor") != "page2" {
+		t.Fatalf("ListSecretsPage: Cursor not sent %v", gotQueries[1])
+	}
+}
+
+func TestSecretFolders(t *testing.T) {
+	var gotPath, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"secretnames": []string{"db/creds", "db/tls/"}})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deleted": []string{"db/creds"}, "error": "Unable to delete db/tls/cert"})
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	page, err := c.ListSecretsPage(ctx, "testdomain", ListOptions{Path: "db"})
+	if err != nil || len(page.Names) != 2 || gotQuery != "path=db" {
+		t.Fatalf("ListSecretsPage: Unexpected folder listing %v %s %v", page, gotQuery, err)
+	}
+
+	deleted, err := c.DeleteSecretFolder(ctx, "testdomain", "db")
+	if err == nil || !reflect.DeepEqual(deleted, []string{"db/creds"}) {
+		t.Fatalf("DeleteSecretFolder: Expected partial result, got %v %v", deleted, err)
+	}
+	if gotPath != "/v1/sms/domain/testdomain/secret/db" || gotQuery != "recursive=true" {
+		t.Fatalf("DeleteSecretFolder: Unexpected request %s?%s", gotPath, gotQuery)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	var gotQuery string
+	var gotBody map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/export"):
+			json.NewEncoder(w).Encode(map[string]string{"domain": "testdomain", "bundle": "encrypted"})
+		case r.URL.Query().Get("overwrite") != "true":
+			http.Error(w, "Secret already exists", http.StatusConflict)
+		default:
+			json.NewDecoder(r.Body).Decode(&gotBody)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"domain": "testdomain", "imported": 2})
+		}
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	bundle, err := c.ExportDomain(ctx, "testdomain", "a+b/c=")
+	if err != nil || bundle != "encrypted" || gotQuery != "pgpkey=a%2Bb%2Fc%3D" {
+		t.Fatalf("ExportDomain: Unexpected result %s %s %v", bundle, gotQuery, err)
+	}
+
+	_, err = c.ImportDomain(ctx, "testdomain", bundle, false)
+	if !IsSecretExists(err) {
+		t.Fatalf("ImportDomain: Expected conflict, got %v", err)
+	}
+
+	count, err := c.ImportDomain(ctx, "testdomain", bundle, true)
+	if err != nil || count != 2 || gotBody["bundle"] != "encrypted" {
+		t.Fatalf("ImportDomain: Unexpected result %d %v %v", count, gotBody, err)
+	}
+}
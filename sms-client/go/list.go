@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smsclient
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Fields secrets can be sorted by. A leading - reverses the order
+const (
+	SortName    = "name"
+	SortCreated = "created"
+	SortUpdated = "updated"
+)
+
+// ListOptions selects and orders one page of secrets. Path is the
+// folder to list, by default the root of the domain. Without Recursive
+// only the secrets directly in the folder and its subfolders, as names
+// ending in a slash, are returned. Prefix and Match apply to the full
+// path and Match is a glob pattern. Labels are
+// key=value or key selectors that secrets must all match. A Limit of
+// zero returns all remaining secrets. Cursor is the Next value of the
+// previous page. Metadata adds the version and metadata of each secret
+type ListOptions struct {
+	Path      string
+	Recursive bool
+	Prefix    string
+	Match     string
+	Labels    []string
+	Sort      string
+	Limit     int
+	Cursor    string
+	Metadata  bool
+}
+
+// SecretInfo describes a secret without its values.
+// It mirrors backend.SecretInfo in the SMS service
+type SecretInfo struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Created     string            `json:"created,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	ModifiedBy  string            `json:"modifiedby,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
+}
+
+// SecretList is one page of secrets. Secrets is only set when
+// ListOptions.Metadata was. Next is empty on the last page
+type SecretList struct {
+	Names   []string     `json:"secretnames"`
+	Secrets []SecretInfo `json:"secrets"`
+	Next    string       `json:"next"`
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	set := func(k string, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("path", o.Path)
+	if o.Recursive {
+		q.Set("recursive", "true")
+	}
+	set("prefix", o.Prefix)
+	set("match", o.Match)
+	set("sort", o.Sort)
+	set("cursor", o.Cursor)
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Metadata {
+		q.Set("metadata", "true")
+	}
+	for _, l := range o.Labels {
+		q.Add("label", l)
+	}
+	return q
+}
+
+// ListSecretsPage returns the page of secrets in the domain selected
+// by opts. Pass the Next value of the result as opts.Cursor to read
+// the following page
+func (c *Client) ListSecretsPage(ctx context.Context, dom string, opts ListOptions) (SecretList, error) {
+	path := domainPath(dom) + "/secret"
+	if q := opts.query(); len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out SecretList
+	err := c.do(ctx, "GET", path, nil, &out)
+	return out, err
+}
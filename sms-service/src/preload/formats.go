@@ -0,0 +1,754 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	pkgerrors "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+//Comment directives that set the domain and secret in .env files
+const (
+	envDomainDirective = "sms-domain:"
+	envSecretDirective = "sms-secret:"
+)
+
+//k8sDomainAnnotation selects the SMS domain for a Kubernetes Secret.
+//The namespace of the Secret is used when it is not set
+const k8sDomainAnnotation = "sms.onap.org/domain"
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+//isSourceFile reports whether preload can read the file
+func isSourceFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".env":
+		return true
+	}
+	return false
+}
+
+//processFile reads a JSON, YAML, Kubernetes Secret or .env file
+//and returns the domains it contains
+func processFile(name string) (DataJSON, error) {
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return DataJSON{}, pkgerrors.Cause(err)
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return parseJSONData(name, data)
+	case ".yaml", ".yml":
+		return parseYAMLData(name, data)
+	case ".env":
+		return parseEnvData(name, data)
+	}
+	return DataJSON{}, pkgerrors.New(name + ": unsupported file type")
+}
+
+//lineError names the file and the line of the problem. A line of 0
+//is unknown and left out
+func lineError(file string, line int, format string, args ...interface{}) error {
+	if line == 0 {
+		return pkgerrors.Errorf("%s: %s", file, fmt.Sprintf(format, args...))
+	}
+	return pkgerrors.Errorf("%s:%d: %s", file, line, fmt.Sprintf(format, args...))
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+//dataDocument, domainDocument and secretDocument describe a JSON or
+//YAML document in the DataJSON format. They remember their line so
+//that schema errors can name it
+type dataDocument struct {
+	Domain  *domainDocument  `json:"domain" yaml:"domain"`
+	Domains []domainDocument `json:"domains" yaml:"domains"`
+	line    int
+}
+
+type domainDocument struct {
+	Name    *string          `json:"name" yaml:"name"`
+	Secrets []secretDocument `json:"secrets" yaml:"secrets"`
+	line    int
+}
+
+type secretDocument struct {
+	Name   *string                `json:"name" yaml:"name"`
+	Values map[string]interface{} `json:"values" yaml:"values"`
+	line   int
+}
+
+func (d *domainDocument) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain domainDocument
+	d.line = yamlLine(unmarshal)
+	return unmarshal((*plain)(d))
+}
+
+func (s *secretDocument) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain secretDocument
+	s.line = yamlLine(unmarshal)
+	return unmarshal((*plain)(s))
+}
+
+//setLines sets the lines of a JSON document, its domains and their
+//secrets from the lines found by scanJSON
+func (d *dataDocument) setLines(lines map[string]int) {
+	d.line = lines[""]
+	if d.Domain != nil {
+		d.Domain.setLines(lines, "domain")
+	}
+	for i := range d.Domains {
+		d.Domains[i].setLines(lines, fmt.Sprintf("domains[%d]", i))
+	}
+}
+
+func (d *domainDocument) setLines(lines map[string]int, path string) {
+	d.line = lines[path]
+	for i := range d.Secrets {
+		d.Secrets[i].line = lines[fmt.Sprintf("%s.secrets[%d]", path, i)]
+	}
+}
+
+//k8sSecretYAML holds the fields preload reads from a Kubernetes
+//Secret. Other fields of the manifest are ignored
+type k8sSecretYAML struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   *k8sMetadataYAML       `yaml:"metadata"`
+	Data       map[string]yamlString  `yaml:"data"`
+	StringData map[string]string      `yaml:"stringData"`
+	Other      map[string]interface{} `yaml:",inline"`
+	line       int
+}
+
+type k8sMetadataYAML struct {
+	Name        *string                `yaml:"name"`
+	Namespace   string                 `yaml:"namespace"`
+	Annotations map[string]string      `yaml:"annotations"`
+	Other       map[string]interface{} `yaml:",inline"`
+	line        int
+}
+
+func (m *k8sMetadataYAML) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain k8sMetadataYAML
+	m.line = yamlLine(unmarshal)
+	return unmarshal((*plain)(m))
+}
+
+//yamlString is a string value together with its line
+type yamlString struct {
+	value string
+	line  int
+}
+
+func (s *yamlString) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s.line = yamlLine(unmarshal)
+	return unmarshal(&s.value)
+}
+
+//yamlLine returns the line of the node that unmarshal decodes, or 0
+//for an empty node. yaml.v2 only reports lines in type errors, so the
+//node is decoded into a func, which no node can be decoded into.
+//It must be called before unmarshal decodes the value, as the errors
+//of the probe reuse the memory of earlier type errors
+func yamlLine(unmarshal func(interface{}) error) int {
+	var probe func()
+	line := 0
+	if te, ok := unmarshal(&probe).(*yaml.TypeError); ok && len(te.Errors) > 0 {
+		fmt.Sscanf(te.Errors[0], "line %d:", &line)
+	}
+	return line
+}
+
+//yamlDocument is a document in the DataJSON format or a Kubernetes
+//Secret, told apart by the kind field
+type yamlDocument struct {
+	data   *dataDocument
+	secret *k8sSecretYAML
+}
+
+func (d *yamlDocument) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	var fields map[string]interface{}
+	err := unmarshal(&fields)
+	if err != nil {
+		return err
+	}
+	if _, ok := fields["kind"]; ok {
+		d.secret = &k8sSecretYAML{line: yamlLine(unmarshal)}
+		return unmarshal(d.secret)
+	}
+	d.data = &dataDocument{line: yamlLine(unmarshal)}
+	return unmarshal(d.data)
+}
+
+var yamlLineRe = regexp.MustCompile(`^(yaml: )?line (\d+): `)
+
+//yamlError formats a yaml.v2 error like lineError
+func yamlError(file string, msg string) error {
+	if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		return lineError(file, line, "%s", msg[len(m[0]):])
+	}
+	return pkgerrors.Errorf("%s: %s", file, strings.TrimPrefix(msg, "yaml: "))
+}
+
+//stringKeys converts the maps yaml decodes nested mappings into so
+//that values can be encoded as JSON
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = stringKeys(e)
+		}
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = stringKeys(e)
+		}
+		return t
+	}
+	return v
+}
+
+//parseYAMLData reads one or more YAML documents. Each document is either
+//in the DataJSON format or a Kubernetes Secret manifest
+func parseYAMLData(file string, data []byte) (DataJSON, error) {
+
+	ret := DataJSON{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.SetStrict(true)
+	for {
+		var parsed yamlDocument
+		err := dec.Decode(&parsed)
+		if err == io.EOF {
+			break
+		}
+		if te, ok := err.(*yaml.TypeError); ok {
+			var msgs []string
+			for _, e := range te.Errors {
+				msgs = append(msgs, yamlError(file, e).Error())
+			}
+			return DataJSON{}, pkgerrors.New(strings.Join(msgs, "; "))
+		}
+		if err != nil {
+			return DataJSON{}, yamlError(file, err.Error())
+		}
+
+		var domains []SecretDomainJSON
+		switch {
+		case parsed.secret != nil:
+			var dom SecretDomainJSON
+			dom, err = parseK8sSecret(file, parsed.secret)
+			domains = []SecretDomainJSON{dom}
+		case parsed.data != nil:
+			domains, err = parseDataDocument(file, parsed.data)
+		default:
+			continue
+		}
+		if err != nil {
+			return DataJSON{}, err
+		}
+		ret.Domains = append(ret.Domains, domains...)
+	}
+
+	if len(ret.Domains) == 0 {
+		return DataJSON{}, pkgerrors.New(file + ": no domain or domains found")
+	}
+	return ret, nil
+}
+
+//jsonField is an object key found by jsonScanner
+type jsonField struct {
+	parent string
+	key    string
+	line   int
+}
+
+//jsonScanner records the line of every value in a valid JSON document
+//by its path, such as domains[0].secrets[1], and the line of every
+//key. encoding/json only reports offsets for syntax and type errors
+type jsonScanner struct {
+	data   []byte
+	pos    int
+	line   int
+	lines  map[string]int
+	fields []jsonField
+}
+
+func scanJSON(data []byte) *jsonScanner {
+	s := &jsonScanner{data: data, line: 1, lines: make(map[string]int)}
+	s.value("")
+	return s
+}
+
+func (s *jsonScanner) skipSpace() {
+	for ; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\n':
+			s.line++
+		case ' ', '\t', '\r':
+		default:
+			return
+		}
+	}
+}
+
+func (s *jsonScanner) skipComma() {
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == ',' {
+		s.pos++
+	}
+}
+
+func (s *jsonScanner) str() string {
+	start := s.pos
+	for s.pos++; s.pos < len(s.data) && s.data[s.pos] != '"'; s.pos++ {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+	}
+	s.pos++
+
+	var str string
+	if s.pos <= len(s.data) {
+		json.Unmarshal(s.data[start:s.pos], &str)
+	}
+	return str
+}
+
+func (s *jsonScanner) value(path string) {
+
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return
+	}
+	s.lines[path] = s.line
+
+	switch s.data[s.pos] {
+	case '{':
+		s.pos++
+		for {
+			s.skipSpace()
+			if s.pos >= len(s.data) || s.data[s.pos] == '}' {
+				s.pos++
+				return
+			}
+			line := s.line
+			key := s.str()
+			s.skipSpace()
+			//Colon
+			s.pos++
+			s.fields = append(s.fields, jsonField{parent: path, key: key, line: line})
+			if path == "" {
+				s.value(key)
+			} else {
+				s.value(path + "." + key)
+			}
+			s.skipComma()
+		}
+	case '[':
+		s.pos++
+		for i := 0; ; i++ {
+			s.skipSpace()
+			if s.pos >= len(s.data) || s.data[s.pos] == ']' {
+				s.pos++
+				return
+			}
+			start := s.pos
+			s.value(fmt.Sprintf("%s[%d]", path, i))
+			s.skipComma()
+			if s.pos == start {
+				return
+			}
+		}
+	case '"':
+		s.str()
+	default:
+		for s.pos < len(s.data) && !strings.ContainsRune(",]} \t\r\n", rune(s.data[s.pos])) {
+			s.pos++
+		}
+	}
+}
+
+//offsetLine returns the line of a byte offset in data
+func offsetLine(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+//jsonStructPath matches the paths of the objects in the DataJSON
+//format, as opposed to secret values
+var jsonStructPath = regexp.MustCompile(`^((domain|domains\[\d+\])(\.secrets\[\d+\])?)?$`)
+
+//jsonError formats an encoding/json error like lineError
+func jsonError(file string, data []byte, err error) error {
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return lineError(file, offsetLine(data, e.Offset), "%s", e)
+	case *json.UnmarshalTypeError:
+		return lineError(file, offsetLine(data, e.Offset), "cannot unmarshal %s into %s", e.Value, e.Field)
+	}
+
+	//Unknown fields are reported without an offset. encoding/json
+	//stops at the first one, which is the first such key in the file
+	const unknown = "json: unknown field "
+	if strings.HasPrefix(err.Error(), unknown) {
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), unknown))
+		for _, f := range scanJSON(data).fields {
+			if f.key == field && jsonStructPath.MatchString(f.parent) {
+				return lineError(file, f.line, "field %s not found", field)
+			}
+		}
+	}
+	return pkgerrors.Errorf("%s: %s", file, err)
+}
+
+//parseJSONData reads a file in the DataJSON format. It is decoded with
+//encoding/json, as not all JSON is accepted by yaml.v2
+func parseJSONData(file string, data []byte) (DataJSON, error) {
+
+	//Syntax errors are reported before the schema is checked
+	var raw interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return DataJSON{}, jsonError(file, data, err)
+	}
+
+	var parsed dataDocument
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&parsed)
+	if err != nil {
+		return DataJSON{}, jsonError(file, data, err)
+	}
+	parsed.setLines(scanJSON(data).lines)
+
+	domains, err := parseDataDocument(file, &parsed)
+	if err != nil {
+		return DataJSON{}, err
+	}
+	return DataJSON{Domains: domains}, nil
+}
+
+//parseDataDocument validates a document in the DataJSON format
+func parseDataDocument(file string, data *dataDocument) ([]SecretDomainJSON, error) {
+
+	switch {
+	case data.Domain != nil && data.Domains != nil:
+		return nil, lineError(file, data.line, "use either \"domain\" or \"domains\", not both")
+	case data.Domain != nil:
+		dom, err := parseDomain(file, *data.Domain)
+		if err != nil {
+			return nil, err
+		}
+		return []SecretDomainJSON{dom}, nil
+	case data.Domains != nil:
+		if len(data.Domains) == 0 {
+			return nil, lineError(file, data.line, "\"domains\" must be a non empty list")
+		}
+		var ret []SecretDomainJSON
+		for _, d := range data.Domains {
+			dom, err := parseDomain(file, d)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, dom)
+		}
+		return ret, nil
+	}
+	return nil, lineError(file, data.line, "no \"domain\" or \"domains\" found")
+}
+
+//nameField returns name if it is set and not empty
+func nameField(file string, line int, name *string, what string) (string, error) {
+	if name == nil {
+		return "", lineError(file, line, "%s is missing \"name\"", what)
+	}
+	if strings.TrimSpace(*name) == "" {
+		return "", lineError(file, line, "%s \"name\" must be a non empty string", what)
+	}
+	return *name, nil
+}
+
+func parseDomain(file string, d domainDocument) (SecretDomainJSON, error) {
+
+	name, err := nameField(file, d.line, d.Name, "domain")
+	if err != nil {
+		return SecretDomainJSON{}, err
+	}
+
+	dom := SecretDomainJSON{Name: name}
+	seen := make(map[string]bool)
+	for _, s := range d.Secrets {
+		sec := SecretJSON{}
+		sec.Name, err = nameField(file, s.line, s.Name, "secret")
+		if err != nil {
+			return SecretDomainJSON{}, err
+		}
+		if seen[sec.Name] {
+			return SecretDomainJSON{}, lineError(file, s.line,
+				"secret %q is defined more than once in domain %q", sec.Name, dom.Name)
+		}
+		seen[sec.Name] = true
+
+		if len(s.Values) == 0 {
+			return SecretDomainJSON{}, lineError(file, s.line,
+				"secret %q must have a non empty \"values\" mapping", sec.Name)
+		}
+		sec.Values = stringKeys(s.Values).(map[string]interface{})
+		dom.Secrets = append(dom.Secrets, sec)
+	}
+
+	return dom, nil
+}
+
+//parseK8sSecret maps a Kubernetes Secret onto a domain with a single
+//secret. Values in data are base64 decoded, stringData takes precedence
+func parseK8sSecret(file string, s *k8sSecretYAML) (SecretDomainJSON, error) {
+
+	if s.Kind != "Secret" {
+		return SecretDomainJSON{}, lineError(file, s.line, "unsupported kind %q", s.Kind)
+	}
+	if s.APIVersion != "v1" {
+		return SecretDomainJSON{}, lineError(file, s.line, "Secret must have apiVersion v1, not %q", s.APIVersion)
+	}
+	if s.Metadata == nil {
+		return SecretDomainJSON{}, lineError(file, s.line, "Secret is missing \"metadata\"")
+	}
+
+	sec := SecretJSON{Values: make(map[string]interface{})}
+	name, err := nameField(file, s.Metadata.line, s.Metadata.Name, "metadata")
+	if err != nil {
+		return SecretDomainJSON{}, err
+	}
+	sec.Name = name
+
+	domain := s.Metadata.Namespace
+	if d, ok := s.Metadata.Annotations[k8sDomainAnnotation]; ok {
+		domain = d
+	}
+	if strings.TrimSpace(domain) == "" {
+		return SecretDomainJSON{}, lineError(file, s.Metadata.line,
+			"Secret %q needs a namespace or the %s annotation", name, k8sDomainAnnotation)
+	}
+
+	for k, v := range s.Data {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v.value))
+		if err != nil {
+			return SecretDomainJSON{}, lineError(file, v.line, "Secret %q: data %q is not valid base64", name, k)
+		}
+		if !utf8.Valid(decoded) {
+			return SecretDomainJSON{}, lineError(file, v.line, "Secret %q: data %q is not valid UTF-8 text", name, k)
+		}
+		sec.Values[k] = string(decoded)
+	}
+	for k, v := range s.StringData {
+		sec.Values[k] = v
+	}
+	if len(sec.Values) == 0 {
+		return SecretDomainJSON{}, lineError(file, s.line, "Secret %q has no data", name)
+	}
+
+	return SecretDomainJSON{Name: domain, Secrets: []SecretJSON{sec}}, nil
+}
+
+//parseEnvData reads KEY=VALUE lines. The domain is set with a
+//"# sms-domain: <name>" comment. The secret defaults to the file name
+//and can be changed with "# sms-secret: <name>" to store several
+//secrets in one file
+func parseEnvData(file string, data []byte) (DataJSON, error) {
+
+	var domains []SecretDomainJSON
+	var current *SecretJSON
+	currentLine := 0
+	domain := ""
+	secret := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	//flush stores the secret being read in its domain
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		if len(current.Values) == 0 {
+			return lineError(file, currentLine, "secret %q has no values", current.Name)
+		}
+		for i := range domains {
+			if domains[i].Name == domain {
+				domains[i].Secrets = append(domains[i].Secrets, *current)
+				current = nil
+				return nil
+			}
+		}
+		domains = append(domains, SecretDomainJSON{Name: domain, Secrets: []SecretJSON{*current}})
+		current = nil
+		return nil
+	}
+
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for i, l := range lines {
+		lineNo := i + 1
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		if strings.HasPrefix(l, "#") {
+			c := strings.TrimSpace(strings.TrimPrefix(l, "#"))
+			switch {
+			case strings.HasPrefix(c, envDomainDirective):
+				err := flush()
+				if err != nil {
+					return DataJSON{}, err
+				}
+				domain = strings.TrimSpace(strings.TrimPrefix(c, envDomainDirective))
+				if domain == "" {
+					return DataJSON{}, lineError(file, lineNo, "empty domain name")
+				}
+			case strings.HasPrefix(c, envSecretDirective):
+				err := flush()
+				if err != nil {
+					return DataJSON{}, err
+				}
+				secret = strings.TrimSpace(strings.TrimPrefix(c, envSecretDirective))
+				if secret == "" {
+					return DataJSON{}, lineError(file, lineNo, "empty secret name")
+				}
+			}
+			continue
+		}
+
+		l = strings.TrimPrefix(l, "export ")
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			return DataJSON{}, lineError(file, lineNo, "expected KEY=VALUE")
+		}
+		key := strings.TrimSpace(kv[0])
+		if !envKeyRe.MatchString(key) {
+			return DataJSON{}, lineError(file, lineNo, "invalid key %q", key)
+		}
+		val, err := parseEnvValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return DataJSON{}, lineError(file, lineNo, "%s", err)
+		}
+
+		if domain == "" {
+			return DataJSON{}, lineError(file, lineNo,
+				"no domain set. Add a \"# %s <name>\" line", envDomainDirective)
+		}
+		if current == nil {
+			if strings.TrimSpace(secret) == "" {
+				return DataJSON{}, lineError(file, lineNo,
+					"no secret set. Add a \"# %s <name>\" line", envSecretDirective)
+			}
+			currentLine = lineNo
+			current = &SecretJSON{Name: secret, Values: make(map[string]interface{})}
+		}
+		if _, ok := current.Values[key]; ok {
+			return DataJSON{}, lineError(file, lineNo, "duplicate key %q", key)
+		}
+		current.Values[key] = val
+	}
+
+	err := flush()
+	if err != nil {
+		return DataJSON{}, err
+	}
+	if len(domains) == 0 {
+		return DataJSON{}, pkgerrors.New(file + ": no values found")
+	}
+	return DataJSON{Domains: domains}, nil
+}
+
+//parseEnvValue removes quotes from a value. Single quoted values are
+//taken as is, double quoted values support \n, \t, \" and \\ escapes.
+//Unquoted values end at a " #" comment
+func parseEnvValue(v string) (string, error) {
+
+	if strings.HasPrefix(v, "'") {
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", pkgerrors.New("unterminated single quote")
+		}
+		if rest := strings.TrimSpace(v[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", pkgerrors.New("unexpected text after quoted value")
+		}
+		return v[1 : end+1], nil
+	}
+
+	if strings.HasPrefix(v, "\"") {
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			ch := v[i]
+			switch {
+			case ch == '\\' && i+1 < len(v):
+				i++
+				switch v[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(v[i])
+				}
+			case ch == '"':
+				if rest := strings.TrimSpace(v[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+					return "", pkgerrors.New("unexpected text after quoted value")
+				}
+				return b.String(), nil
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		return "", pkgerrors.New("unterminated double quote")
+	}
+
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v), nil
+}
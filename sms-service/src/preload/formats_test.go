@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSource(t *testing.T, dir string, name string, content string) string {
+	p := filepath.Join(dir, name)
+	err := ioutil.WriteFile(p, []byte(content), 0600)
+	if err != nil {
+		t.Fatal("WriteSource: Unable to write", p, err)
+	}
+	return p
+}
+
+func TestProcessFileFormats(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		domain  string
+		secret  string
+		values  map[string]interface{}
+	}{
+		{"sample.json", "{\n\t\"domain\": {\"name\": \"dom1\", \"secrets\": [\n\t\t{\"name\": \"db\", \"values\": {\"user\": \"admin\", \"ttl\": 3600}}]}\n}",
+			"dom1", "db", map[string]interface{}{"user": "admin", "ttl": 3600}},
+		{"escape.json", `{"domain": {"name": "dom6", "secrets": [{"name": "db", "values": {"url": "http:\/\/host", "smile": "\ud83d\ude00"}}]}}`,
+			"dom6", "db", map[string]interface{}{"url": "http://host", "smile": "\U0001F600"}},
+		{"sample.yaml", "domains:\n- name: dom2\n  secrets:\n  - name: db\n    values:\n      user: admin\n",
+			"dom2", "db", map[string]interface{}{"user": "admin"}},
+		{"nested.yaml", "domain:\n  name: dom5\n  secrets:\n  - name: db\n    values:\n      tls: {cert: c, ports: [{port: 1}]}\n",
+			"dom5", "db", map[string]interface{}{"tls": map[string]interface{}{"cert": "c",
+				"ports": []interface{}{map[string]interface{}{"port": 1}}}}},
+		{"secret.yml", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n  namespace: onap\n" +
+			"  annotations:\n    sms.onap.org/domain: dom3\ndata:\n  password: YWRtaW4=\nstringData:\n  user: admin\n",
+			"dom3", "db", map[string]interface{}{"user": "admin", "password": "admin"}},
+		{"db.env", "# sms-domain: dom4\nexport USER=admin # the user\nPASSWORD='a #b'\nMOTD=\"hi\\nthere\"\n",
+			"dom4", "db", map[string]interface{}{"USER": "admin", "PASSWORD": "a #b", "MOTD": "hi\nthere"}},
+	}
+
+	for _, tc := range tests {
+		d, err := processFile(writeSource(t, dir, tc.name, tc.content))
+		if err != nil {
+			t.Fatal("ProcessFile: Error reading", tc.name, err)
+		}
+		doms, err := d.domainList()
+		if err != nil || len(doms) != 1 || doms[0].Name != tc.domain {
+			t.Fatal("ProcessFile: Unexpected domains for", tc.name, doms)
+		}
+		if len(doms[0].Secrets) != 1 || doms[0].Secrets[0].Name != tc.secret {
+			t.Fatal("ProcessFile: Unexpected secrets for", tc.name, doms[0].Secrets)
+		}
+		values, _ := normalizeValues(doms[0].Secrets[0].Values)
+		expected, _ := normalizeValues(tc.values)
+		if len(changedKeys(expected, values)) != 0 {
+			t.Fatal("ProcessFile: Unexpected values for", tc.name, values)
+		}
+	}
+}
+
+func TestProcessFileMultipleDocuments(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+
+	p := writeSource(t, dir, "all.yaml", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: a\n  namespace: onap\n"+
+		"stringData:\n  k: v\n---\ndomain:\n  name: dom\n  secrets:\n  - name: b\n    values: {k: v}\n")
+	d, err := processFile(p)
+	if err != nil {
+		t.Fatal("ProcessFile: Error reading multiple documents", err)
+	}
+	if len(d.Domains) != 2 || d.Domains[0].Name != "onap" || d.Domains[1].Name != "dom" {
+		t.Fatal("ProcessFile: Unexpected domains", d.Domains)
+	}
+}
+
+func TestProcessFileErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"unknown.yaml", "domain:\n  name: dom\n  secret: []\n", "unknown.yaml:3: field secret not found"},
+		{"noname.json", "{\"domains\": [\n  {\"secrets\": []}\n]}", "noname.json:2: domain is missing \"name\""},
+		{"novalues.yaml", "domain:\n  name: dom\n  secrets:\n  - name: s\n    values: {}\n",
+			"novalues.yaml:4: secret \"s\" must have a non empty \"values\" mapping"},
+		{"dup.yaml", "domain:\n  name: dom\n  secrets:\n  - name: s\n    values: {k: v}\n  - name: s\n    values: {k: v}\n",
+			"dup.yaml:6: secret \"s\" is defined more than once"},
+		{"kind.yaml", "apiVersion: v1\nkind: ConfigMap\n", "kind.yaml:1: unsupported kind \"ConfigMap\""},
+		{"b64.yaml", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: s\n  namespace: n\ndata:\n  k: '%%%'\n",
+			"b64.yaml:7: Secret \"s\": data \"k\" is not valid base64"},
+		{"nons.yaml", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: s\nstringData:\n  k: v\n",
+			"nons.yaml:4: Secret \"s\" needs a namespace"},
+		{"dupkey.yaml", "domain:\n  name: dom\n  name: x\n", "dupkey.yaml:3: key \"name\" already set"},
+		{"type.yaml", "domain:\n  name: dom\n  secrets:\n  - name: s\n    values: [1]\n", "type.yaml:5: cannot unmarshal"},
+		{"later.yaml", "domain:\n  name: dom\n---\ndomains: []\n", "later.yaml:4: \"domains\" must be a non empty list"},
+		{"syntax.json", "{\"domain\": {\"name\": \"dom\",,}}", "syntax.json:1: invalid character ','"},
+		{"unknown.json", "{\"domain\": {\"name\": \"d\",\n \"secrets\": [{\"name\": \"s\", \"values\": {\"secret\": 1}}],\n \"secret\": 1}}",
+			"unknown.json:3: field secret not found"},
+		{"type.json", "{\"domain\": {\"name\": \"d\",\n \"secrets\": {}}}", "type.json:2: cannot unmarshal object"},
+		{"dup.json", "{\"domain\": {\"name\": \"d\", \"secrets\": [\n {\"name\": \"s\", \"values\": {\"k\": 1}},\n {\"name\": \"s\", \"values\": {\"k\": 1}}]}}",
+			"dup.json:3: secret \"s\" is defined more than once"},
+		{"nodomain.env", "\nKEY=value\n", "nodomain.env:2: no domain set"},
+		{"bad.env", "# sms-domain: d\nKEY value\n", "bad.env:2: expected KEY=VALUE"},
+		{"quote.env", "# sms-domain: d\nKEY=\"value\n", "quote.env:2: unterminated double quote"},
+		{"dupkey.env", "# sms-domain: d\nKEY=a\nKEY=b\n", "dupkey.env:3: duplicate key \"KEY\""},
+	}
+
+	for _, tc := range tests {
+		_, err := processFile(writeSource(t, dir, tc.name, tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatal("ProcessFile: Expected error", tc.expected, "got", err)
+		}
+	}
+}
+
+func TestParseEnvSecrets(t *testing.T) {
+	d, err := parseEnvData(".env", []byte("# sms-domain: d1\n# sms-secret: s1\nA=1\n"+
+		"# sms-secret: s2\nB=2\n# sms-domain: d2\n# sms-secret: s3\nC=3\n"))
+	if err != nil {
+		t.Fatal("ParseEnvData: Error parsing sections", err)
+	}
+	if len(d.Domains) != 2 || len(d.Domains[0].Secrets) != 2 || d.Domains[1].Secrets[0].Name != "s3" {
+		t.Fatal("ParseEnvData: Unexpected domains", d.Domains)
+	}
+
+	_, err = parseEnvData(".env", []byte("# sms-domain: d1\nA=1\n"))
+	if err == nil || !strings.Contains(err.Error(), "no secret set") {
+		t.Fatal("ParseEnvData: Expected error without a secret name", err)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+//generateKey marks a value as a generator directive, for example
+//{"$generate": "password", "length": 24, "charset": "alphanumeric"}
+const generateKey = "$generate"
+
+//publicKeySuffix is appended to the key of a keypair generator to
+//store the public key next to the private key
+const publicKeySuffix = ".pub"
+
+//generatorKinds lists the supported generators
+var generatorKinds = []string{"password", "hex", "base64", "uuid", "rsa", "ec"}
+
+//Character sets for the password generator
+var passwordCharsets = map[string]string{
+	"alpha":        "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"numeric":      "0123456789",
+	"alphanumeric": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"symbols":      "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#%+,-.:=@^_~",
+}
+
+//generator returns the directive stored in v, if any
+func generator(v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	_, ok = m[generateKey]
+	return m, ok
+}
+
+//hasGenerators reports whether any value is a generator directive
+func hasGenerators(values map[string]interface{}) bool {
+	for _, v := range values {
+		if _, ok := generator(v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//resolveGenerators replaces generator directives with generated values.
+//existing holds the values stored in SMS and is nil when the secret does
+//not exist yet. Values are only generated for new secrets; an existing
+//secret keeps its stored values so that re-running preload does not
+//change them, and a generated key it does not hold is reported
+func resolveGenerators(values map[string]interface{},
+	existing map[string]interface{}) (map[string]interface{}, error) {
+
+	ret := make(map[string]interface{}, len(values))
+	var keys []string
+	for k, v := range values {
+		if _, ok := generator(v); ok {
+			keys = append(keys, k)
+			continue
+		}
+		ret[k] = v
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		gen, _ := generator(values[k])
+		kind := fmt.Sprint(gen[generateKey])
+		if !containsString(generatorKinds, kind) {
+			return nil, pkgerrors.Errorf("Unknown generator %q for %s", kind, k)
+		}
+		pair := kind == "rsa" || kind == "ec"
+		if pair {
+			if _, ok := values[k+publicKeySuffix]; ok {
+				return nil, pkgerrors.Errorf("Key %s conflicts with the public key of %s",
+					k+publicKeySuffix, k)
+			}
+		}
+
+		if existing != nil {
+			old, ok := existing[k]
+			if !ok {
+				return nil, pkgerrors.Errorf("Stored secret has no value for generated key %s", k)
+			}
+			ret[k] = old
+			if pair {
+				oldPub, ok := existing[k+publicKeySuffix]
+				if !ok {
+					return nil, pkgerrors.Errorf("Stored secret has no value for generated key %s",
+						k+publicKeySuffix)
+				}
+				ret[k+publicKeySuffix] = oldPub
+			}
+			continue
+		}
+
+		priv, pub, err := generate(kind, gen)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "Generating "+k)
+		}
+		ret[k] = priv
+		if pair {
+			ret[k+publicKeySuffix] = pub
+		}
+	}
+
+	return ret, nil
+}
+
+//intOption reads a positive integer option from a directive
+func intOption(gen map[string]interface{}, name string, def int) (int, error) {
+	v, ok := gen[name]
+	if !ok {
+		return def, nil
+	}
+	var n int
+	switch t := v.(type) {
+	case int:
+		n = t
+	case float64:
+		n = int(t)
+		if float64(n) != t {
+			return 0, pkgerrors.Errorf("%s must be an integer", name)
+		}
+	default:
+		return 0, pkgerrors.Errorf("%s must be an integer", name)
+	}
+	if n <= 0 {
+		return 0, pkgerrors.Errorf("%s must be greater than 0", name)
+	}
+	return n, nil
+}
+
+//checkOptions rejects options that the generator does not use
+func checkOptions(gen map[string]interface{}, allowed ...string) error {
+	for k := range gen {
+		if k != generateKey && !containsString(allowed, k) {
+			return pkgerrors.Errorf("Unknown option %s for %s", k, gen[generateKey])
+		}
+	}
+	return nil
+}
+
+//generate creates a value for the directive. The second return value
+//is only set for keypairs and holds the public key
+func generate(kind string, gen map[string]interface{}) (string, string, error) {
+
+	switch kind {
+	case "password":
+		err := checkOptions(gen, "length", "charset")
+		if err != nil {
+			return "", "", err
+		}
+		length, err := intOption(gen, "length", 32)
+		if err != nil {
+			return "", "", err
+		}
+		charset := "alphanumeric"
+		if c, ok := gen["charset"]; ok {
+			charset = fmt.Sprint(c)
+		}
+		chars, ok := passwordCharsets[charset]
+		if !ok {
+			//Anything else is used as the list of allowed characters
+			chars = charset
+		}
+		if chars == "" {
+			return "", "", pkgerrors.New("charset must not be empty")
+		}
+		s, err := randomString(length, chars)
+		return s, "", err
+
+	case "hex", "base64":
+		err := checkOptions(gen, "bytes")
+		if err != nil {
+			return "", "", err
+		}
+		n, err := intOption(gen, "bytes", 32)
+		if err != nil {
+			return "", "", err
+		}
+		b := make([]byte, n)
+		_, err = rand.Read(b)
+		if err != nil {
+			return "", "", pkgerrors.Cause(err)
+		}
+		if kind == "hex" {
+			return hex.EncodeToString(b), "", nil
+		}
+		return base64.StdEncoding.EncodeToString(b), "", nil
+
+	case "uuid":
+		err := checkOptions(gen)
+		if err != nil {
+			return "", "", err
+		}
+		b := make([]byte, 16)
+		_, err = rand.Read(b)
+		if err != nil {
+			return "", "", pkgerrors.Cause(err)
+		}
+		//Version 4, variant RFC 4122
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), "", nil
+
+	case "rsa":
+		err := checkOptions(gen, "bits")
+		if err != nil {
+			return "", "", err
+		}
+		bits, err := intOption(gen, "bits", 2048)
+		if err != nil {
+			return "", "", err
+		}
+		if bits < 2048 {
+			return "", "", pkgerrors.New("bits must be at least 2048")
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return "", "", pkgerrors.Cause(err)
+		}
+		return encodeKeyPair(key, &key.PublicKey)
+
+	case "ec":
+		err := checkOptions(gen, "curve")
+		if err != nil {
+			return "", "", err
+		}
+		var curve elliptic.Curve
+		switch strings.ToUpper(fmt.Sprint(gen["curve"])) {
+		case "P256", "P-256", "<NIL>":
+			curve = elliptic.P256()
+		case "P384", "P-384":
+			curve = elliptic.P384()
+		case "P521", "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", "", pkgerrors.Errorf("Unsupported curve %v", gen["curve"])
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return "", "", pkgerrors.Cause(err)
+		}
+		return encodeKeyPair(key, &key.PublicKey)
+	}
+
+	return "", "", pkgerrors.Errorf("Unknown generator %q", kind)
+}
+
+//randomString picks length characters from chars with crypto/rand
+func randomString(length int, chars string) (string, error) {
+
+	runes := []rune(chars)
+	max := big.NewInt(int64(len(runes)))
+	ret := make([]rune, length)
+	for i := range ret {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", pkgerrors.Cause(err)
+		}
+		ret[i] = runes[n.Int64()]
+	}
+	return string(ret), nil
+}
+
+//encodeKeyPair returns the PKCS#8 private key and PKIX public key as PEM
+func encodeKeyPair(priv interface{}, pub interface{}) (string, string, error) {
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", "", pkgerrors.Cause(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", "", pkgerrors.Cause(err)
+	}
+
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return string(privPEM), string(pubPEM), nil
+}
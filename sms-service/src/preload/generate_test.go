@@ -0,0 +1,171 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		gen     map[string]interface{}
+		pattern string
+	}{
+		{map[string]interface{}{"$generate": "password"}, `^[a-zA-Z0-9]{32}$`},
+		{map[string]interface{}{"$generate": "password", "length": 8.0, "charset": "numeric"}, `^[0-9]{8}$`},
+		{map[string]interface{}{"$generate": "password", "length": 12, "charset": "ab"}, `^[ab]{12}$`},
+		{map[string]interface{}{"$generate": "hex", "bytes": 4}, `^[0-9a-f]{8}$`},
+		{map[string]interface{}{"$generate": "base64", "bytes": 3}, `^[A-Za-z0-9+/]{4}$`},
+		{map[string]interface{}{"$generate": "uuid"}, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+	}
+
+	for _, tc := range tests {
+		v, _, err := generate(tc.gen["$generate"].(string), tc.gen)
+		if err != nil {
+			t.Fatal("Generate: Error generating", tc.gen, err)
+		}
+		if !regexp.MustCompile(tc.pattern).MatchString(v) {
+			t.Fatal("Generate: Unexpected value", v, "for", tc.gen)
+		}
+	}
+
+	bad := []map[string]interface{}{
+		{"$generate": "password", "length": 0},
+		{"$generate": "password", "length": "ten"},
+		{"$generate": "hex", "length": 4},
+		{"$generate": "rsa", "bits": 1024},
+		{"$generate": "ec", "curve": "P224"},
+		{"$generate": "otp"},
+	}
+	for _, gen := range bad {
+		_, _, err := generate(gen["$generate"].(string), gen)
+		if err == nil {
+			t.Fatal("Generate: Expected error for", gen)
+		}
+	}
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	priv, pub, err := generate("ec", map[string]interface{}{"$generate": "ec", "curve": "P384"})
+	if err != nil {
+		t.Fatal("GenerateKeyPair: Error generating EC key", err)
+	}
+
+	block, _ := pem.Decode([]byte(priv))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatal("GenerateKeyPair: Private key is not PEM encoded")
+	}
+	_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal("GenerateKeyPair: Unable to parse private key", err)
+	}
+
+	block, _ = pem.Decode([]byte(pub))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatal("GenerateKeyPair: Public key is not PEM encoded")
+	}
+	_, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal("GenerateKeyPair: Unable to parse public key", err)
+	}
+}
+
+func TestResolveGenerators(t *testing.T) {
+	values := map[string]interface{}{
+		"user":     "admin",
+		"password": map[string]interface{}{"$generate": "password"},
+		"key":      map[string]interface{}{"$generate": "ec"},
+	}
+
+	res, err := resolveGenerators(values, nil)
+	if err != nil {
+		t.Fatal("ResolveGenerators: Error resolving", err)
+	}
+	if res["user"] != "admin" || len(res["password"].(string)) != 32 || res["key.pub"] == nil {
+		t.Fatal("ResolveGenerators: Unexpected values", res)
+	}
+	if _, ok := values["key.pub"]; ok {
+		t.Fatal("ResolveGenerators: Input values should not be modified")
+	}
+
+	existing := map[string]interface{}{"password": "stored", "key": "storedkey", "key.pub": "storedpub"}
+	res, err = resolveGenerators(values, existing)
+	if err != nil {
+		t.Fatal("ResolveGenerators: Error resolving with existing values", err)
+	}
+	if res["password"] != "stored" || res["key"] != "storedkey" || res["key.pub"] != "storedpub" {
+		t.Fatal("ResolveGenerators: Existing values should be kept", res)
+	}
+
+	delete(existing, "key.pub")
+	_, err = resolveGenerators(values, existing)
+	if err == nil || !strings.Contains(err.Error(), "key.pub") {
+		t.Fatal("ResolveGenerators: Expected error for keypair without public key", err)
+	}
+	_, err = resolveGenerators(values, map[string]interface{}{})
+	if err == nil {
+		t.Fatal("ResolveGenerators: Values should never be generated for existing secrets")
+	}
+
+	values["key.pub"] = "literal"
+	_, err = resolveGenerators(values, nil)
+	if err == nil {
+		t.Fatal("ResolveGenerators: Expected error for conflicting public key")
+	}
+}
+
+func TestUploadGeneratedStable(t *testing.T) {
+	f := &fakeSMS{domains: make(map[string]map[string]map[string]interface{})}
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	data := DataJSON{Domain: SecretDomainJSON{Name: "dom", Secrets: []SecretJSON{
+		{Name: "db", Values: map[string]interface{}{
+			"password": map[string]interface{}{"$generate": "password", "length": 16},
+		}},
+	}}}
+
+	err := c.uploadToSMS(data)
+	if err != nil {
+		t.Fatal("UploadToSMS: Error uploading", err)
+	}
+	first := f.domains["dom"]["db"]["password"]
+	if s, ok := first.(string); !ok || len(s) != 16 {
+		t.Fatal("UploadToSMS: Password not generated", first)
+	}
+
+	err = c.uploadToSMS(data)
+	if err != nil {
+		t.Fatal("UploadToSMS: Error uploading again", err)
+	}
+	if f.domains["dom"]["db"]["password"] != first {
+		t.Fatal("UploadToSMS: Generated password changed on re-run")
+	}
+
+	f.domains["dom"]["db"] = map[string]interface{}{"user": "admin"}
+	err = c.uploadToSMS(data)
+	if err == nil {
+		t.Fatal("UploadToSMS: Expected error for stored secret without the generated key")
+	}
+	if _, ok := f.domains["dom"]["db"]["password"]; ok {
+		t.Fatal("UploadToSMS: Password generated for an existing secret")
+	}
+}
@@ -1,5 +1,11 @@
 module preload
 
-require github.com/pkg/errors v0.8.0
+require (
+	github.com/pkg/errors v0.8.0
+	gopkg.in/yaml.v2 v2.2.1
+	smsclient v0.0.0
+)
 
 replace sms => ../sms
+
+replace smsclient => ../../../sms-client/go
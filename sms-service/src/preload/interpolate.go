@@ -0,0 +1,172 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+//filePrefix marks a value that is read from a file, e.g. "@file:/run/secrets/pw".
+//Use "@@file:" for a literal value starting with "@file:"
+const filePrefix = "@file:"
+
+//interpolator resolves ${VAR} and @file: references in secret values
+type interpolator struct {
+	//strict fails on variables that are not set instead of
+	//replacing them with an empty string
+	strict bool
+	lookup func(string) (string, bool)
+}
+
+func newInterpolator(strict bool) *interpolator {
+	return &interpolator{strict: strict, lookup: os.LookupEnv}
+}
+
+//interpolateData resolves the references in all secrets of d
+func (ip *interpolator) interpolateData(d DataJSON) (DataJSON, error) {
+
+	domains, err := d.domainList()
+	if err != nil {
+		return DataJSON{}, err
+	}
+
+	ret := DataJSON{}
+	for _, dom := range domains {
+		newDom := SecretDomainJSON{Name: dom.Name}
+		for _, s := range dom.Secrets {
+			values, err := ip.value(s.Values)
+			if err != nil {
+				return DataJSON{}, pkgerrors.Wrapf(err, "Domain %s secret %s",
+					strings.TrimSpace(dom.Name), s.Name)
+			}
+			m, _ := values.(map[string]interface{})
+			newDom.Secrets = append(newDom.Secrets, SecretJSON{Name: s.Name, Values: m})
+		}
+		ret.Domains = append(ret.Domains, newDom)
+	}
+	return ret, nil
+}
+
+//value resolves references in strings nested anywhere in v
+func (ip *interpolator) value(v interface{}) (interface{}, error) {
+
+	switch t := v.(type) {
+	case string:
+		return ip.str(t)
+	case map[string]interface{}:
+		if t == nil {
+			return t, nil
+		}
+		ret := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			r, err := ip.value(item)
+			if err != nil {
+				return nil, pkgerrors.Wrap(err, "Key "+k)
+			}
+			ret[k] = r
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, item := range t {
+			r, err := ip.value(item)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = r
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+//str resolves a single string. A value starting with @file: is replaced
+//by the file contents without its trailing newline
+func (ip *interpolator) str(s string) (string, error) {
+
+	if strings.HasPrefix(s, "@"+filePrefix) {
+		return s[1:], nil
+	}
+
+	if strings.HasPrefix(s, filePrefix) {
+		path, err := ip.expand(strings.TrimPrefix(s, filePrefix))
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return "", pkgerrors.Cause(err)
+		}
+		ret := strings.TrimSuffix(string(data), "\n")
+		return strings.TrimSuffix(ret, "\r"), nil
+	}
+
+	return ip.expand(s)
+}
+
+//expand replaces ${VAR} and ${VAR:-default} references.
+//$${ is kept as a literal ${
+func (ip *interpolator) expand(s string) (string, error) {
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", pkgerrors.New("Unterminated ${ in value")
+		}
+		b.WriteString(s[:i])
+
+		ref := s[i+2 : i+end]
+		name, def, hasDef := ref, "", false
+		if j := strings.Index(ref, ":-"); j >= 0 {
+			name, def, hasDef = ref[:j], ref[j+2:], true
+		}
+		if name == "" {
+			return "", pkgerrors.New("Empty variable name in value")
+		}
+
+		val, ok := ip.lookup(name)
+		switch {
+		case ok:
+			b.WriteString(val)
+		case hasDef:
+			b.WriteString(def)
+		case ip.strict:
+			return "", pkgerrors.Errorf("Environment variable %s is not set", name)
+		default:
+			fmt.Println("Environment variable", name, "is not set. Using an empty value")
+		}
+		s = s[i+end+1:]
+	}
+}
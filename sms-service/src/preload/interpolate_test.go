@@ -0,0 +1,106 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testInterpolator(strict bool) *interpolator {
+	env := map[string]string{"USER": "admin", "EMPTY": ""}
+	return &interpolator{strict: strict, lookup: func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}}
+}
+
+func TestExpand(t *testing.T) {
+	tests := map[string]string{
+		"${USER}":            "admin",
+		"user=${USER}!":      "user=admin!",
+		"${EMPTY:-default}":  "",
+		"${MISSING:-x:y}":    "x:y",
+		"$${USER}":           "${USER}",
+		"pa$$word":           "pa$$word",
+		"$USER":              "$USER",
+		"${USER}${USER}":     "adminadmin",
+		"${MISSING}and more": "and more",
+	}
+
+	ip := testInterpolator(false)
+	for in, expected := range tests {
+		out, err := ip.expand(in)
+		if err != nil || out != expected {
+			t.Fatal("Expand: Expected", expected, "for", in, "got", out, err)
+		}
+	}
+
+	_, err := testInterpolator(true).expand("${MISSING}")
+	if err == nil {
+		t.Fatal("Expand: Expected error for missing variable in strict mode")
+	}
+	_, err = ip.expand("${USER")
+	if err == nil {
+		t.Fatal("Expand: Expected error for unterminated reference")
+	}
+}
+
+func TestInterpolateData(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+	pw := filepath.Join(dir, "password")
+	ioutil.WriteFile(pw, []byte("s3cret\n"), 0600)
+
+	d := DataJSON{Domain: SecretDomainJSON{Name: "dom", Secrets: []SecretJSON{
+		{Name: "db", Values: map[string]interface{}{
+			"user":     "${USER}",
+			"password": "@file:" + pw,
+			"literal":  "@@file:/etc/passwd",
+			"ttl":      3600,
+			"nested":   map[string]interface{}{"list": []interface{}{"${USER}"}},
+		}},
+	}}}
+
+	res, err := testInterpolator(true).interpolateData(d)
+	if err != nil {
+		t.Fatal("InterpolateData: Error resolving references", err)
+	}
+
+	expected := map[string]interface{}{
+		"user":     "admin",
+		"password": "s3cret",
+		"literal":  "@file:/etc/passwd",
+		"ttl":      3600,
+		"nested":   map[string]interface{}{"list": []interface{}{"admin"}},
+	}
+	if len(res.Domains) != 1 || !reflect.DeepEqual(res.Domains[0].Secrets[0].Values, expected) {
+		t.Fatal("InterpolateData: Unexpected values", res.Domains)
+	}
+	if d.Domain.Secrets[0].Values["user"] != "${USER}" {
+		t.Fatal("InterpolateData: Input should not be modified")
+	}
+
+	d.Domain.Secrets[0].Values["password"] = "@file:" + filepath.Join(dir, "missing")
+	_, err = testInterpolator(false).interpolateData(d)
+	if err == nil {
+		t.Fatal("InterpolateData: Expected error for missing file")
+	}
+}
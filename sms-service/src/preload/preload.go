@@ -17,21 +17,18 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	pkgerrors "github.com/pkg/errors"
+	"smsclient"
 )
 
 //DataJSON stores a list of domains from JSON file
@@ -54,263 +51,106 @@ type SecretJSON struct {
 	Values map[string]interface{} `json:"values"`
 }
 
-//Processes the JSON file and returns a DataJSON struct
-func processJSONFile(name string) (DataJSON, error) {
-
-	data, err := ioutil.ReadFile(name)
-	if err != nil {
-		return DataJSON{}, pkgerrors.Cause(err)
-	}
-
-	d := DataJSON{}
-	err = json.Unmarshal(data, &d)
-	if err != nil {
-		return DataJSON{}, pkgerrors.Cause(err)
-	}
-
-	return d, nil
-}
-
+//smsClient uploads secrets to SMS. Requests, TLS and retries are
+//handled by the smsclient package
 type smsClient struct {
-	BaseURL *url.URL
-	//In seconds
-	Timeout    int
-	CaCertPath string
-	RetryCount int
-
-	httpClient *http.Client
+	sms *smsclient.Client
 }
 
-func (c *smsClient) init() error {
+//newSMSClient creates the client used to upload to SMS. Unless insecure
+//is set, the SMS certificate is verified and an unusable CA is an error
+func newSMSClient(cfg smsclient.Config, insecure bool) (*smsClient, error) {
 
-	skipVerify := false
-	caCert, err := ioutil.ReadFile(c.CaCertPath)
-	if err != nil {
-		fmt.Println(pkgerrors.Cause(err))
+	if insecure {
 		fmt.Println("Using Insecure Server Verification")
-		skipVerify = true
-	}
-
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS12,
-	}
-
-	tlsConfig.InsecureSkipVerify = skipVerify
-
-	// Add cert information when skipVerify is false
-	if skipVerify == false {
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		tlsConfig.RootCAs = caCertPool
-	}
-
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-
-	c.httpClient = &http.Client{
-		Transport: tr,
-		Timeout:   time.Duration(c.Timeout) * time.Second,
-	}
-
-	return nil
-}
-
-func (c *smsClient) resolveURL(relURL string) (*url.URL, error) {
-
-	rel, err := url.Parse(relURL)
-	if err != nil {
-		return nil, pkgerrors.Cause(err)
-	}
-
-	return c.BaseURL.ResolveReference(rel), nil
-
-}
-
-func (c *smsClient) sendGetRequest(relURL string) (map[string]interface{}, error) {
-
-	u, err := c.resolveURL(relURL)
-	if err != nil {
-		return nil, pkgerrors.Cause(err)
-	}
-
-	resp, err := c.httpClient.Get(u.String())
-	if err != nil {
-		return nil, pkgerrors.Cause(err)
-	}
-
-	if resp.StatusCode >= 400 && resp.StatusCode < 600 {
-		// Request Failed
-		errText, _ := ioutil.ReadAll(resp.Body)
-		return nil, pkgerrors.Errorf("Request Failed with: %s and Error: %s",
-			resp.Status, string(errText))
-	}
-
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return nil, pkgerrors.Cause(err)
-	}
-
-	return result, nil
-}
-
-func (c *smsClient) sendPostRequest(relURL string, message map[string]interface{}) error {
-
-	u, err := c.resolveURL(relURL)
-	if err != nil {
-		return pkgerrors.Cause(err)
-	}
-
-	body, err := json.Marshal(message)
-	if err != nil {
-		return pkgerrors.Cause(err)
-	}
-
-	resp, err := c.httpClient.Post(u.String(), "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return pkgerrors.Cause(err)
+		cfg.CAFile = ""
+		cfg.InsecureSkipVerify = true
 	}
 
-	if resp.StatusCode >= 400 && resp.StatusCode < 600 {
-		// Request Failed
-		errText, _ := ioutil.ReadAll(resp.Body)
-		return pkgerrors.Errorf("Request Failed with: %s and Error: %s",
-			resp.Status, string(errText))
-	}
-
-	return nil
-}
-
-func (c *smsClient) createDomain(domain string) error {
-
-	message := map[string]interface{}{
-		"name": domain,
-	}
-	url := "/v1/sms/domain"
-	err := c.sendPostRequest(url, message)
+	sms, err := smsclient.NewClient(cfg)
 	if err != nil {
-		if strings.Contains(err.Error(), "existing domain") {
-			fmt.Println("Domain ", domain, " already exists...")
-			return nil
-		}
-		return pkgerrors.Cause(err)
+		return nil, pkgerrors.Wrap(err, "Creating SMS client")
 	}
-	return nil
+	return &smsClient{sms: sms}, nil
 }
 
-func (c *smsClient) createSecret(domain string, secret string,
-	values map[string]interface{}) error {
+func (c *smsClient) isReady(ctx context.Context) bool {
 
-	message := map[string]interface{}{
-		"name":   secret,
-		"values": values,
-	}
-
-	url := "/v1/sms/domain/" + strings.TrimSpace(domain) + "/secret"
-	err := c.sendPostRequest(url, message)
+	status, err := c.sms.GetStatus(ctx)
 	if err != nil {
-		return pkgerrors.Cause(err)
+		fmt.Println(err)
+		return false
 	}
 
-	return nil
+	return !status.Sealed
 }
 
-func (c *smsClient) isReady() bool {
+//waitForReady blocks until SMS is unsealed
+func (c *smsClient) waitForReady(ctx context.Context) {
 
-	url := "v1/sms/quorum/status"
-	res, err := c.sendGetRequest(url)
-	if err != nil {
-		fmt.Println(pkgerrors.Cause(err))
-		return false
+	for c.isReady(ctx) == false {
+		time.Sleep(5 * time.Second)
+		fmt.Println("Waiting for SMS to accept requests...")
 	}
-
-	if res["sealstatus"] == true {
-		return false
-	}
-
-	return true
 }
 
 //uploadToSMS reads through the domain or domains and uploads
 //their corresponding secrets to SMS service
 func (c *smsClient) uploadToSMS(data DataJSON) error {
 
-	var ldata []SecretDomainJSON
-
-	//Check if Domain is empty
-	if strings.TrimSpace(data.Domain.Name) != "" {
-		ldata = append(ldata, data.Domain)
-	} else if len(data.Domains) != 0 {
-		//Check if plural Domains are empty
-		ldata = append(ldata, data.Domains...)
-	} else {
-		return pkgerrors.New("Invalid JSON Data. No domain or domains found")
+	domains, err := mergeDomains([]DataJSON{data})
+	if err != nil {
+		return err
 	}
 
-	isReady := make(chan bool)
-	go func() {
-		for c.isReady() == false {
-			time.Sleep(5 * time.Second)
-			fmt.Println("Waiting for SMS to accept requests...")
-		}
-		isReady <- true
-	}()
-	<-isReady
-
 	fmt.Println("Uploading data...")
 
-	for _, d := range ldata {
-		var err error
-		for i := 0; i < c.RetryCount; i++ {
-			err = c.createDomain(d.Name)
-			if err != nil {
-				fmt.Println(pkgerrors.Cause(err))
-				fmt.Println("Retrying...")
-				time.Sleep(5 * time.Second)
-			} else {
-				break
-			}
-		}
-		if err != nil {
-			return pkgerrors.Cause(err)
-		}
-
-		for _, s := range d.Secrets {
-			for i := 0; i < c.RetryCount; i++ {
-				err = c.createSecret(d.Name, s.Name, s.Values)
-				if err != nil {
-					fmt.Println(pkgerrors.Cause(err))
-					fmt.Println("Retrying...")
-					time.Sleep(5 * time.Second)
-				} else {
-					break
-				}
-			}
-		}
-		if err != nil {
-			return pkgerrors.Cause(err)
-		}
-	}
-
-	return nil
+	report := c.upload(domains, uploadOptions{Parallel: 1}, os.Stdout)
+	return report.err()
 }
 
 func main() {
 
 	cacert := flag.String("cacert", "/sms/certs/aaf_root_ca.cer",
 		"Path to the CA Certificate file")
+	clientcert := flag.String("clientcert", "",
+		"Path to the client certificate presented to SMS")
+	clientkey := flag.String("clientkey", "",
+		"Path to the key of the client certificate")
+	servername := flag.String("servername", "",
+		"Name used to verify the SMS certificate if it differs from the url")
+	insecure := flag.Bool("insecure", false,
+		"Do not verify the SMS certificate. Only use this for testing")
 	serviceurl := flag.String("serviceurl", "https://aaf-sms.onap",
 		"Url for the SMS Service")
 	serviceport := flag.String("serviceport", "10443",
 		"Service port if its different than the default")
 	jsondir := flag.String("jsondir", ".",
-		"Folder containing json files to upload")
+		"Folder containing json, yaml, env or Kubernetes Secret files to upload")
+	dryRun := flag.Bool("dry-run", false,
+		"Print the changes against SMS for each domain without making them")
+	sync := flag.Bool("sync", false,
+		"Only write secrets that are new or have changed")
+	prune := flag.Bool("prune", false,
+		"Delete secrets in the uploaded domains that are not in the source files")
+	strict := flag.Bool("strict", false,
+		"Fail when a ${VAR} reference in a value is not set")
+	parallel := flag.Int("parallel", 4,
+		"Number of domains uploaded at the same time")
+	retries := flag.Int("retries", 5,
+		"Number of attempts for each request")
+	reportFile := flag.String("report", "",
+		"Write a JSON report of the upload to this file. Use - for stdout")
+	stateFile := flag.String("state", "",
+		"File recording completed changes. An interrupted upload resumes from it")
 
 	flag.Parse()
 
 	//Clear all trailing/leading spaces from incoming strings
 	*cacert = strings.TrimSpace(*cacert)
+	*clientcert = strings.TrimSpace(*clientcert)
+	*clientkey = strings.TrimSpace(*clientkey)
+	*servername = strings.TrimSpace(*servername)
 	*serviceurl = strings.TrimSpace(*serviceurl)
 	*serviceport = strings.TrimSpace(*serviceport)
 	*jsondir = strings.TrimSpace(*jsondir)
@@ -320,34 +160,93 @@ func main() {
 		log.Fatal(pkgerrors.Cause(err))
 	}
 
-	//URL validity is checked here
-	serviceURL, err := url.Parse(*serviceurl + ":" + *serviceport)
-	if err != nil {
-		log.Fatal(pkgerrors.Cause(err))
+	//-retries counts the first attempt, the SDK only counts retries
+	retryCount := *retries - 1
+	if retryCount < 1 {
+		retryCount = -1
 	}
 
-	client := &smsClient{
-		Timeout:    30,
-		BaseURL:    serviceURL,
-		CaCertPath: *cacert,
-		RetryCount: 5,
+	client, err := newSMSClient(smsclient.Config{
+		URL:          *serviceurl + ":" + *serviceport,
+		CAFile:       *cacert,
+		ClientCert:   *clientcert,
+		ClientKey:    *clientkey,
+		ServerName:   *servername,
+		Timeout:      30 * time.Second,
+		RetryCount:   retryCount,
+		RetryWait:    time.Second,
+		MaxRetryWait: 30 * time.Second,
+	}, *insecure)
+	if err != nil {
+		log.Fatal(err)
 	}
-	client.init()
 
+	//Resolves ${VAR} and @file: references after a file is read
+	ip := newInterpolator(*strict)
+
+	//All files are read first so that secrets for a domain
+	//spread across files are uploaded together
+	var data []DataJSON
+	var fileErrors []string
 	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			fmt.Println("Processing   ", filepath.Join(*jsondir, file.Name()))
-			d, err := processJSONFile(filepath.Join(*jsondir, file.Name()))
-			if err != nil {
-				log.Printf("Error Reading %s : %s", file.Name(), pkgerrors.Cause(err))
-				continue
-			}
+		if !isSourceFile(file.Name()) {
+			continue
+		}
 
-			err = client.uploadToSMS(d)
-			if err != nil {
-				log.Printf("Error Uploading %s : %s", file.Name(), pkgerrors.Cause(err))
-				continue
+		fmt.Println("Processing   ", filepath.Join(*jsondir, file.Name()))
+		d, err := processFile(filepath.Join(*jsondir, file.Name()))
+		if err == nil {
+			d, err = ip.interpolateData(d)
+		}
+		if err != nil {
+			//Pruning with a file missing would delete its secrets
+			if *prune {
+				log.Fatalf("Error Reading %s : %s", file.Name(), pkgerrors.Cause(err))
 			}
+			log.Printf("Error Reading %s : %s", file.Name(), pkgerrors.Cause(err))
+			fileErrors = append(fileErrors, pkgerrors.Cause(err).Error())
+			continue
+		}
+		data = append(data, d)
+	}
+
+	domains, err := mergeDomains(data)
+	if err != nil {
+		log.Fatal(pkgerrors.Cause(err))
+	}
+
+	opts := uploadOptions{
+		DryRun:   *dryRun,
+		Sync:     *sync,
+		Prune:    *prune,
+		Parallel: *parallel,
+	}
+	if *stateFile != "" && !*dryRun {
+		opts.State, err = loadUploadState(*stateFile)
+		if err != nil {
+			log.Fatal(pkgerrors.Cause(err))
+		}
+	}
+
+	report := client.upload(domains, opts, os.Stdout)
+	report.Errors = append(report.Errors, fileErrors...)
+	fmt.Println("Summary:", report)
+
+	if *reportFile != "" {
+		err = report.write(*reportFile)
+		if err != nil {
+			log.Printf("Error Writing report %s : %s", *reportFile, err)
 		}
 	}
+
+	if opts.State != nil {
+		err = opts.State.close(!report.failed())
+		if err != nil {
+			log.Printf("Error Closing state %s : %s", *stateFile, err)
+		}
+	}
+
+	if report.failed() {
+		os.Exit(1)
+	}
 }
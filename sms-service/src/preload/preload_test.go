@@ -0,0 +1,407 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"smsclient"
+)
+
+//fakeSMS is an in memory implementation of the SMS domain and secret API
+type fakeSMS struct {
+	sync.Mutex
+	domains map[string]map[string]map[string]interface{}
+	writes  int
+}
+
+func (f *fakeSMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/sms/"), "/")
+	switch {
+	case r.URL.Path == "/v1/sms/quorum/status":
+		w.Write([]byte(`{"sealstatus":false}`))
+	case r.URL.Path == "/v1/sms/domain" && r.Method == "GET":
+		names := []string{}
+		for n := range f.domains {
+			names = append(names, n)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"domainnames": names})
+	case r.URL.Path == "/v1/sms/domain" && r.Method == "POST":
+		var d SecretDomainJSON
+		json.NewDecoder(r.Body).Decode(&d)
+		if _, ok := f.domains[d.Name]; ok {
+			http.Error(w, "existing domain", http.StatusInternalServerError)
+			return
+		}
+		f.writes++
+		f.domains[d.Name] = make(map[string]map[string]interface{})
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	case len(parts) == 3 && r.Method == "GET":
+		names := []string{}
+		for n := range f.domains[parts[1]] {
+			names = append(names, n)
+		}
+		if len(names) == 0 {
+			http.Error(w, "Secret not found at the provided path", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"secretnames": names})
+	case len(parts) == 3 && r.Method == "POST":
+		var s SecretJSON
+		json.NewDecoder(r.Body).Decode(&s)
+		if strings.Contains(s.Name, "/") {
+			http.Error(w, "invalid secret name", http.StatusBadRequest)
+			return
+		}
+		f.writes++
+		f.domains[parts[1]][s.Name] = s.Values
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 4 && r.Method == "GET":
+		if _, ok := f.domains[parts[1]][parts[3]]; !ok {
+			http.Error(w, "Secret not found at the provided path", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(SecretJSON{Name: parts[3], Values: f.domains[parts[1]][parts[3]]})
+	case len(parts) == 4 && r.Method == "DELETE":
+		f.writes++
+		delete(f.domains[parts[1]], parts[3])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func newTestClient(t *testing.T, f *fakeSMS) (*smsClient, func()) {
+	srv := httptest.NewServer(f)
+	c, err := newSMSClient(smsclient.Config{URL: srv.URL, RetryCount: -1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv.Close
+}
+
+func TestMergeDomains(t *testing.T) {
+	data := []DataJSON{
+		{Domain: SecretDomainJSON{Name: "dom1 ", Secrets: []SecretJSON{
+			{Name: "b", Values: map[string]interface{}{"k": "v1"}},
+		}}},
+		{Domains: []SecretDomainJSON{
+			{Name: "dom2"},
+			{Name: "dom1", Secrets: []SecretJSON{
+				{Name: "a"},
+				{Name: "b", Values: map[string]interface{}{"k": "v2"}},
+			}},
+		}},
+	}
+
+	doms, err := mergeDomains(data)
+	if err != nil {
+		t.Fatal("MergeDomains: Error merging domains", err)
+	}
+	if len(doms) != 2 || doms[0].Name != "dom1" || doms[1].Name != "dom2" {
+		t.Fatal("MergeDomains: Unexpected domains", doms)
+	}
+	if len(doms[0].Secrets) != 2 || doms[0].Secrets[1].Values["k"] != "v2" {
+		t.Fatal("MergeDomains: Later definition should win", doms[0].Secrets)
+	}
+
+	_, err = mergeDomains([]DataJSON{{}})
+	if err == nil {
+		t.Fatal("MergeDomains: Expected error for file without domains")
+	}
+}
+
+func TestUploadSync(t *testing.T) {
+	f := &fakeSMS{domains: map[string]map[string]map[string]interface{}{
+		"dom1": {
+			"same":    {"user": "admin", "ttl": 3600},
+			"changed": {"user": "admin", "password": "old"},
+			"stale":   {"user": "gone"},
+			"gen":     {"token": "abc"},
+		},
+	}}
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	domains := []SecretDomainJSON{
+		{Name: "dom1", Secrets: []SecretJSON{
+			{Name: "changed", Values: map[string]interface{}{"user": "admin", "password": "new"}},
+			{Name: "gen", Values: map[string]interface{}{"token": map[string]interface{}{"$generate": "hex"}}},
+			{Name: "new", Values: map[string]interface{}{"user": "x"}},
+			{Name: "same", Values: map[string]interface{}{"user": "admin", "ttl": 3600}},
+		}},
+		{Name: "dom2", Secrets: []SecretJSON{
+			{Name: "s", Values: map[string]interface{}{"k": "v"}},
+		}},
+	}
+
+	out := &bytes.Buffer{}
+	err := c.upload(domains, uploadOptions{DryRun: true, Prune: true}, out).err()
+	if err != nil {
+		t.Fatal("Upload: Error in dry run", err)
+	}
+	if f.writes != 0 {
+		t.Fatal("Upload: Dry run should not write to SMS")
+	}
+	expected := "Domain dom1:\n  ~ changed (keys: password)\n  + new\n  - stale\n  2 unchanged\n" +
+		"Domain dom2 (new):\n  + s\n"
+	if out.String() != expected {
+		t.Fatal("Upload: Unexpected diff", out.String())
+	}
+	if strings.Contains(out.String(), "old") || strings.Contains(out.String(), "admin") {
+		t.Fatal("Upload: Diff should not contain secret values")
+	}
+
+	err = c.upload(domains, uploadOptions{Sync: true}, &bytes.Buffer{}).err()
+	if err != nil {
+		t.Fatal("Upload: Error in sync", err)
+	}
+	//changed, new, dom2 and its secret are written. same and gen are not
+	if f.writes != 4 {
+		t.Fatal("Upload: Unexpected number of writes", f.writes)
+	}
+	if _, ok := f.domains["dom1"]["stale"]; !ok {
+		t.Fatal("Upload: Secret deleted without prune")
+	}
+	if !reflect.DeepEqual(f.domains["dom1"]["changed"], domains[0].Secrets[0].Values) {
+		t.Fatal("Upload: Changed secret not updated")
+	}
+
+	err = c.upload(domains, uploadOptions{Sync: true, Prune: true}, &bytes.Buffer{}).err()
+	if err != nil {
+		t.Fatal("Upload: Error in sync with prune", err)
+	}
+	if _, ok := f.domains["dom1"]["stale"]; ok {
+		t.Fatal("Upload: Stale secret not pruned")
+	}
+}
+
+func TestUploadReport(t *testing.T) {
+	f := &fakeSMS{domains: map[string]map[string]map[string]interface{}{
+		"dom1": {"same": {"k": "v"}},
+	}}
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	var domains []SecretDomainJSON
+	for _, name := range []string{"dom1", "dom2", "dom3", "dom4"} {
+		domains = append(domains, SecretDomainJSON{Name: name, Secrets: []SecretJSON{
+			{Name: "same", Values: map[string]interface{}{"k": "v"}},
+			{Name: "bad/name", Values: map[string]interface{}{"k": "v"}},
+		}})
+	}
+
+	report := c.upload(domains, uploadOptions{Sync: true, Parallel: 3}, &bytes.Buffer{})
+	if !report.failed() || report.err() == nil {
+		t.Fatal("UploadReport: Expected failures for invalid secret names")
+	}
+	expected := map[string]int{statusCreated: 3, statusUpdated: 0, statusUnchanged: 1,
+		statusDeleted: 0, statusSkipped: 0, statusFailed: 4}
+	if !reflect.DeepEqual(report.Summary, expected) {
+		t.Fatal("UploadReport: Unexpected summary", report.Summary)
+	}
+	if report.Secrets[0].Domain != "dom1" || report.Secrets[0].Status != statusFailed ||
+		report.Secrets[1].Status != statusUnchanged {
+		t.Fatal("UploadReport: Results are not sorted", report.Secrets)
+	}
+
+	data, _ := json.Marshal(report)
+	if !strings.Contains(string(data), `"status":"failed"`) {
+		t.Fatal("UploadReport: Unexpected JSON", string(data))
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	f := &fakeSMS{domains: make(map[string]map[string]map[string]interface{})}
+	c, stop := newTestClient(t, f)
+	defer stop()
+
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state")
+
+	domains := []SecretDomainJSON{{Name: "dom", Secrets: []SecretJSON{
+		{Name: "a", Values: map[string]interface{}{"k": "v"}},
+		{Name: "b/c", Values: map[string]interface{}{"k": "v"}},
+	}}}
+
+	state, err := loadUploadState(stateFile)
+	if err != nil {
+		t.Fatal("UploadResume: Error creating state", err)
+	}
+	report := c.upload(domains, uploadOptions{State: state}, &bytes.Buffer{})
+	state.close(!report.failed())
+	if report.Summary[statusCreated] != 1 || report.Summary[statusFailed] != 1 {
+		t.Fatal("UploadResume: Unexpected first run", report.Summary)
+	}
+
+	//Fix the failing secret and resume
+	domains[0].Secrets[1].Name = "c"
+	state, err = loadUploadState(stateFile)
+	if err != nil {
+		t.Fatal("UploadResume: Error loading state", err)
+	}
+	writes := f.writes
+	report = c.upload(domains, uploadOptions{State: state}, &bytes.Buffer{})
+	state.close(!report.failed())
+	if report.Summary[statusSkipped] != 1 || report.Summary[statusCreated] != 1 || f.writes != writes+1 {
+		t.Fatal("UploadResume: Completed secret should be skipped", report.Summary)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatal("UploadResume: State file should be removed after a complete upload")
+	}
+}
+
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return cert, key
+}
+
+func TestNewSMSClient(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "preload")
+	defer os.RemoveAll(dir)
+
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sms test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "aaf-sms.onap"},
+		DNSNames:     []string{"aaf-sms.onap"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "preload"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(&fakeSMS{})
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+	cfg := smsclient.Config{URL: server.URL, RetryCount: -1,
+		CAFile: filepath.Join(dir, "missing.pem")}
+	_, err = newSMSClient(cfg, false)
+	if err == nil {
+		t.Fatal("NewSMSClient: Expected error for unreadable CA file")
+	}
+
+	cfg.CAFile = filepath.Join(dir, "client.key")
+	_, err = newSMSClient(cfg, false)
+	if err == nil {
+		t.Fatal("NewSMSClient: Expected error for CA file without certificates")
+	}
+
+	cfg.CAFile = filepath.Join(dir, "ca.pem")
+	cfg.ClientCert = filepath.Join(dir, "client.pem")
+	_, err = newSMSClient(cfg, false)
+	if err == nil {
+		t.Fatal("NewSMSClient: Expected error for client certificate without key")
+	}
+
+	cfg.ClientKey = filepath.Join(dir, "client.key")
+	cfg.ServerName = "aaf-sms.onap"
+	c, err := newSMSClient(cfg, false)
+	if err != nil {
+		t.Fatal("NewSMSClient: Error creating client", err)
+	}
+	if !c.isReady(context.Background()) {
+		t.Fatal("NewSMSClient: mTLS request failed")
+	}
+
+	//The CA is not needed when the certificate is not verified
+	cfg.CAFile = filepath.Join(dir, "missing.pem")
+	cfg.ServerName = ""
+	c, err = newSMSClient(cfg, true)
+	if err != nil {
+		t.Fatal("NewSMSClient: Error creating insecure client", err)
+	}
+	if !c.isReady(context.Background()) {
+		t.Fatal("NewSMSClient: Insecure request failed")
+	}
+
+	cfg.ClientCert, cfg.ClientKey = "", ""
+	c, _ = newSMSClient(cfg, true)
+	if c.isReady(context.Background()) {
+		t.Fatal("NewSMSClient: Expected failure without client certificate")
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	"smsclient"
+)
+
+//Actions that can be taken on a secret during sync
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionDelete    = "delete"
+	actionUnchanged = "unchanged"
+)
+
+//secretChange describes how a secret in SMS differs from the source files
+type secretChange struct {
+	Name   string
+	Action string
+	//Keys that were added, changed or removed for an update
+	Keys   []string
+	Values map[string]interface{}
+}
+
+//domainPlan lists the changes needed to bring a domain in SMS
+//in line with the source files
+type domainPlan struct {
+	Name    string
+	Exists  bool
+	Changes []secretChange
+}
+
+//domainList returns the domains in data, preferring the single
+//domain form when both are present
+func (d DataJSON) domainList() ([]SecretDomainJSON, error) {
+
+	//Check if Domain is empty
+	if strings.TrimSpace(d.Domain.Name) != "" {
+		return []SecretDomainJSON{d.Domain}, nil
+	} else if len(d.Domains) != 0 {
+		//Check if plural Domains are empty
+		return d.Domains, nil
+	}
+	return nil, pkgerrors.New("Invalid JSON Data. No domain or domains found")
+}
+
+//mergeDomains combines the domains from all source files by name.
+//A secret defined in more than one file takes the later definition
+func mergeDomains(data []DataJSON) ([]SecretDomainJSON, error) {
+
+	var order []string
+	merged := make(map[string]map[string]SecretJSON)
+
+	for _, d := range data {
+		domains, err := d.domainList()
+		if err != nil {
+			return nil, err
+		}
+		for _, dom := range domains {
+			name := strings.TrimSpace(dom.Name)
+			if name == "" {
+				return nil, pkgerrors.New("Invalid JSON Data. Domain name is empty")
+			}
+			if _, ok := merged[name]; !ok {
+				merged[name] = make(map[string]SecretJSON)
+				order = append(order, name)
+			}
+			for _, s := range dom.Secrets {
+				if _, ok := merged[name][s.Name]; ok {
+					fmt.Println("Secret", s.Name, "in domain", name, "is defined more than once")
+				}
+				merged[name][s.Name] = s
+			}
+		}
+	}
+
+	ret := make([]SecretDomainJSON, 0, len(order))
+	for _, name := range order {
+		dom := SecretDomainJSON{Name: name}
+		for _, s := range merged[name] {
+			dom.Secrets = append(dom.Secrets, s)
+		}
+		sort.Slice(dom.Secrets, func(i, j int) bool {
+			return dom.Secrets[i].Name < dom.Secrets[j].Name
+		})
+		ret = append(ret, dom)
+	}
+	return ret, nil
+}
+
+//normalizeValues passes values through JSON so that they compare
+//equal to values read back from SMS
+func normalizeValues(values map[string]interface{}) (map[string]interface{}, error) {
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, pkgerrors.Cause(err)
+	}
+
+	ret := make(map[string]interface{})
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, pkgerrors.Cause(err)
+	}
+	return ret, nil
+}
+
+//changedKeys returns the keys that differ between old and new
+func changedKeys(old map[string]interface{}, new map[string]interface{}) []string {
+
+	var keys []string
+	for k, v := range new {
+		ov, ok := old[k]
+		if !ok || !reflect.DeepEqual(ov, v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//planDomain compares dom with what SMS currently holds. Without compare
+//every secret in dom is written. Secrets that are not in dom are only
+//scheduled for deletion when prune is set
+func (c *smsClient) planDomain(ctx context.Context, dom SecretDomainJSON, exists bool,
+	prune bool, compare bool) (domainPlan, error) {
+
+	plan := domainPlan{Name: dom.Name, Exists: exists}
+
+	current := make(map[string]bool)
+	if exists {
+		names, err := c.sms.ListSecrets(ctx, dom.Name)
+		//Listing an empty domain reports that no secrets were found
+		if err != nil && !smsclient.IsNotFound(err) {
+			return plan, pkgerrors.Cause(err)
+		}
+		for _, n := range names {
+			current[n] = true
+		}
+	}
+
+	for _, s := range dom.Secrets {
+		var old map[string]interface{}
+		if current[s.Name] && (compare || hasGenerators(s.Values)) {
+			sec, err := c.sms.GetSecret(ctx, dom.Name, s.Name)
+			//Never generate new values when the stored ones can't be read
+			if err != nil {
+				return plan, pkgerrors.Cause(err)
+			}
+			old = sec.Values
+			if old == nil {
+				old = make(map[string]interface{})
+			}
+		}
+
+		//Generated values are kept from the stored secret
+		resolved, err := resolveGenerators(s.Values, old)
+		if err != nil {
+			return plan, pkgerrors.Wrap(err, "Secret "+s.Name)
+		}
+
+		change := secretChange{Name: s.Name, Action: actionCreate, Values: resolved}
+		if current[s.Name] {
+			change.Action = actionUpdate
+		}
+		if current[s.Name] && compare {
+			values, err := normalizeValues(resolved)
+			if err != nil {
+				return plan, err
+			}
+			change.Keys = changedKeys(old, values)
+			if len(change.Keys) == 0 {
+				change.Action = actionUnchanged
+			}
+		}
+		plan.Changes = append(plan.Changes, change)
+		delete(current, s.Name)
+	}
+
+	if prune {
+		var stale []string
+		for n := range current {
+			stale = append(stale, n)
+		}
+		sort.Strings(stale)
+		for _, n := range stale {
+			plan.Changes = append(plan.Changes, secretChange{Name: n, Action: actionDelete})
+		}
+	}
+
+	return plan, nil
+}
+
+//print writes the plan as a diff. Only key names are shown,
+//secret values are never printed
+func (p domainPlan) print(w io.Writer) {
+
+	if p.Exists {
+		fmt.Fprintf(w, "Domain %s:\n", p.Name)
+	} else {
+		fmt.Fprintf(w, "Domain %s (new):\n", p.Name)
+	}
+
+	unchanged := 0
+	for _, ch := range p.Changes {
+		switch ch.Action {
+		case actionCreate:
+			fmt.Fprintf(w, "  + %s\n", ch.Name)
+		case actionUpdate:
+			if ch.Keys == nil {
+				fmt.Fprintf(w, "  ~ %s\n", ch.Name)
+				continue
+			}
+			fmt.Fprintf(w, "  ~ %s (keys: %s)\n", ch.Name, strings.Join(ch.Keys, ", "))
+		case actionDelete:
+			fmt.Fprintf(w, "  - %s\n", ch.Name)
+		default:
+			unchanged++
+		}
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(w, "  %d unchanged\n", unchanged)
+	}
+}
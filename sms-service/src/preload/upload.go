@@ -0,0 +1,410 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	pkgerrors "github.com/pkg/errors"
+	"smsclient"
+)
+
+//Status of a secret in the upload report
+const (
+	statusCreated   = "created"
+	statusUpdated   = "updated"
+	statusUnchanged = "unchanged"
+	statusDeleted   = "deleted"
+	statusSkipped   = "skipped"
+	statusFailed    = "failed"
+)
+
+var actionStatus = map[string]string{
+	actionCreate:    statusCreated,
+	actionUpdate:    statusUpdated,
+	actionDelete:    statusDeleted,
+	actionUnchanged: statusUnchanged,
+}
+
+//uploadOptions controls how domains are uploaded
+type uploadOptions struct {
+	//DryRun only prints the changes
+	DryRun bool
+	//Sync skips secrets that have not changed
+	Sync bool
+	//Prune deletes secrets that are not in the source files
+	Prune bool
+	//Parallel is the number of domains uploaded at the same time
+	Parallel int
+	//State records completed secrets so that an interrupted
+	//upload can be resumed. It is optional
+	State *uploadState
+}
+
+//secretResult is the outcome of the upload for a single secret
+type secretResult struct {
+	Domain string `json:"domain"`
+	Secret string `json:"secret"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+//uploadReport summarizes an upload. With DryRun set the statuses
+//describe what would have been done
+type uploadReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Summary map[string]int `json:"summary"`
+	Secrets []secretResult `json:"secrets"`
+	//Errors that are not tied to a secret, like unreadable files
+	Errors []string `json:"errors,omitempty"`
+}
+
+//finish sorts the results and counts them by status
+func (r *uploadReport) finish() {
+
+	sort.Slice(r.Secrets, func(i, j int) bool {
+		if r.Secrets[i].Domain != r.Secrets[j].Domain {
+			return r.Secrets[i].Domain < r.Secrets[j].Domain
+		}
+		return r.Secrets[i].Secret < r.Secrets[j].Secret
+	})
+
+	r.Summary = map[string]int{
+		statusCreated:   0,
+		statusUpdated:   0,
+		statusUnchanged: 0,
+		statusDeleted:   0,
+		statusSkipped:   0,
+		statusFailed:    0,
+	}
+	for _, s := range r.Secrets {
+		r.Summary[s.Status]++
+	}
+}
+
+func (r *uploadReport) failed() bool {
+	return len(r.Errors) > 0 || r.Summary[statusFailed] > 0
+}
+
+//err returns an error listing the failures in the report
+func (r *uploadReport) err() error {
+
+	if !r.failed() {
+		return nil
+	}
+	msgs := append([]string{}, r.Errors...)
+	for _, s := range r.Secrets {
+		if s.Status == statusFailed {
+			msgs = append(msgs, fmt.Sprintf("%s/%s: %s", s.Domain, s.Secret, s.Error))
+		}
+	}
+	return pkgerrors.New(strings.Join(msgs, "; "))
+}
+
+func (r *uploadReport) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d deleted, %d skipped, %d failed",
+		r.Summary[statusCreated], r.Summary[statusUpdated], r.Summary[statusUnchanged],
+		r.Summary[statusDeleted], r.Summary[statusSkipped], r.Summary[statusFailed])
+}
+
+//write stores the report as JSON in file, or on stdout for "-"
+func (r *uploadReport) write(file string) error {
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return pkgerrors.Cause(err)
+	}
+	data = append(data, '\n')
+
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return pkgerrors.Cause(writeFileAtomic(file, data))
+}
+
+func writeFileAtomic(file string, data []byte) error {
+
+	tmp := file + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+//stateWrite is the action recorded for created and updated secrets
+const stateWrite = "write"
+
+//stateEntry is a completed change recorded in the state file
+type stateEntry struct {
+	Domain string `json:"domain"`
+	Secret string `json:"secret"`
+	Action string `json:"action"`
+	//Hash of the uploaded values, so that a secret that changed
+	//in the source files is uploaded again
+	Hash string `json:"hash,omitempty"`
+}
+
+//uploadState keeps track of completed changes in a file with
+//one JSON entry per line
+type uploadState struct {
+	sync.Mutex
+	path string
+	done map[stateEntry]bool
+	file *os.File
+}
+
+//loadUploadState reads the changes completed by an earlier run
+//from path. The file is created if it does not exist
+func loadUploadState(path string) (*uploadState, error) {
+
+	s := &uploadState{path: path, done: make(map[stateEntry]bool)}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, pkgerrors.Cause(err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e stateEntry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			//A partially written last line is ignored
+			fmt.Printf("Ignoring line %d of %s: %s\n", line, path, err)
+			continue
+		}
+		s.done[e] = true
+	}
+	if err = scanner.Err(); err != nil {
+		f.Close()
+		return nil, pkgerrors.Cause(err)
+	}
+
+	s.file = f
+	if len(s.done) > 0 {
+		fmt.Println("Resuming upload.", len(s.done), "changes already completed")
+	}
+	return s, nil
+}
+
+func (s *uploadState) isDone(e stateEntry) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.done[e]
+}
+
+func (s *uploadState) record(e stateEntry) error {
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return pkgerrors.Cause(err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.done[e] = true
+	_, err = s.file.Write(append(data, '\n'))
+	return pkgerrors.Cause(err)
+}
+
+//close closes the state file. It is removed after a complete
+//upload so that the next run starts from the beginning
+func (s *uploadState) close(complete bool) error {
+
+	err := s.file.Close()
+	if err != nil {
+		return pkgerrors.Cause(err)
+	}
+	if complete {
+		return pkgerrors.Cause(os.Remove(s.path))
+	}
+	return nil
+}
+
+//valuesHash returns a digest of values that does not depend on key order
+func valuesHash(values map[string]interface{}) string {
+
+	if values == nil {
+		return ""
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//upload brings the domains in SMS in line with the source files.
+//Up to opts.Parallel domains are processed at the same time and
+//failures are recorded in the report instead of stopping the upload
+func (c *smsClient) upload(domains []SecretDomainJSON, opts uploadOptions,
+	w io.Writer) *uploadReport {
+
+	report := &uploadReport{DryRun: opts.DryRun}
+	ctx := context.Background()
+
+	c.waitForReady(ctx)
+
+	existing, err := c.sms.ListDomains(ctx)
+	if err != nil {
+		for _, d := range domains {
+			report.Secrets = append(report.Secrets, failedResults(d, err)...)
+		}
+		report.finish()
+		return report
+	}
+	exists := make(map[string]bool)
+	for _, d := range existing {
+		exists[d] = true
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for _, d := range domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d SecretDomainJSON) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			//Output is buffered so that domains are not interleaved
+			out := &bytes.Buffer{}
+			results := c.uploadDomain(ctx, d, exists[d.Name], opts, out)
+
+			mu.Lock()
+			defer mu.Unlock()
+			w.Write(out.Bytes())
+			report.Secrets = append(report.Secrets, results...)
+		}(d)
+	}
+	wg.Wait()
+
+	report.finish()
+	return report
+}
+
+//failedResults marks every secret of d as failed with err
+func failedResults(d SecretDomainJSON, err error) []secretResult {
+
+	if len(d.Secrets) == 0 {
+		return []secretResult{{Domain: d.Name, Status: statusFailed, Error: err.Error()}}
+	}
+
+	ret := make([]secretResult, 0, len(d.Secrets))
+	for _, s := range d.Secrets {
+		ret = append(ret, secretResult{Domain: d.Name, Secret: s.Name,
+			Status: statusFailed, Error: err.Error()})
+	}
+	return ret
+}
+
+//uploadDomain plans and applies the changes for a single domain
+func (c *smsClient) uploadDomain(ctx context.Context, d SecretDomainJSON, exists bool,
+	opts uploadOptions, w io.Writer) []secretResult {
+
+	plan, err := c.planDomain(ctx, d, exists, opts.Prune, opts.Sync || opts.DryRun)
+	if err != nil {
+		fmt.Fprintf(w, "Domain %s: %s\n", d.Name, pkgerrors.Cause(err))
+		return failedResults(d, err)
+	}
+	plan.print(w)
+
+	if !opts.DryRun && !plan.Exists {
+		_, err = c.sms.CreateDomain(ctx, plan.Name)
+		//Created by another run in the meantime
+		if err != nil && !smsclient.IsDomainExists(err) {
+			fmt.Fprintf(w, "Domain %s: %s\n", d.Name, pkgerrors.Cause(err))
+			return failedResults(d, err)
+		}
+	}
+
+	results := make([]secretResult, 0, len(plan.Changes))
+	for _, ch := range plan.Changes {
+		res := secretResult{Domain: plan.Name, Secret: ch.Name, Status: actionStatus[ch.Action]}
+		//A secret created by an earlier run is an update on resume,
+		//so both are recorded as writes
+		entry := stateEntry{Domain: plan.Name, Secret: ch.Name, Action: stateWrite,
+			Hash: valuesHash(ch.Values)}
+		if ch.Action == actionDelete {
+			entry.Action = actionDelete
+		}
+
+		switch {
+		case opts.DryRun || ch.Action == actionUnchanged:
+		case opts.State != nil && opts.State.isDone(entry):
+			res.Status = statusSkipped
+		default:
+			err = c.applyChange(ctx, plan.Name, ch)
+			if err != nil {
+				fmt.Fprintf(w, "  %s: %s\n", ch.Name, pkgerrors.Cause(err))
+				res.Status = statusFailed
+				res.Error = pkgerrors.Cause(err).Error()
+				break
+			}
+			if opts.State != nil {
+				err = opts.State.record(entry)
+				if err != nil {
+					fmt.Fprintln(w, "Unable to record progress:", err)
+				}
+			}
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
+
+//applyChange writes or deletes a single secret
+func (c *smsClient) applyChange(ctx context.Context, domain string, ch secretChange) error {
+
+	switch ch.Action {
+	case actionCreate, actionUpdate:
+		return c.sms.CreateSecret(ctx, domain, smsclient.Secret{Name: ch.Name, Values: ch.Values})
+	case actionDelete:
+		err := c.sms.DeleteSecret(ctx, domain, ch.Name)
+		//Deleted by an earlier attempt
+		if err != nil && smsclient.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
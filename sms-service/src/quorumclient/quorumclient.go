@@ -25,10 +25,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	smsauth "sms/auth"
 	smslogger "sms/log"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -111,6 +113,7 @@ func main() {
 		ClientCert        string `json:"clientcert"`
 		ClientKey         string `json:"clientkey"`
 		TimeOut           string `json:"timeout"`
+		ShutdownTimeout   string `json:"shutdown_timeout"`
 		DisableTLS        bool   `json:"disable_tls"`
 	}
 
@@ -120,7 +123,7 @@ func main() {
 		log.Fatalf("Error reading config file %v", err)
 	}
 
-	cfg := config{}
+	cfg := config{ShutdownTimeout: "30s"}
 	err = json.NewDecoder(vcf).Decode(&cfg)
 	if err != nil {
 		log.Fatalf("Error while parsing config file %v", err)
@@ -169,7 +172,39 @@ func main() {
 	duration, _ := time.ParseDuration(cfg.TimeOut)
 	ticker := time.NewTicker(duration)
 
-	for _ = range ticker.C {
+	drainTimeout, err := time.ParseDuration(cfg.ShutdownTimeout)
+	if err != nil {
+		log.Fatalf("Error while parsing shutdown_timeout %v", err)
+	}
+
+	/*
+		Listen for SIGINT and SIGTERM. The current request to SMS
+		is allowed to finish within the drain timeout after which
+		the client exits forcefully.
+	*/
+	stop := make(chan struct{})
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		sig := <-c
+		smslogger.WriteInfo("Received " + sig.String() + ". Shutting down...")
+		close(stop)
+
+		time.Sleep(drainTimeout)
+		smslogger.WriteWarn("Drain timeout exceeded. Exiting")
+		smslogger.Close()
+		os.Exit(1)
+	}()
+
+	for {
+		select {
+		case <-stop:
+			ticker.Stop()
+			smslogger.WriteInfo("Quorum Client shutdown complete")
+			smslogger.Close()
+			return
+		case <-ticker.C:
+		}
 
 		//URL and Port is configured in config file
 		response, err := client.Get(cfg.BackEndURL + "/v1/sms/quorum/status")
@@ -36,40 +36,61 @@ import (
 func GetTLSConfig(caCertFile string, certFile string, keyFile string) (*tls.Config, error) {
 
 	// Initialize tlsConfig once
-	caCert, err := ioutil.ReadFile(caCertFile)
-
-	if smslogger.CheckError(err, "Read CA Cert file") != nil {
+	caCertPool, err := loadCACertPool(caCertFile)
+	if err != nil {
 		return nil, err
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
 	tlsConfig := &tls.Config{
-		// Change to RequireAndVerify once we have mandatory certs
+		// Use CertReloader.SetClientAuth to require client certificates
 		ClientAuth: tls.VerifyClientCertIfGiven,
 		ClientCAs:  caCertPool,
 		MinVersion: tls.VersionTLS12,
 	}
 
+	cert, err := loadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	tlsConfig.BuildNameToCertificate()
+	return tlsConfig, nil
+}
+
+// loadCACertPool reads the CA certificate file into a new pool
+func loadCACertPool(caCertFile string) (*x509.CertPool, error) {
+
+	caCert, err := ioutil.ReadFile(caCertFile)
+	if smslogger.CheckError(err, "Read CA Cert file") != nil {
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		smslogger.WriteWarn("No certificates found in CA Cert file")
+	}
+	return caCertPool, nil
+}
+
+// loadX509KeyPair reads a certificate and its possibly encrypted key
+func loadX509KeyPair(certFile string, keyFile string) (tls.Certificate, error) {
+
 	certPEMBlk, err := readPEMBlock(certFile)
 	if smslogger.CheckError(err, "Read Cert File") != nil {
-		return nil, err
+		return tls.Certificate{}, err
 	}
 
 	keyPEMBlk, err := readPEMBlock(keyFile)
 	if smslogger.CheckError(err, "Read Key File") != nil {
-		return nil, err
+		return tls.Certificate{}, err
 	}
 
-	tlsConfig.Certificates = make([]tls.Certificate, 1)
-	tlsConfig.Certificates[0], err = tls.X509KeyPair(certPEMBlk, keyPEMBlk)
+	cert, err := tls.X509KeyPair(certPEMBlk, keyPEMBlk)
 	if smslogger.CheckError(err, "Load x509 cert and key") != nil {
-		return nil, err
+		return tls.Certificate{}, err
 	}
-
-	tlsConfig.BuildNameToCertificate()
-	return tlsConfig, nil
+	return cert, nil
 }
 
 func readPEMBlock(filename string) ([]byte, error) {
@@ -86,7 +107,23 @@ func readPEMBlock(filename string) ([]byte, error) {
 	}
 
 	if x509.IsEncryptedPEMBlock(pemBlock) {
-		pByte, err := base64.StdEncoding.DecodeString(smsconfig.SMSConfig.Password)
+		// Password is cleared after startup. Read it again from its
+		// source or, when it is plain text, from the configuration file
+		password := smsconfig.SMSConfig.Password
+		if password == "" {
+			password, err = passwordSource(smsconfig.SMSConfig).resolve()
+			if smslogger.CheckError(err, "Load PEM Password") != nil {
+				return nil, err
+			}
+		}
+		if password == "" {
+			password, err = smsconfig.ReadConfigPassword()
+			if smslogger.CheckError(err, "Load PEM Password") != nil {
+				return nil, err
+			}
+		}
+
+		pByte, err := base64.StdEncoding.DecodeString(password)
 		if smslogger.CheckError(err, "Decode PEM Password") != nil {
 			return nil, err
 		}
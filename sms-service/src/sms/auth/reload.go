@@ -0,0 +1,236 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	smslogger "sms/log"
+)
+
+// CertReloader holds the server certificate and the CA pool used
+// to verify clients. Both can be reloaded from disk while the server
+// is running so that rotated certificates take effect without a restart
+type CertReloader struct {
+	sync.RWMutex
+	caCertFile string
+	certFile   string
+	keyFile    string
+	cert       *tls.Certificate
+	caCertPool *x509.CertPool
+	modTimes   map[string]time.Time
+	baseConfig *tls.Config
+	clientAuth ClientAuthConfig
+	revocation *revocationChecker
+}
+
+// NewCertReloader loads the certificates for the first time.
+// An error is returned if any of them cannot be read
+func NewCertReloader(caCertFile string, certFile string, keyFile string) (*CertReloader, error) {
+
+	cr := &CertReloader{
+		caCertFile: caCertFile,
+		certFile:   certFile,
+		keyFile:    keyFile,
+		baseConfig: &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	cr.baseConfig.VerifyPeerCertificate = cr.verifyPeerCertificate
+
+	err := cr.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// SetClientAuth changes how client certificates are verified.
+// The CRL is loaded immediately and again on every Reload
+func (cr *CertReloader) SetClientAuth(conf ClientAuthConfig) error {
+
+	cr.RLock()
+	caCertFile := cr.caCertFile
+	cr.RUnlock()
+
+	rc, err := newRevocationChecker(conf, caCertFile)
+	if err != nil {
+		return err
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+
+	cr.clientAuth = conf
+	cr.revocation = rc
+	if conf.RequireClientCert {
+		cr.baseConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		cr.baseConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
+
+// SetFiles updates the files that are read on the next Reload
+func (cr *CertReloader) SetFiles(caCertFile string, certFile string, keyFile string) {
+	cr.Lock()
+	defer cr.Unlock()
+
+	cr.caCertFile = caCertFile
+	cr.certFile = certFile
+	cr.keyFile = keyFile
+}
+
+// Reload reads the certificate, key and CA files again.
+// The currently loaded certificates are kept if any of them fail to load
+func (cr *CertReloader) Reload() error {
+
+	cr.RLock()
+	caCertFile, certFile, keyFile := cr.caCertFile, cr.certFile, cr.keyFile
+	clientAuth := cr.clientAuth
+	cr.RUnlock()
+
+	caCertPool, err := loadCACertPool(caCertFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := loadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	rc, err := newRevocationChecker(clientAuth, caCertFile)
+	if err != nil {
+		return err
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+
+	cr.cert = &cert
+	cr.caCertPool = caCertPool
+	cr.revocation = rc
+	cr.modTimes = getModTimes(caCertFile, certFile, keyFile, clientAuth.CRLFile)
+
+	smslogger.WriteInfo("Loaded TLS certificates")
+	return nil
+}
+
+// GetCertificate returns the currently loaded server certificate.
+// It is meant to be used as tls.Config.GetCertificate
+func (cr *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.RLock()
+	defer cr.RUnlock()
+
+	return cr.cert, nil
+}
+
+// GetConfigForClient returns a tls.Config that uses the currently
+// loaded CA pool to verify client certificates.
+// It is meant to be used as tls.Config.GetConfigForClient
+func (cr *CertReloader) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	cr.RLock()
+	defer cr.RUnlock()
+
+	conf := cr.baseConfig.Clone()
+	conf.ClientCAs = cr.caCertPool
+	conf.GetCertificate = cr.GetCertificate
+	return conf, nil
+}
+
+// TLSConfig returns a tls.Config for http.Server that always
+// serves the latest certificates held by the reloader
+func (cr *CertReloader) TLSConfig() *tls.Config {
+	conf := cr.baseConfig.Clone()
+	conf.GetCertificate = cr.GetCertificate
+	conf.GetConfigForClient = cr.GetConfigForClient
+	return conf
+}
+
+// verifyPeerCertificate checks the verified client certificate
+// chains against the currently loaded revocation information
+func (cr *CertReloader) verifyPeerCertificate(rawCerts [][]byte,
+	verifiedChains [][]*x509.Certificate) error {
+
+	cr.RLock()
+	rc := cr.revocation
+	cr.RUnlock()
+
+	if rc == nil {
+		return nil
+	}
+	return rc.verifyPeerCertificate(rawCerts, verifiedChains)
+}
+
+// Watch polls the certificate files every interval and reloads them
+// when their modification times change. It returns when stop is closed
+func (cr *CertReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !cr.filesChanged() {
+			continue
+		}
+
+		smslogger.WriteInfo("TLS certificate files changed. Reloading...")
+		err := cr.Reload()
+		smslogger.CheckError(err, "Reload TLS certificates")
+	}
+}
+
+// filesChanged compares the modification times on disk with the
+// ones recorded during the last successful reload
+func (cr *CertReloader) filesChanged() bool {
+	cr.RLock()
+	defer cr.RUnlock()
+
+	current := getModTimes(cr.caCertFile, cr.certFile, cr.keyFile, cr.clientAuth.CRLFile)
+	for f, t := range current {
+		if !t.Equal(cr.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func getModTimes(files ...string) map[string]time.Time {
+
+	modTimes := make(map[string]time.Time)
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		modTimes[f] = info.ModTime()
+	}
+	return modTimes
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestNewCertReloader(t *testing.T) {
+	_, err := NewCertReloader("filedoesnotexist.cert", "filedoesnotexist.cert", "filedoesnotexist.cert")
+	if err == nil {
+		t.Errorf("NewCertReloader: Expected error but got none")
+	}
+
+	cr, err := NewCertReloader("../test/auth_test_certificate.pem",
+		"../test/auth_test_certificate.pem",
+		"../test/auth_test_key.pem")
+	if err != nil {
+		t.Fatal("NewCertReloader: Returned error: " + err.Error())
+	}
+
+	tlsConfig := cr.TLSConfig()
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("NewCertReloader: Incorrect min TLS version")
+	}
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil {
+		t.Fatal("NewCertReloader: GetCertificate did not return a certificate")
+	}
+
+	clientConf, err := tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil || clientConf.ClientCAs == nil {
+		t.Fatal("NewCertReloader: GetConfigForClient did not set ClientCAs")
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	cr, err := NewCertReloader("../test/auth_test_certificate.pem",
+		"../test/auth_test_certificate.pem",
+		"../test/auth_test_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := cr.GetCertificate(nil)
+
+	// A failed reload must keep the previously loaded certificate
+	cr.SetFiles("filedoesnotexist.cert", "filedoesnotexist.cert", "filedoesnotexist.cert")
+	err = cr.Reload()
+	if err == nil {
+		t.Fatal("Reload: Expected error but got none")
+	}
+
+	after, _ := cr.GetCertificate(nil)
+	if before != after {
+		t.Fatal("Reload: Certificate changed after failed reload")
+	}
+
+	cr.SetFiles("../test/auth_test_certificate.pem",
+		"../test/auth_test_certificate.pem",
+		"../test/auth_test_key.pem")
+	err = cr.Reload()
+	if err != nil {
+		t.Fatal("Reload: Returned error: " + err.Error())
+	}
+
+	after, _ = cr.GetCertificate(nil)
+	if before == after {
+		t.Fatal("Reload: Certificate was not replaced")
+	}
+}
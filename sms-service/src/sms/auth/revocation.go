@@ -0,0 +1,261 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	smslogger "sms/log"
+)
+
+// ClientAuthConfig controls how client certificates presented to
+// SMS are verified
+type ClientAuthConfig struct {
+	// RequireClientCert rejects connections without a valid client certificate
+	RequireClientCert bool
+	// CRLFile is a PEM or DER encoded CRL signed by one of the CAs
+	CRLFile string
+	// OCSPCheck queries the OCSP responder listed in the client certificate
+	OCSPCheck bool
+}
+
+// revocationChecker verifies client certificates against a CRL
+// and OCSP responders. OCSP responses are cached until their NextUpdate
+type revocationChecker struct {
+	sync.Mutex
+	crl        *pkix.CertificateList
+	crlIssuer  string
+	revoked    map[string]bool
+	ocspCheck  bool
+	ocspCache  map[string]*ocsp.Response
+	httpClient *http.Client
+}
+
+// newRevocationChecker loads the CRL if one is specified and
+// verifies that it is signed by a certificate in caCertFile
+func newRevocationChecker(conf ClientAuthConfig, caCertFile string) (*revocationChecker, error) {
+
+	rc := &revocationChecker{
+		ocspCheck:  conf.OCSPCheck,
+		ocspCache:  make(map[string]*ocsp.Response),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	if conf.CRLFile == "" {
+		return rc, nil
+	}
+
+	crl, err := loadCRL(conf.CRLFile, caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+
+	rc.crl = crl
+	rc.crlIssuer = issuer.String()
+	rc.revoked = make(map[string]bool)
+	for _, r := range crl.TBSCertList.RevokedCertificates {
+		rc.revoked[r.SerialNumber.String()] = true
+	}
+
+	return rc, nil
+}
+
+// loadCRL parses the CRL file and checks its signature against
+// the certificates in the CA file
+func loadCRL(crlFile string, caCertFile string) (*pkix.CertificateList, error) {
+
+	crlData, err := ioutil.ReadFile(crlFile)
+	if smslogger.CheckError(err, "Read CRL File") != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(crlData)
+	if smslogger.CheckError(err, "Parse CRL") != nil {
+		return nil, err
+	}
+
+	caCerts, err := readCertificates(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ca := range caCerts {
+		if ca.CheckCRLSignature(crl) == nil {
+			if crl.HasExpired(time.Now()) {
+				smslogger.WriteWarn("CRL has passed its next update time")
+			}
+			return crl, nil
+		}
+	}
+
+	smslogger.WriteError("CRL is not signed by a trusted CA")
+	return nil, errors.New("CRL is not signed by a trusted CA")
+}
+
+// readCertificates returns all the certificates in a PEM file
+func readCertificates(fileName string) ([]*x509.Certificate, error) {
+
+	data, err := ioutil.ReadFile(fileName)
+	if smslogger.CheckError(err, "Read Certificate File") != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if smslogger.CheckError(err, "Parse Certificate") != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// verifyPeerCertificate is used as tls.Config.VerifyPeerCertificate.
+// It runs after the standard chain verification and rejects any
+// client certificate that has been revoked
+func (rc *revocationChecker) verifyPeerCertificate(rawCerts [][]byte,
+	verifiedChains [][]*x509.Certificate) error {
+
+	for _, chain := range verifiedChains {
+		if len(chain) < 2 {
+			continue
+		}
+
+		cert, issuer := chain[0], chain[1]
+		err := rc.checkCRL(cert)
+		if err != nil {
+			return err
+		}
+
+		err = rc.checkOCSP(cert, issuer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rc *revocationChecker) checkCRL(cert *x509.Certificate) error {
+
+	if rc.crl == nil {
+		return nil
+	}
+
+	if cert.Issuer.String() == rc.crlIssuer && rc.revoked[cert.SerialNumber.String()] {
+		smslogger.WriteWarn("Rejected revoked certificate: " + cert.Subject.CommonName)
+		return errors.New("Client certificate has been revoked")
+	}
+
+	return nil
+}
+
+// checkOCSP asks the responder listed in the certificate for its
+// status. Certificates without a responder are skipped, but failing
+// to reach a listed responder rejects the connection
+func (rc *revocationChecker) checkOCSP(cert *x509.Certificate, issuer *x509.Certificate) error {
+
+	if !rc.ocspCheck || len(cert.OCSPServer) == 0 {
+		return nil
+	}
+
+	key := cert.Issuer.String() + "/" + cert.SerialNumber.String()
+
+	rc.Lock()
+	resp, ok := rc.ocspCache[key]
+	rc.Unlock()
+
+	if !ok || time.Now().After(resp.NextUpdate) {
+		var err error
+		resp, err = rc.queryOCSP(cert, issuer)
+		if err != nil {
+			return errors.New("Unable to verify client certificate status")
+		}
+
+		rc.Lock()
+		rc.ocspCache[key] = resp
+		rc.Unlock()
+	}
+
+	if resp.Status != ocsp.Good {
+		smslogger.WriteWarn("Rejected certificate with OCSP status " +
+			ocspStatusString(resp.Status) + ": " + cert.Subject.CommonName)
+		return errors.New("Client certificate has been revoked")
+	}
+
+	return nil
+}
+
+func (rc *revocationChecker) queryOCSP(cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if smslogger.CheckError(err, "Create OCSP Request") != nil {
+		return nil, err
+	}
+
+	httpResp, err := rc.httpClient.Post(cert.OCSPServer[0], "application/ocsp-request",
+		bytes.NewReader(req))
+	if smslogger.CheckError(err, "Send OCSP Request") != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if smslogger.CheckError(err, "Read OCSP Response") != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if smslogger.CheckError(err, "Parse OCSP Response") != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI is a locally generated CA with a server certificate and
+// two client certificates, one of which is revoked
+type testPKI struct {
+	dir           string
+	caCert        *x509.Certificate
+	caKey         *ecdsa.PrivateKey
+	goodClient    tls.Certificate
+	revokedClient tls.Certificate
+}
+
+func createCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, fileName string, blockType string, data []byte) {
+	err := ioutil.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestPKI(t *testing.T, ocspURL string) *testPKI {
+
+	dir, err := ioutil.TempDir("", "smsauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPKI{dir: dir}
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+
+	p.caCert, p.caKey = createCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sms test ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}, nil, nil)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", p.caCert.Raw)
+
+	serverCert, serverKey := createCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "aaf-sms.onap"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, p.caCert, p.caKey)
+	writePEM(t, filepath.Join(dir, "server.pem"), "CERTIFICATE", serverCert.Raw)
+	keyDER, _ := x509.MarshalECPrivateKey(serverKey)
+	writePEM(t, filepath.Join(dir, "server.key"), "EC PRIVATE KEY", keyDER)
+
+	for i, c := range []*tls.Certificate{&p.goodClient, &p.revokedClient} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(10 + i)),
+			Subject:      pkix.Name{CommonName: "onap-component"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if ocspURL != "" {
+			template.OCSPServer = []string{ocspURL}
+		}
+		cert, key := createCertificate(t, template, p.caCert, p.caKey)
+		c.Certificate = [][]byte{cert.Raw}
+		c.PrivateKey = key
+		c.Leaf = cert
+	}
+
+	crl, err := p.caCert.CreateCRL(rand.Reader, p.caKey, []pkix.RevokedCertificate{
+		{SerialNumber: p.revokedClient.Leaf.SerialNumber, RevocationTime: time.Now()},
+	}, time.Now(), notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "crl.pem"), "X509 CRL", crl)
+
+	return p
+}
+
+func (p *testPKI) certReloader(t *testing.T, conf ClientAuthConfig) *CertReloader {
+	cr, err := NewCertReloader(filepath.Join(p.dir, "ca.pem"),
+		filepath.Join(p.dir, "server.pem"), filepath.Join(p.dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cr.SetClientAuth(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cr
+}
+
+func TestCRLRevocation(t *testing.T) {
+	p := createTestPKI(t, "")
+	defer os.RemoveAll(p.dir)
+
+	cr := p.certReloader(t, ClientAuthConfig{CRLFile: filepath.Join(p.dir, "crl.pem")})
+
+	err := cr.verifyPeerCertificate(nil, [][]*x509.Certificate{{p.goodClient.Leaf, p.caCert}})
+	if err != nil {
+		t.Fatal("CRL: Valid certificate was rejected: " + err.Error())
+	}
+
+	err = cr.verifyPeerCertificate(nil, [][]*x509.Certificate{{p.revokedClient.Leaf, p.caCert}})
+	if err == nil {
+		t.Fatal("CRL: Revoked certificate was accepted")
+	}
+}
+
+func TestCRLSignedByUnknownCA(t *testing.T) {
+	p := createTestPKI(t, "")
+	defer os.RemoveAll(p.dir)
+	other := createTestPKI(t, "")
+	defer os.RemoveAll(other.dir)
+
+	cr := p.certReloader(t, ClientAuthConfig{})
+	err := cr.SetClientAuth(ClientAuthConfig{CRLFile: filepath.Join(other.dir, "crl.pem")})
+	if err == nil {
+		t.Fatal("CRL: Expected error for CRL from another CA")
+	}
+}
+
+func TestOCSPRevocation(t *testing.T) {
+	var p *testPKI
+	queries := 0
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if req.SerialNumber.Cmp(p.revokedClient.Leaf.SerialNumber) == 0 {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now()
+		}
+
+		resp, _ := ocsp.CreateResponse(p.caCert, p.caCert, template, p.caKey)
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	p = createTestPKI(t, responder.URL)
+	defer os.RemoveAll(p.dir)
+
+	cr := p.certReloader(t, ClientAuthConfig{OCSPCheck: true})
+
+	err := cr.verifyPeerCertificate(nil, [][]*x509.Certificate{{p.goodClient.Leaf, p.caCert}})
+	if err != nil {
+		t.Fatal("OCSP: Valid certificate was rejected: " + err.Error())
+	}
+
+	err = cr.verifyPeerCertificate(nil, [][]*x509.Certificate{{p.revokedClient.Leaf, p.caCert}})
+	if err == nil {
+		t.Fatal("OCSP: Revoked certificate was accepted")
+	}
+
+	// Second check for the good certificate is served from cache
+	cr.verifyPeerCertificate(nil, [][]*x509.Certificate{{p.goodClient.Leaf, p.caCert}})
+	if queries != 2 {
+		t.Fatalf("OCSP: Expected 2 responder queries, got %d", queries)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	p := createTestPKI(t, "")
+	defer os.RemoveAll(p.dir)
+
+	cr := p.certReloader(t, ClientAuthConfig{
+		RequireClientCert: true,
+		CRLFile:           filepath.Join(p.dir, "crl.pem"),
+	})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = cr.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(p.caCert)
+
+	newClient := func(certs []tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      roots,
+					Certificates: certs,
+					MinVersion:   tls.VersionTLS12,
+				},
+			},
+		}
+	}
+
+	_, err := newClient(nil).Get(server.URL)
+	if err == nil {
+		t.Fatal("RequireClientCert: Connection without certificate was accepted")
+	}
+
+	_, err = newClient([]tls.Certificate{p.revokedClient}).Get(server.URL)
+	if err == nil {
+		t.Fatal("RequireClientCert: Connection with revoked certificate was accepted")
+	}
+
+	resp, err := newClient([]tls.Certificate{p.goodClient}).Get(server.URL)
+	if err != nil {
+		t.Fatal("RequireClientCert: Connection with valid certificate failed: " + err.Error())
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	smsconfig "sms/config"
+	smslogger "sms/log"
+)
+
+// secretSource describes where a configuration secret can be read from.
+// Only one of the fields may be set
+type secretSource struct {
+	name       string
+	value      string
+	file       string
+	env        string
+	pgp        string
+	pgpKeyFile string
+}
+
+// LoadConfigSecrets fills in VaultToken and Password from the file,
+// environment variable or PGP encrypted blob configured for them
+func LoadConfigSecrets(conf *smsconfig.SMSConfiguration) error {
+
+	token, err := vaultTokenSource(conf).resolve()
+	if err != nil {
+		return err
+	}
+
+	password, err := passwordSource(conf).resolve()
+	if err != nil {
+		return err
+	}
+
+	conf.VaultToken = token
+	conf.Password = password
+	return nil
+}
+
+// ClearConfigPassword removes the PEM password from the configuration
+// once the certificates have been loaded. Certificate reloads read it
+// again from its source or from the configuration file
+func ClearConfigPassword(conf *smsconfig.SMSConfiguration) {
+	conf.Password = ""
+}
+
+func vaultTokenSource(conf *smsconfig.SMSConfiguration) secretSource {
+	return secretSource{
+		name:       "vaulttoken",
+		value:      conf.VaultToken,
+		file:       conf.VaultTokenFile,
+		env:        conf.VaultTokenEnv,
+		pgp:        conf.VaultTokenPGP,
+		pgpKeyFile: conf.PGPKeyFile,
+	}
+}
+
+func passwordSource(conf *smsconfig.SMSConfiguration) secretSource {
+	return secretSource{
+		name:       "password",
+		value:      conf.Password,
+		file:       conf.PasswordFile,
+		env:        conf.PasswordEnv,
+		pgp:        conf.PasswordPGP,
+		pgpKeyFile: conf.PGPKeyFile,
+	}
+}
+
+// resolve returns the secret from whichever source is configured.
+// The plain text value is returned when no other source is set
+func (s secretSource) resolve() (string, error) {
+
+	external := 0
+	for _, v := range []string{s.file, s.env, s.pgp} {
+		if v != "" {
+			external++
+		}
+	}
+
+	if external > 1 {
+		return "", errors.New(s.name + ": only one of " + s.name + "_file, " +
+			s.name + "_env and " + s.name + "_pgp can be set")
+	}
+
+	if external == 1 && s.value != "" {
+		smslogger.WriteWarn("Ignoring plain text " + s.name + " in configuration")
+	}
+
+	switch {
+	case s.file != "":
+		data, err := ReadFromFile(s.file)
+		if err != nil {
+			return "", errors.New(s.name + "_file: " + err.Error())
+		}
+		return strings.TrimSpace(data), nil
+
+	case s.env != "":
+		val, ok := os.LookupEnv(s.env)
+		if !ok {
+			return "", errors.New(s.name + "_env: environment variable " + s.env + " is not set")
+		}
+		return strings.TrimSpace(val), nil
+
+	case s.pgp != "":
+		if s.pgpKeyFile == "" {
+			return "", errors.New(s.name + "_pgp: pgp_private_key_file must be set")
+		}
+		prkey, err := ReadFromFile(s.pgpKeyFile)
+		if err != nil {
+			return "", errors.New("pgp_private_key_file: " + err.Error())
+		}
+		val, err := DecryptPGPString(strings.TrimSpace(s.pgp), strings.TrimSpace(prkey))
+		if err != nil {
+			return "", errors.New(s.name + "_pgp: " + err.Error())
+		}
+		return val, nil
+	}
+
+	return s.value, nil
+}
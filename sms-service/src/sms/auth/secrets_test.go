@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	smsconfig "sms/config"
+)
+
+func TestLoadConfigSecretsFromFileAndEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smssecrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	WriteToFile("aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee\n", tokenFile)
+
+	os.Setenv("TEST_SMS_PEM_PASSWORD", "c2VjcmV0")
+	defer os.Unsetenv("TEST_SMS_PEM_PASSWORD")
+
+	conf := &smsconfig.SMSConfiguration{
+		VaultTokenFile: tokenFile,
+		PasswordEnv:    "TEST_SMS_PEM_PASSWORD",
+	}
+
+	err = LoadConfigSecrets(conf)
+	if err != nil {
+		t.Fatal("LoadConfigSecrets: Returned error: " + err.Error())
+	}
+	if conf.VaultToken != "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee" {
+		t.Fatal("LoadConfigSecrets: Incorrect token read from file")
+	}
+	if conf.Password != "c2VjcmV0" {
+		t.Fatal("LoadConfigSecrets: Incorrect password read from environment")
+	}
+
+	ClearConfigPassword(conf)
+	if conf.Password != "" {
+		t.Fatal("ClearConfigPassword: Password was not cleared")
+	}
+}
+
+func TestLoadConfigSecretsFromPGP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smssecrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pbkey, prkey, err := GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "prkey")
+	WriteToFile(prkey, keyFile)
+
+	blob, err := EncryptPGPString("aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", pbkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &smsconfig.SMSConfiguration{
+		VaultTokenPGP: blob,
+		PGPKeyFile:    keyFile,
+	}
+
+	err = LoadConfigSecrets(conf)
+	if err != nil {
+		t.Fatal("LoadConfigSecrets: Returned error: " + err.Error())
+	}
+	if conf.VaultToken != "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee" {
+		t.Fatal("LoadConfigSecrets: Incorrect token decrypted from PGP blob")
+	}
+}
+
+func TestLoadConfigSecretsErrors(t *testing.T) {
+	conf := &smsconfig.SMSConfiguration{
+		VaultTokenFile: "filedoesnotexist",
+		VaultTokenEnv:  "TEST_SMS_TOKEN",
+	}
+	err := LoadConfigSecrets(conf)
+	if err == nil {
+		t.Fatal("LoadConfigSecrets: Expected error for multiple sources")
+	}
+
+	conf = &smsconfig.SMSConfiguration{PasswordEnv: "TEST_SMS_VARIABLE_NOT_SET"}
+	err = LoadConfigSecrets(conf)
+	if err == nil {
+		t.Fatal("LoadConfigSecrets: Expected error for missing environment variable")
+	}
+
+	conf = &smsconfig.SMSConfiguration{Password: "c2VjcmV0"}
+	err = LoadConfigSecrets(conf)
+	if err != nil || conf.Password != "c2VjcmV0" {
+		t.Fatal("LoadConfigSecrets: Plain text password should be kept")
+	}
+	ClearConfigPassword(conf)
+	if conf.Password != "" {
+		t.Fatal("ClearConfigPassword: Plain text password was not cleared")
+	}
+}
@@ -17,6 +17,8 @@
 package backend
 
 import (
+	"errors"
+
 	smsconfig "sms/config"
 	smslogger "sms/log"
 )
@@ -24,15 +26,33 @@ import (
 // SecretDomain is where Secrets are stored.
 // A single domain can have any number of secrets
 type SecretDomain struct {
-	UUID string `json:"uuid"`
-	Name string `json:"name"`
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Metadata *Metadata `json:"metadata,omitempty"`
 }
 
 // Secret is the struct that defines the structure of a secret
-// It consists of a name and map containing key value pairs
+// It consists of a name and map containing key value pairs.
+// Types optionally declares the type of a value, see ValueBinary
 type Secret struct {
-	Name   string                 `json:"name"`
-	Values map[string]interface{} `json:"values"`
+	Name     string                 `json:"name"`
+	Values   map[string]interface{} `json:"values"`
+	Types    map[string]string      `json:"types,omitempty"`
+	Metadata *Metadata              `json:"metadata,omitempty"`
+}
+
+// Metadata is stored with a secret or domain but is not part of its
+// values. Times are RFC 3339 strings in UTC. Created, Updated and
+// ModifiedBy, the client that made the last change, are set by SMS.
+// The other fields are provided by the client and only informational
+type Metadata struct {
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Created     string            `json:"created,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	ModifiedBy  string            `json:"modifiedby,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
 }
 
 // SecretBackend interface that will be implemented for various secret backends
@@ -44,22 +64,49 @@ type SecretBackend interface {
 
 	GetSecret(dom string, sec string) (Secret, error)
 	ListSecret(dom string) ([]string, error)
+	ListSecretDomain() ([]string, error)
+	GetSecretDomain(name string) (SecretDomain, error)
 
 	CreateSecretDomain(name string) (SecretDomain, error)
+	RestoreSecretDomain(dom SecretDomain) error
+	UpdateSecretDomain(dom SecretDomain) error
 	CreateSecret(dom string, sec Secret) error
 
 	DeleteSecretDomain(name string) error
 	DeleteSecret(dom string, name string) error
+
+	Close() error
+}
+
+// Backends return these errors for domains and secrets that do not
+// exist. IsNotFound recognizes them
+var (
+	ErrDomainNotFound = errors.New("Domain not found")
+	ErrSecretNotFound = errors.New("Secret not found at the provided path")
+	ErrFolderNotFound = errors.New("Secret folder not found")
+)
+
+// IsNotFound reports whether a backend error means that the
+// domain, secret or folder does not exist
+func IsNotFound(err error) bool {
+	return err == ErrDomainNotFound || err == ErrSecretNotFound || err == ErrFolderNotFound
 }
 
 // InitSecretBackend returns an interface implementation
 func InitSecretBackend() (SecretBackend, error) {
-	backendImpl := &Vault{
-		vaultAddress: smsconfig.SMSConfig.BackendAddress,
-		vaultToken:   smsconfig.SMSConfig.VaultToken,
+	return NewSecretBackend(smsconfig.SMSConfig)
+}
+
+// NewSecretBackend returns the backend implementation selected by
+// the given configuration and initializes the backend if needed
+func NewSecretBackend(conf *smsconfig.SMSConfiguration) (SecretBackend, error) {
+
+	backendImpl, err := newVault(conf)
+	if smslogger.CheckError(err, "InitSecretBackend") != nil {
+		return nil, err
 	}
 
-	err := backendImpl.Init()
+	err = backendImpl.Init()
 	if smslogger.CheckError(err, "InitSecretBackend") != nil {
 		return nil, err
 	}
@@ -67,6 +114,43 @@ func InitSecretBackend() (SecretBackend, error) {
 	return backendImpl, nil
 }
 
+// OpenSecretBackend returns the backend selected by the given
+// configuration for the maintenance commands. Unlike NewSecretBackend
+// it never initializes the backend and fails if the backend is not
+// initialized or sealed
+func OpenSecretBackend(conf *smsconfig.SMSConfiguration) (SecretBackend, error) {
+
+	backendImpl, err := newVault(conf)
+	if smslogger.CheckError(err, "OpenSecretBackend") != nil {
+		return nil, err
+	}
+
+	err = backendImpl.open()
+	if smslogger.CheckError(err, "OpenSecretBackend") != nil {
+		return nil, err
+	}
+
+	return backendImpl, nil
+}
+
+// newVault creates a Vault backend that is not yet connected
+func newVault(conf *smsconfig.SMSConfiguration) (*Vault, error) {
+	if conf.Backend != "" && conf.Backend != "vault" {
+		return nil, errors.New("Unsupported secret backend: " + conf.Backend)
+	}
+
+	backendImpl := &Vault{
+		vaultAddress: conf.BackendAddress,
+		vaultToken:   conf.VaultToken,
+		authDir:      conf.AuthDir,
+	}
+	// The backend keeps its own copy of the token until initRole
+	// has consumed it. Do not keep it around in the configuration
+	conf.VaultToken = ""
+
+	return backendImpl, nil
+}
+
 // LoginBackend Interface that will be implemented for various login backends
 type LoginBackend interface {
 }
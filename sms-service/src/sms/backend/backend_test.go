@@ -17,8 +17,81 @@
 package backend
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	smsconfig "sms/config"
 )
 
 func TestInitSecretBackend(t *testing.T) {
 }
+
+func TestIsNotFound(t *testing.T) {
+
+	for _, err := range []error{ErrDomainNotFound, ErrSecretNotFound, ErrFolderNotFound} {
+		if !IsNotFound(err) {
+			t.Fatal("IsNotFound: Expected true for " + err.Error())
+		}
+	}
+	// Other errors that mention not found, such as from a backend
+	// that is not reachable, are not a missing secret
+	if IsNotFound(errors.New("Token file not found")) || IsNotFound(nil) {
+		t.Fatal("IsNotFound: Expected false for other errors")
+	}
+}
+
+func TestOpenSecretBackend(t *testing.T) {
+
+	var initialized, sealed bool
+	var written []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			written = append(written, r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/v1/sys/init":
+			if initialized {
+				w.Write([]byte(`{"initialized": true}`))
+			} else {
+				w.Write([]byte(`{"initialized": false}`))
+			}
+		case "/v1/sys/seal-status":
+			if sealed {
+				w.Write([]byte(`{"sealed": true, "t": 3, "n": 3}`))
+			} else {
+				w.Write([]byte(`{"sealed": false, "t": 3, "n": 3}`))
+			}
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	conf := func() *smsconfig.SMSConfiguration {
+		return &smsconfig.SMSConfiguration{BackendAddress: srv.URL, AuthDir: "/nonexistent"}
+	}
+
+	_, err := OpenSecretBackend(conf())
+	if err == nil || !strings.Contains(err.Error(), "not initialized") {
+		t.Fatal("OpenSecretBackend: Expected error for uninitialized Vault")
+	}
+	if len(written) != 0 {
+		t.Fatal("OpenSecretBackend: Must not write to an uninitialized Vault")
+	}
+
+	initialized, sealed = true, true
+	_, err = OpenSecretBackend(conf())
+	if err == nil || !strings.Contains(err.Error(), "sealed") {
+		t.Fatal("OpenSecretBackend: Expected error for sealed Vault")
+	}
+
+	conf2 := conf()
+	conf2.Backend = "unknown"
+	_, err = OpenSecretBackend(conf2)
+	if err == nil {
+		t.Fatal("OpenSecretBackend: Expected error for unsupported backend")
+	}
+}
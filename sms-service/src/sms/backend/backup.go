@@ -0,0 +1,295 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	smsauth "sms/auth"
+	smslogger "sms/log"
+)
+
+// BackupVersion is the format version of full instance backups
+const BackupVersion = 1
+
+// ErrBackendNotEmpty is returned by RestoreBackup when the target
+// backend already has secret domains
+var ErrBackendNotEmpty = errors.New("Backend already contains secret domains")
+
+// DomainBackup holds a domain with its UUID and all its secrets.
+// Checksum is computed over the secrets
+type DomainBackup struct {
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+	Checksum string    `json:"checksum"`
+	Secrets  []Secret  `json:"secrets"`
+}
+
+// Backup is a point in time copy of all secret domains. Vault
+// policies are not included; they are not part of the SecretBackend
+// interface and SMS creates its own policy again on a new backend
+type Backup struct {
+	Version int            `json:"version"`
+	Created string         `json:"created"`
+	Domains []DomainBackup `json:"domains"`
+}
+
+// BackupArchive is the encrypted form of a Backup that is written
+// to disk. Checksum covers Data so that an archive can be checked
+// without the private key
+type BackupArchive struct {
+	Version  int    `json:"version"`
+	Created  string `json:"created"`
+	Domains  int    `json:"domains"`
+	Checksum string `json:"checksum"`
+	Data     string `json:"data"`
+}
+
+// RestoreResult reports what RestoreBackup created or, for a dry
+// run, would have created
+type RestoreResult struct {
+	Domains []string `json:"domains"`
+	Secrets int      `json:"secrets"`
+}
+
+func sha256String(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checksum returns the checksum of the secrets in the domain.
+// Secrets are sorted by name and encoding/json sorts map keys
+// so the result does not depend on backend ordering
+func (d DomainBackup) checksum() (string, error) {
+
+	secrets := make([]Secret, len(d.Secrets))
+	copy(secrets, d.Secrets)
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return "", err
+	}
+	return sha256String(data), nil
+}
+
+// CreateBackup reads all secret domains with their UUIDs and secrets.
+// Backend policies are not read
+func CreateBackup(b SecretBackend) (Backup, error) {
+
+	backup := Backup{
+		Version: BackupVersion,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Domains: []DomainBackup{},
+	}
+
+	doms, err := b.ListSecretDomain()
+	if smslogger.CheckError(err, "CreateBackup") != nil {
+		return Backup{}, err
+	}
+	sort.Strings(doms)
+
+	for _, name := range doms {
+		d, err := backupDomain(b, name)
+		if smslogger.CheckError(err, "CreateBackup") != nil {
+			return Backup{}, err
+		}
+		backup.Domains = append(backup.Domains, d)
+	}
+
+	return backup, nil
+}
+
+// backupDomain reads the UUID, metadata and all secrets of one domain
+func backupDomain(b SecretBackend, name string) (DomainBackup, error) {
+
+	dom, err := b.GetSecretDomain(name)
+	if err != nil {
+		return DomainBackup{}, err
+	}
+
+	d := DomainBackup{UUID: dom.UUID, Name: dom.Name, Metadata: dom.Metadata, Secrets: []Secret{}}
+	names, err := listDomainSecrets(b, name)
+	if err != nil {
+		return DomainBackup{}, err
+	}
+
+	for _, secName := range names {
+		sec, err := b.GetSecret(name, secName)
+		if err != nil {
+			return DomainBackup{}, err
+		}
+		d.Secrets = append(d.Secrets, sec)
+	}
+
+	d.Checksum, err = d.checksum()
+	if err != nil {
+		return DomainBackup{}, err
+	}
+	return d, nil
+}
+
+// restoreDomain recreates a domain with its UUID, metadata and secrets
+func restoreDomain(b SecretBackend, d DomainBackup) error {
+
+	err := b.RestoreSecretDomain(SecretDomain{UUID: d.UUID, Name: d.Name, Metadata: d.Metadata})
+	if err != nil {
+		return err
+	}
+
+	for _, sec := range d.Secrets {
+		err = b.CreateSecret(d.Name, sec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks the version and the checksum of every domain
+func (bk Backup) Verify() error {
+
+	if bk.Version != BackupVersion {
+		return errors.New("Unsupported backup version")
+	}
+
+	seen := map[string]bool{}
+	for _, d := range bk.Domains {
+		if d.Name == "" || d.UUID == "" {
+			return errors.New("Domain without name or UUID in backup")
+		}
+		if seen[d.Name] {
+			return errors.New("Duplicate domain in backup: " + d.Name)
+		}
+		seen[d.Name] = true
+
+		sum, err := d.checksum()
+		if err != nil {
+			return err
+		}
+		if sum != d.Checksum {
+			return errors.New("Checksum mismatch for domain: " + d.Name)
+		}
+	}
+
+	return nil
+}
+
+// SealBackup encrypts a backup with a base64 encoded PGP public key
+func SealBackup(bk Backup, pbKey string) (BackupArchive, error) {
+
+	data, err := json.Marshal(bk)
+	if smslogger.CheckError(err, "SealBackup") != nil {
+		return BackupArchive{}, err
+	}
+
+	enc, err := smsauth.EncryptPGPString(string(data), strings.TrimSpace(pbKey))
+	if smslogger.CheckError(err, "SealBackup") != nil {
+		return BackupArchive{}, err
+	}
+
+	return BackupArchive{
+		Version:  bk.Version,
+		Created:  bk.Created,
+		Domains:  len(bk.Domains),
+		Checksum: sha256String([]byte(enc)),
+		Data:     enc,
+	}, nil
+}
+
+// Verify checks the checksum of the encrypted data
+func (a BackupArchive) Verify() error {
+
+	if a.Version != BackupVersion {
+		return errors.New("Unsupported backup version")
+	}
+	if sha256String([]byte(a.Data)) != a.Checksum {
+		return errors.New("Checksum mismatch for backup archive")
+	}
+	return nil
+}
+
+// OpenBackup verifies and decrypts an archive with a base64
+// encoded PGP private key
+func OpenBackup(a BackupArchive, prKey string) (Backup, error) {
+
+	err := a.Verify()
+	if smslogger.CheckError(err, "OpenBackup") != nil {
+		return Backup{}, err
+	}
+
+	data, err := smsauth.DecryptPGPString(a.Data, strings.TrimSpace(prKey))
+	if smslogger.CheckError(err, "OpenBackup") != nil {
+		return Backup{}, errors.New("Unable to decrypt backup archive")
+	}
+
+	var bk Backup
+	err = json.Unmarshal([]byte(data), &bk)
+	if smslogger.CheckError(err, "OpenBackup") != nil {
+		return Backup{}, errors.New("Invalid backup archive")
+	}
+
+	err = bk.Verify()
+	if smslogger.CheckError(err, "OpenBackup") != nil {
+		return Backup{}, err
+	}
+
+	return bk, nil
+}
+
+// RestoreBackup recreates all domains of a backup with their UUIDs
+// and secrets. The backend must not have any secret domains.
+// With dryRun set only the checks are done and nothing is written
+func RestoreBackup(b SecretBackend, bk Backup, dryRun bool) (RestoreResult, error) {
+
+	result := RestoreResult{Domains: []string{}}
+
+	err := bk.Verify()
+	if smslogger.CheckError(err, "RestoreBackup") != nil {
+		return result, err
+	}
+
+	doms, err := b.ListSecretDomain()
+	if smslogger.CheckError(err, "RestoreBackup") != nil {
+		return result, err
+	}
+	if len(doms) > 0 {
+		return result, ErrBackendNotEmpty
+	}
+
+	for _, d := range bk.Domains {
+		if !dryRun {
+			err = restoreDomain(b, d)
+			if smslogger.CheckError(err, "RestoreBackup") != nil {
+				return result, err
+			}
+		}
+		result.Domains = append(result.Domains, d.Name)
+		result.Secrets += len(d.Secrets)
+	}
+
+	return result, nil
+}
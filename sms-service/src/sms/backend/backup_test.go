@@ -0,0 +1,221 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	smsauth "sms/auth"
+)
+
+// memBackend is an in memory SecretBackend used to test functions
+// that only work through the interface
+type memBackend struct {
+	SecretBackend
+	uuids   map[string]string
+	meta    map[string]*Metadata
+	secrets map[string]map[string]Secret
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{
+		uuids:   map[string]string{},
+		meta:    map[string]*Metadata{},
+		secrets: map[string]map[string]Secret{},
+	}
+}
+
+func (m *memBackend) ListSecretDomain() ([]string, error) {
+	doms := []string{}
+	for d := range m.uuids {
+		doms = append(doms, d)
+	}
+	sort.Strings(doms)
+	return doms, nil
+}
+
+func (m *memBackend) GetSecretDomain(name string) (SecretDomain, error) {
+	id, ok := m.uuids[name]
+	if !ok {
+		return SecretDomain{}, ErrDomainNotFound
+	}
+	return SecretDomain{UUID: id, Name: name, Metadata: m.meta[name]}, nil
+}
+
+func (m *memBackend) CreateSecretDomain(name string) (SecretDomain, error) {
+	dom := SecretDomain{UUID: "uuid-" + name, Name: name}
+	return dom, m.RestoreSecretDomain(dom)
+}
+
+func (m *memBackend) RestoreSecretDomain(dom SecretDomain) error {
+	if _, ok := m.uuids[dom.Name]; ok {
+		return errors.New("existing domain")
+	}
+	m.uuids[dom.Name] = dom.UUID
+	m.meta[dom.Name] = dom.Metadata
+	m.secrets[dom.Name] = map[string]Secret{}
+	return nil
+}
+
+func (m *memBackend) UpdateSecretDomain(dom SecretDomain) error {
+	if _, ok := m.uuids[dom.Name]; !ok {
+		return ErrDomainNotFound
+	}
+	m.meta[dom.Name] = dom.Metadata
+	return nil
+}
+
+func (m *memBackend) ListSecret(dom string) ([]string, error) {
+	secs, ok := m.secrets[dom]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	names := []string{}
+	for n := range secs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memBackend) GetSecret(dom string, name string) (Secret, error) {
+	sec, ok := m.secrets[dom][name]
+	if !ok {
+		return Secret{}, ErrSecretNotFound
+	}
+	return sec, nil
+}
+
+func (m *memBackend) CreateSecret(dom string, sec Secret) error {
+	if _, ok := m.secrets[dom]; !ok {
+		return errors.New("Unable to create Secret at provided path")
+	}
+	m.secrets[dom][sec.Name] = sec
+	return nil
+}
+
+func (m *memBackend) DeleteSecret(dom string, name string) error {
+	delete(m.secrets[dom], name)
+	return nil
+}
+
+func (m *memBackend) DeleteSecretDomain(name string) error {
+	delete(m.uuids, name)
+	delete(m.meta, name)
+	delete(m.secrets, name)
+	return nil
+}
+
+func populateBackend(t *testing.T, m *memBackend) {
+	for _, d := range []string{"dom1", "dom2", "empty"} {
+		_, err := m.CreateSecretDomain(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.CreateSecret("dom1", Secret{Name: "b", Values: map[string]interface{}{"user": "admin"}})
+	m.CreateSecret("dom1", Secret{Name: "a", Values: map[string]interface{}{"passwd": "secret"}})
+	m.CreateSecret("dom2", Secret{Name: "c", Values: map[string]interface{}{"port": "8080"}})
+}
+
+func TestBackupRestore(t *testing.T) {
+
+	src := newMemBackend()
+	populateBackend(t, src)
+
+	bk, err := CreateBackup(src)
+	if err != nil {
+		t.Fatal("CreateBackup: Error creating backup")
+	}
+	if len(bk.Domains) != 3 || bk.Domains[0].UUID != "uuid-dom1" {
+		t.Fatal("CreateBackup: Returned incorrect domains")
+	}
+
+	pbkey, prkey, err := smsauth.GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := SealBackup(bk, pbkey)
+	if err != nil {
+		t.Fatal("SealBackup: Error encrypting backup")
+	}
+	if strings.Contains(archive.Data, "secret") || archive.Verify() != nil {
+		t.Fatal("SealBackup: Returned invalid archive")
+	}
+
+	opened, err := OpenBackup(archive, prkey)
+	if err != nil || reflect.DeepEqual(opened, bk) == false {
+		t.Fatal("OpenBackup: Decrypted backup does not match")
+	}
+
+	dst := newMemBackend()
+	res, err := RestoreBackup(dst, opened, true)
+	if err != nil || len(res.Domains) != 3 || res.Secrets != 3 {
+		t.Fatal("RestoreBackup: Incorrect dry run result")
+	}
+	if len(dst.uuids) != 0 {
+		t.Fatal("RestoreBackup: Dry run wrote to the backend")
+	}
+
+	_, err = RestoreBackup(dst, opened, false)
+	if err != nil {
+		t.Fatal("RestoreBackup: Error restoring backup")
+	}
+	if reflect.DeepEqual(dst.uuids, src.uuids) == false ||
+		reflect.DeepEqual(dst.secrets, src.secrets) == false {
+		t.Fatal("RestoreBackup: Restored backend does not match")
+	}
+
+	_, err = RestoreBackup(dst, opened, true)
+	if err != ErrBackendNotEmpty {
+		t.Fatal("RestoreBackup: Expected error for non empty backend")
+	}
+}
+
+func TestBackupChecksums(t *testing.T) {
+
+	src := newMemBackend()
+	populateBackend(t, src)
+	bk, err := CreateBackup(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.Domains[0].Secrets[0].Values = map[string]interface{}{"passwd": "changed"}
+	if bk.Verify() == nil {
+		t.Fatal("Verify: Expected checksum mismatch for modified secret")
+	}
+
+	pbkey, prkey, err := smsauth.GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bk, _ = CreateBackup(src)
+	archive, err := SealBackup(bk, pbkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Data = archive.Data[:len(archive.Data)-4] + "AAAA"
+	_, err = OpenBackup(archive, prkey)
+	if err == nil || archive.Verify() == nil {
+		t.Fatal("OpenBackup: Expected checksum mismatch for modified archive")
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	smsconfig "sms/config"
+	smslogger "sms/log"
+)
+
+// ErrBatchAborted is the result of items that were not run, or whose
+// changes were undone, because another item of an atomic batch failed
+var ErrBatchAborted = errors.New("Aborted because another item in the batch failed")
+
+// Default batch limits used when no configuration has been loaded
+const (
+	defaultMaxBatchItems    = 100
+	defaultBatchConcurrency = 8
+)
+
+// BatchResult is the outcome of one item of a batch operation.
+// Secret is set for reads and Domain for created domains
+type BatchResult struct {
+	Name    string
+	Version string
+	Secret  *Secret
+	Domain  *SecretDomain
+	Err     error
+}
+
+// batchLimits returns the configured item limit and concurrency
+func batchLimits() (int, int) {
+	maxItems, workers := defaultMaxBatchItems, defaultBatchConcurrency
+	if conf := smsconfig.Current(); conf != nil {
+		if conf.MaxBatchItems > 0 {
+			maxItems = conf.MaxBatchItems
+		}
+		if conf.BatchConcurrency > 0 {
+			workers = conf.BatchConcurrency
+		}
+	}
+	return maxItems, workers
+}
+
+// checkBatchNames trims the names in place and rejects empty batches,
+// batches over the item limit and names that appear more than once
+// or are rejected by check
+func checkBatchNames(names []string, check func(string) error) error {
+
+	maxItems, _ := batchLimits()
+	if len(names) == 0 {
+		return &ValidationError{Message: "Batch has no items"}
+	}
+	if len(names) > maxItems {
+		return &ValidationError{Message: "Batch has more than the allowed items", TooLarge: true}
+	}
+
+	seen := map[string]bool{}
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		n := names[i]
+		if n == "" {
+			return invalidSecret("Batch item without a name")
+		}
+		if seen[n] {
+			return invalidSecret("Duplicate name in batch: %s", n)
+		}
+		if check != nil {
+			err := check(n)
+			if err != nil {
+				return err
+			}
+		}
+		seen[n] = true
+	}
+	return nil
+}
+
+// runBatch calls do for every item using at most the configured
+// number of concurrent workers. With stopOnError set, items that
+// have not started when an item fails are not run
+func runBatch(n int, stopOnError bool, do func(i int) error) []error {
+
+	_, workers := batchLimits()
+	errs := make([]error, n)
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := stopOnError && failed
+		mu.Unlock()
+		if stop {
+			<-sem
+			errs[i] = ErrBatchAborted
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := do(i)
+			if err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+			errs[i] = err
+		}(i)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// rollbackBatch undoes the items that succeeded when any item of an
+// atomic batch failed and reports whether a rollback happened.
+// Undone items are marked as aborted; an item that could not be
+// undone keeps the rollback error
+func rollbackBatch(errs []error, undo func(i int) error) bool {
+
+	ok := []int{}
+	for i, err := range errs {
+		if err == nil {
+			ok = append(ok, i)
+		}
+	}
+	if len(ok) == len(errs) {
+		return false
+	}
+
+	undoErrs := runBatch(len(ok), false, func(j int) error {
+		return undo(ok[j])
+	})
+	for j, i := range ok {
+		errs[i] = ErrBatchAborted
+		if smslogger.CheckError(undoErrs[j], "Batch rollback") != nil {
+			errs[i] = errors.New("Rollback failed: " + undoErrs[j].Error())
+		}
+	}
+	return true
+}
+
+// batchResults builds one result per name from the item errors
+func batchResults(names []string, errs []error) []BatchResult {
+
+	results := make([]BatchResult, len(names))
+	for i, n := range names {
+		results[i] = BatchResult{Name: n, Err: errs[i]}
+	}
+	return results
+}
+
+// CreateSecrets writes many secrets to a domain for the client by.
+// Every secret is validated first. With atomic set nothing is written unless all
+// secrets are valid, and if any write fails the secrets already
+// written are deleted again or, when they replaced an existing
+// secret, restored to their previous values
+func CreateSecrets(b SecretBackend, dom string, secrets []Secret, atomic bool, by string) ([]BatchResult, bool, error) {
+
+	names := make([]string, len(secrets))
+	for i, sec := range secrets {
+		names[i] = sec.Name
+	}
+	err := checkBatchNames(names, ValidateSecretName)
+	if smslogger.CheckError(err, "CreateSecrets") != nil {
+		return nil, false, err
+	}
+	// Secrets are stored and locked under the checked name
+	for i := range secrets {
+		secrets[i].Name = names[i]
+	}
+
+	errs := make([]error, len(secrets))
+	invalid := false
+	for i, sec := range secrets {
+		errs[i] = sec.Validate()
+		invalid = invalid || errs[i] != nil
+	}
+	if atomic && invalid {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = ErrBatchAborted
+			}
+		}
+		return batchResults(names, errs), false, nil
+	}
+
+	// Previous values of replaced secrets, kept for the rollback
+	previous := make([]*Secret, len(secrets))
+	writeErrs := runBatch(len(secrets), atomic, func(i int) error {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		defer lockSecret(dom, secrets[i].Name)()
+
+		prev, err := b.GetSecret(dom, secrets[i].Name)
+		if err == nil {
+			previous[i] = &prev
+		} else if !IsNotFound(err) {
+			return err
+		}
+		return b.CreateSecret(dom, stampSecret(secrets[i], previous[i], by))
+	})
+
+	rolledBack := false
+	if atomic {
+		rolledBack = rollbackBatch(writeErrs, func(i int) error {
+			defer lockSecret(dom, secrets[i].Name)()
+			if previous[i] != nil {
+				return b.CreateSecret(dom, *previous[i])
+			}
+			return b.DeleteSecret(dom, secrets[i].Name)
+		})
+	}
+
+	results := batchResults(names, writeErrs)
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Version = SecretVersion(secrets[i])
+		}
+	}
+	return results, rolledBack, nil
+}
+
+// GetSecrets reads many secrets of a domain
+func GetSecrets(b SecretBackend, dom string, names []string) ([]BatchResult, error) {
+
+	err := checkBatchNames(names, CheckSecretName)
+	if smslogger.CheckError(err, "GetSecrets") != nil {
+		return nil, err
+	}
+
+	secrets := make([]Secret, len(names))
+	errs := runBatch(len(names), false, func(i int) error {
+		var err error
+		secrets[i], err = b.GetSecret(dom, names[i])
+		return err
+	})
+
+	results := batchResults(names, errs)
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Secret = &secrets[i]
+			results[i].Version = SecretVersion(secrets[i])
+		}
+	}
+	return results, nil
+}
+
+// DeleteSecrets deletes many secrets of a domain. With atomic set
+// every secret must exist, and if any delete fails the secrets
+// already deleted are written back
+func DeleteSecrets(b SecretBackend, dom string, names []string, atomic bool) ([]BatchResult, bool, error) {
+
+	err := checkBatchNames(names, CheckSecretName)
+	if smslogger.CheckError(err, "DeleteSecrets") != nil {
+		return nil, false, err
+	}
+
+	previous := make([]Secret, len(names))
+	errs := runBatch(len(names), atomic, func(i int) error {
+		name := names[i]
+		defer lockSecret(dom, name)()
+
+		if atomic {
+			var err error
+			previous[i], err = b.GetSecret(dom, name)
+			if err != nil {
+				return err
+			}
+		}
+		return b.DeleteSecret(dom, name)
+	})
+
+	rolledBack := false
+	if atomic {
+		rolledBack = rollbackBatch(errs, func(i int) error {
+			defer lockSecret(dom, previous[i].Name)()
+			return b.CreateSecret(dom, previous[i])
+		})
+	}
+
+	return batchResults(names, errs), rolledBack, nil
+}
+
+// CreateSecretDomains creates many secret domains for the client by.
+// With atomic set the domains already created are deleted again if
+// any of them could not be created
+func CreateSecretDomains(b SecretBackend, names []string, atomic bool, by string) ([]BatchResult, bool, error) {
+
+	err := checkBatchNames(names, ValidateDomainName)
+	if smslogger.CheckError(err, "CreateSecretDomains") != nil {
+		return nil, false, err
+	}
+
+	doms := make([]SecretDomain, len(names))
+	errs := runBatch(len(names), atomic, func(i int) error {
+		var err error
+		doms[i], err = CreateDomain(b, SecretDomain{Name: names[i]}, by)
+		return err
+	})
+
+	rolledBack := false
+	if atomic {
+		rolledBack = rollbackBatch(errs, func(i int) error {
+			return b.DeleteSecretDomain(doms[i].Name)
+		})
+	}
+
+	results := batchResults(names, errs)
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Domain = &doms[i]
+		}
+	}
+	return results, rolledBack, nil
+}
+
+// DeleteSecretDomains deletes many secret domains with their
+// secrets. With atomic set every domain must exist and is read
+// before it is deleted, so that domains already deleted can be
+// recreated with their UUIDs and secrets if any delete fails
+func DeleteSecretDomains(b SecretBackend, names []string, atomic bool) ([]BatchResult, bool, error) {
+
+	err := checkBatchNames(names, CheckDomainName)
+	if smslogger.CheckError(err, "DeleteSecretDomains") != nil {
+		return nil, false, err
+	}
+
+	previous := make([]DomainBackup, len(names))
+	errs := runBatch(len(names), atomic, func(i int) error {
+		name := names[i]
+		if atomic {
+			var err error
+			previous[i], err = backupDomain(b, name)
+			if err != nil {
+				return err
+			}
+		}
+		return b.DeleteSecretDomain(name)
+	})
+
+	rolledBack := false
+	if atomic {
+		rolledBack = rollbackBatch(errs, func(i int) error {
+			return restoreDomain(b, previous[i])
+		})
+	}
+
+	return batchResults(names, errs), rolledBack, nil
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// syncBackend serializes calls so that memBackend can be used by
+// the concurrent batch functions. Writes to names listed in fail
+// return an error
+type syncBackend struct {
+	*memBackend
+	mu   sync.Mutex
+	fail map[string]bool
+}
+
+func newSyncBackend(t *testing.T, fail ...string) *syncBackend {
+	s := &syncBackend{memBackend: newMemBackend(), fail: map[string]bool{}}
+	populateBackend(t, s.memBackend)
+	for _, f := range fail {
+		s.fail[f] = true
+	}
+	return s
+}
+
+func (s *syncBackend) GetSecretDomain(name string) (SecretDomain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memBackend.GetSecretDomain(name)
+}
+
+func (s *syncBackend) CreateSecretDomain(name string) (SecretDomain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[name] {
+		return SecretDomain{}, errors.New("Unable to create Secret Domain")
+	}
+	return s.memBackend.CreateSecretDomain(name)
+}
+
+func (s *syncBackend) RestoreSecretDomain(dom SecretDomain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memBackend.RestoreSecretDomain(dom)
+}
+
+func (s *syncBackend) UpdateSecretDomain(dom SecretDomain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memBackend.UpdateSecretDomain(dom)
+}
+
+func (s *syncBackend) DeleteSecretDomain(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[name] {
+		return errors.New("Unable to delete Secret Domain")
+	}
+	return s.memBackend.DeleteSecretDomain(name)
+}
+
+func (s *syncBackend) ListSecret(dom string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memBackend.ListSecret(dom)
+}
+
+func (s *syncBackend) GetSecret(dom string, name string) (Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memBackend.GetSecret(dom, name)
+}
+
+func (s *syncBackend) CreateSecret(dom string, sec Secret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[sec.Name] {
+		return errors.New("Unable to create Secret at provided path")
+	}
+	return s.memBackend.CreateSecret(dom, sec)
+}
+
+func (s *syncBackend) DeleteSecret(dom string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[name] {
+		return errors.New("Unable to delete Secret at provided path")
+	}
+	return s.memBackend.DeleteSecret(dom, name)
+}
+
+func testSecrets(names ...string) []Secret {
+	secs := []Secret{}
+	for _, n := range names {
+		secs = append(secs, Secret{Name: n, Values: map[string]interface{}{"value": n}})
+	}
+	return secs
+}
+
+func TestCreateSecrets(t *testing.T) {
+
+	b := newSyncBackend(t, "bad")
+	secs := append(testSecrets("x", "y", "bad"), Secret{Name: "invalid", Types: map[string]string{"k": "string"}})
+
+	results, rolledBack, err := CreateSecrets(b, "dom1", secs, false, "")
+	if err != nil || rolledBack {
+		t.Fatal("CreateSecrets: Error creating secrets")
+	}
+	if results[0].Err != nil || results[1].Err != nil || results[0].Version != SecretVersion(secs[0]) {
+		t.Fatal("CreateSecrets: Valid secrets were not created")
+	}
+	if results[2].Err == nil || results[3].Err == nil {
+		t.Fatal("CreateSecrets: Expected errors for failing secrets")
+	}
+	if _, ok := results[3].Err.(*ValidationError); !ok {
+		t.Fatal("CreateSecrets: Expected validation error for invalid secret")
+	}
+	if _, err := b.GetSecret("dom1", "y"); err != nil {
+		t.Fatal("CreateSecrets: Secret was not written")
+	}
+
+	// The existing secret a must get its old values back
+	b = newSyncBackend(t, "bad")
+	old, _ := b.GetSecret("dom1", "a")
+	results, rolledBack, err = CreateSecrets(b, "dom1", testSecrets("a", "x", "bad"), true, "")
+	if err != nil || !rolledBack {
+		t.Fatal("CreateSecrets: Expected atomic batch to be rolled back")
+	}
+	if results[0].Err != ErrBatchAborted || results[2].Err == ErrBatchAborted {
+		t.Fatal("CreateSecrets: Returned incorrect results for rollback")
+	}
+	if _, err := b.GetSecret("dom1", "x"); err == nil {
+		t.Fatal("CreateSecrets: Created secret was not removed")
+	}
+	sec, _ := b.GetSecret("dom1", "a")
+	if !reflect.DeepEqual(sec, old) {
+		t.Fatal("CreateSecrets: Replaced secret was not restored")
+	}
+
+	results, _, _ = CreateSecrets(b, "dom1", secs[3:], true, "")
+	if _, ok := results[0].Err.(*ValidationError); !ok {
+		t.Fatal("CreateSecrets: Expected validation error in atomic batch")
+	}
+
+	_, _, err = CreateSecrets(b, "dom1", testSecrets("x", "x"), false, "")
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("CreateSecrets: Expected error for duplicate names")
+	}
+}
+
+func TestGetDeleteSecrets(t *testing.T) {
+
+	b := newSyncBackend(t)
+	results, err := GetSecrets(b, "dom1", []string{"a", "missing", "b"})
+	if err != nil {
+		t.Fatal("GetSecrets: Error reading secrets")
+	}
+	if results[0].Secret == nil || results[0].Secret.Values["passwd"] != "secret" {
+		t.Fatal("GetSecrets: Returned incorrect secret")
+	}
+	if !IsNotFound(results[1].Err) || results[2].Err != nil {
+		t.Fatal("GetSecrets: Returned incorrect results")
+	}
+
+	_, rolledBack, err := DeleteSecrets(b, "dom1", []string{"a", "missing"}, true)
+	if err != nil || !rolledBack {
+		t.Fatal("DeleteSecrets: Expected atomic batch to be rolled back")
+	}
+	if _, err := b.GetSecret("dom1", "a"); err != nil {
+		t.Fatal("DeleteSecrets: Deleted secret was not restored")
+	}
+
+	results, rolledBack, err = DeleteSecrets(b, "dom1", []string{"a", "b"}, true)
+	if err != nil || rolledBack || results[0].Err != nil || results[1].Err != nil {
+		t.Fatal("DeleteSecrets: Error deleting secrets")
+	}
+	if names, _ := b.ListSecret("dom1"); len(names) != 0 {
+		t.Fatal("DeleteSecrets: Secrets were not deleted")
+	}
+}
+
+func TestBatchSecretNamesTrimmed(t *testing.T) {
+
+	b := newSyncBackend(t)
+	results, _, err := CreateSecrets(b, "dom1", testSecrets(" x "), false, "")
+	if err != nil || results[0].Err != nil || results[0].Name != "x" {
+		t.Fatal("CreateSecrets: Error creating secret with surrounding spaces")
+	}
+	if _, err := b.GetSecret("dom1", "x"); err != nil {
+		t.Fatal("CreateSecrets: Secret was not stored under the trimmed name")
+	}
+	if _, err := b.GetSecret("dom1", " x "); err == nil {
+		t.Fatal("CreateSecrets: Secret was stored under the untrimmed name")
+	}
+
+	results, err = GetSecrets(b, "dom1", []string{" x "})
+	if err != nil || results[0].Err != nil || results[0].Secret.Name != "x" {
+		t.Fatal("GetSecrets: Error reading secret with surrounding spaces")
+	}
+
+	results, _, err = DeleteSecrets(b, "dom1", []string{" x "}, false)
+	if err != nil || results[0].Err != nil {
+		t.Fatal("DeleteSecrets: Error deleting secret with surrounding spaces")
+	}
+	if _, err := b.GetSecret("dom1", "x"); err == nil {
+		t.Fatal("DeleteSecrets: Secret was not deleted")
+	}
+}
+
+func TestBatchSecretDomains(t *testing.T) {
+
+	b := newSyncBackend(t, "bad")
+	results, rolledBack, err := CreateSecretDomains(b, []string{"new1", "new2", "bad"}, true, "")
+	if err != nil || !rolledBack || results[2].Err == nil {
+		t.Fatal("CreateSecretDomains: Expected atomic batch to be rolled back")
+	}
+	if _, err := b.GetSecretDomain("new1"); err == nil {
+		t.Fatal("CreateSecretDomains: Created domain was not removed")
+	}
+
+	results, _, err = CreateSecretDomains(b, []string{"new1", "bad"}, false, "")
+	if err != nil || results[0].Domain == nil || results[0].Domain.Name != "new1" || results[1].Err == nil {
+		t.Fatal("CreateSecretDomains: Returned incorrect results")
+	}
+
+	before, _ := CreateBackup(b)
+	_, rolledBack, err = DeleteSecretDomains(b, []string{"dom1", "dom2", "bad"}, true)
+	if err != nil || !rolledBack {
+		t.Fatal("DeleteSecretDomains: Expected atomic batch to be rolled back")
+	}
+	after, _ := CreateBackup(b)
+	if !reflect.DeepEqual(before.Domains, after.Domains) {
+		t.Fatal("DeleteSecretDomains: Deleted domains were not restored")
+	}
+
+	results, _, err = DeleteSecretDomains(b, []string{"dom1", "dom2"}, false)
+	if err != nil || results[0].Err != nil || results[1].Err != nil {
+		t.Fatal("DeleteSecretDomains: Error deleting domains")
+	}
+	doms, _ := b.ListSecretDomain()
+	if !reflect.DeepEqual(doms, []string{"empty", "new1"}) {
+		t.Fatal("DeleteSecretDomains: Returned incorrect domains")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	smslogger "sms/log"
+)
+
+// BundleVersion is the format version of exported domain bundles
+const BundleVersion = 1
+
+// ErrSecretExists is returned by ImportDomain when a secret in the
+// bundle already exists and overwriting was not requested
+var ErrSecretExists = errors.New("Secret already exists in domain")
+
+// DomainBundle holds all the secrets of a domain and its metadata.
+// It is used to move domains between SMS instances
+type DomainBundle struct {
+	Version  int       `json:"version"`
+	Domain   string    `json:"domain"`
+	Exported string    `json:"exported"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+	Secrets  []Secret  `json:"secrets"`
+}
+
+// ExportDomain reads the metadata and all secrets of a domain into
+// a bundle
+func ExportDomain(b SecretBackend, dom string) (DomainBundle, error) {
+
+	dom = strings.TrimSpace(dom)
+	d, err := b.GetSecretDomain(dom)
+	if smslogger.CheckError(err, "ExportDomain") != nil {
+		return DomainBundle{}, err
+	}
+
+	bundle := DomainBundle{
+		Version:  BundleVersion,
+		Domain:   dom,
+		Exported: time.Now().UTC().Format(time.RFC3339),
+		Metadata: d.Metadata,
+		Secrets:  []Secret{},
+	}
+
+	names, err := b.ListSecret(dom)
+	if smslogger.CheckError(err, "ExportDomain") != nil {
+		return DomainBundle{}, err
+	}
+
+	for _, name := range names {
+		sec, err := b.GetSecret(dom, name)
+		if smslogger.CheckError(err, "ExportDomain") != nil {
+			return DomainBundle{}, err
+		}
+		bundle.Secrets = append(bundle.Secrets, sec)
+	}
+
+	return bundle, nil
+}
+
+// ImportDomain stores the secrets of a bundle in the domain dom for
+// the client by. The domain is created with the metadata of the
+// bundle if needed; an existing domain keeps its metadata. Existing
+// secrets are only replaced when overwrite is set. Either all secrets
+// are imported or, if a write fails, the domain is left as it was.
+// It returns the number of imported secrets
+func ImportDomain(b SecretBackend, dom string, bundle DomainBundle, overwrite bool, by string) (int, error) {
+
+	if bundle.Version != BundleVersion {
+		return 0, errors.New("Unsupported bundle version")
+	}
+	err := bundle.Metadata.Validate()
+	if smslogger.CheckError(err, "ImportDomain") != nil {
+		return 0, err
+	}
+	for _, sec := range bundle.Secrets {
+		err = ValidateSecretName(sec.Name)
+		if err == nil {
+			err = sec.Validate()
+		}
+		if smslogger.CheckError(err, "ImportDomain") != nil {
+			return 0, err
+		}
+	}
+
+	dom = strings.TrimSpace(dom)
+	doms, err := b.ListSecretDomain()
+	if smslogger.CheckError(err, "ImportDomain") != nil {
+		return 0, err
+	}
+
+	exists := false
+	for _, d := range doms {
+		if d == dom {
+			exists = true
+			break
+		}
+	}
+
+	if !exists {
+		_, err = CreateDomain(b, SecretDomain{Name: dom, Metadata: bundle.Metadata}, by)
+		if smslogger.CheckError(err, "ImportDomain") != nil {
+			return 0, err
+		}
+	} else if !overwrite {
+		// Check all secrets first so that nothing is written on conflict
+		current, err := b.ListSecret(dom)
+		// An empty domain reports that no secrets were found
+		if err != nil && !IsNotFound(err) {
+			smslogger.WriteError(err.Error())
+			return 0, err
+		}
+		for _, name := range current {
+			for _, sec := range bundle.Secrets {
+				if sec.Name == name {
+					return 0, ErrSecretExists
+				}
+			}
+		}
+	}
+
+	// Previous values of replaced secrets, kept for the rollback
+	previous := make([]*Secret, len(bundle.Secrets))
+	errs := runBatch(len(bundle.Secrets), true, func(i int) error {
+		sec := bundle.Secrets[i]
+		defer lockSecret(dom, sec.Name)()
+
+		prev, err := b.GetSecret(dom, sec.Name)
+		if err == nil {
+			previous[i] = &prev
+		} else if !IsNotFound(err) {
+			return err
+		}
+		return b.CreateSecret(dom, stampSecret(sec, previous[i], by))
+	})
+
+	var failed error
+	for _, err := range errs {
+		if err != nil && err != ErrBatchAborted {
+			failed = err
+			break
+		}
+	}
+	if failed == nil {
+		return len(bundle.Secrets), nil
+	}
+	smslogger.WriteError("ImportDomain: " + failed.Error())
+
+	// A domain created for the import is removed with its secrets
+	if !exists {
+		err = b.DeleteSecretDomain(dom)
+		smslogger.CheckError(err, "ImportDomain rollback")
+		return 0, failed
+	}
+	rollbackBatch(errs, func(i int) error {
+		sec := bundle.Secrets[i]
+		defer lockSecret(dom, sec.Name)()
+		if previous[i] != nil {
+			return b.CreateSecret(dom, *previous[i])
+		}
+		return b.DeleteSecret(dom, sec.Name)
+	})
+	return 0, failed
+}
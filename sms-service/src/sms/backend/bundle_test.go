@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestImportDomainMetadata(t *testing.T) {
+
+	src := newMemBackend()
+	populateBackend(t, src)
+	src.meta["dom1"] = &Metadata{Owner: "team-a", ModifiedBy: "alice"}
+
+	bundle, err := ExportDomain(src, "dom1")
+	if err != nil {
+		t.Fatal("ExportDomain: Error exporting domain")
+	}
+	if bundle.Metadata == nil || bundle.Metadata.Owner != "team-a" {
+		t.Fatal("ExportDomain: Bundle is missing the domain metadata")
+	}
+
+	dst := newSyncBackend(t)
+	count, err := ImportDomain(dst, "copy", bundle, false, "bob")
+	if err != nil || count != 2 {
+		t.Fatal("ImportDomain: Error importing into new domain")
+	}
+	meta := dst.meta["copy"]
+	if meta == nil || meta.Owner != "team-a" || meta.ModifiedBy != "bob" {
+		t.Fatalf("ImportDomain: Unexpected domain metadata %+v", meta)
+	}
+	for _, name := range []string{"a", "b"} {
+		sec, _ := dst.GetSecret("copy", name)
+		if sec.Metadata == nil || sec.Metadata.ModifiedBy != "bob" || sec.Metadata.Created == "" {
+			t.Fatalf("ImportDomain: Secret %s was not stamped", name)
+		}
+	}
+
+	// An existing domain keeps its own metadata
+	dst.meta["dom2"] = &Metadata{Owner: "team-b"}
+	_, err = ImportDomain(dst, "dom2", bundle, false, "bob")
+	if err != nil || dst.meta["dom2"].Owner != "team-b" {
+		t.Fatal("ImportDomain: Metadata of existing domain was replaced")
+	}
+}
+
+func TestImportDomainRollback(t *testing.T) {
+
+	bundle := DomainBundle{
+		Version: BundleVersion,
+		Secrets: []Secret{
+			{Name: "a", Values: map[string]interface{}{"passwd": "new"}},
+			{Name: "new", Values: map[string]interface{}{"user": "new"}},
+			{Name: "z", Values: map[string]interface{}{"user": "new"}},
+		},
+	}
+
+	b := newSyncBackend(t, "z")
+	_, err := ImportDomain(b, "dom1", bundle, true, "bob")
+	if err == nil || err == ErrBatchAborted {
+		t.Fatal("ImportDomain: Expected the failed write to be reported")
+	}
+	sec, _ := b.GetSecret("dom1", "a")
+	if sec.Values["passwd"] != "secret" || sec.Metadata != nil {
+		t.Fatal("ImportDomain: Replaced secret was not restored")
+	}
+	if _, err := b.GetSecret("dom1", "new"); !IsNotFound(err) {
+		t.Fatal("ImportDomain: Imported secret was not removed")
+	}
+
+	_, err = ImportDomain(b, "fresh", bundle, false, "bob")
+	if err == nil {
+		t.Fatal("ImportDomain: Expected the failed write to be reported")
+	}
+	if _, err := b.GetSecretDomain("fresh"); !IsNotFound(err) {
+		t.Fatal("ImportDomain: Created domain was not removed")
+	}
+}
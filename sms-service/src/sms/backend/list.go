@@ -0,0 +1,328 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	smslogger "sms/log"
+)
+
+// Fields that ListSecrets can sort by. A leading - reverses the order
+const (
+	SortName    = "name"
+	SortCreated = "created"
+	SortUpdated = "updated"
+)
+
+// ListOptions selects and orders the secrets returned by ListSecrets.
+// Path is the folder to list, by default the root of the domain.
+// Without Recursive only the secrets directly in the folder and its
+// subfolders, as names ending in a slash, are returned. Prefix and
+// Match apply to the full path and Match is a glob as understood by
+// path.Match. Labels are selectors that secrets must all match, see
+// matchLabels. A Limit of zero returns all remaining secrets
+type ListOptions struct {
+	Path      string
+	Recursive bool
+	Prefix    string
+	Match     string
+	Labels    []string
+	Sort      string
+	Limit     int
+	Cursor    string
+	Metadata  bool
+}
+
+// SecretInfo describes a secret without its values
+type SecretInfo struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Created     string            `json:"created,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	ModifiedBy  string            `json:"modifiedby,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
+}
+
+// SecretList is one page of secrets. Next is the cursor for the
+// following page and is empty on the last page
+type SecretList struct {
+	Secrets []SecretInfo
+	Next    string
+}
+
+// listCursor is the position after the last secret of a page. It
+// carries the sort order so it cannot be used with another one
+type listCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	Name string `json:"n"`
+}
+
+// sortField returns the field and direction of the sort option
+func (o ListOptions) sortField() (string, bool, error) {
+
+	field := strings.TrimPrefix(o.Sort, "-")
+	desc := strings.HasPrefix(o.Sort, "-")
+	switch field {
+	case "":
+		return SortName, desc, nil
+	case SortName, SortCreated, SortUpdated:
+		return field, desc, nil
+	}
+	return "", false, invalidSecret("Unknown sort order: %s", o.Sort)
+}
+
+func sortKey(field string, info SecretInfo) string {
+	switch field {
+	case SortCreated:
+		return info.Created
+	case SortUpdated:
+		return info.Updated
+	}
+	return ""
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, sortOrder string) (*listCursor, error) {
+
+	if s == "" {
+		return nil, nil
+	}
+
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Sort != sortOrder {
+		return nil, invalidSecret("Invalid cursor")
+	}
+	return &c, nil
+}
+
+// loadSecretInfo reads the version and metadata of the secrets.
+// Secrets that were deleted since they were listed are dropped and
+// folders are kept as they are
+func loadSecretInfo(b SecretBackend, dom string, infos []SecretInfo) ([]SecretInfo, error) {
+
+	errs := runBatch(len(infos), false, func(i int) error {
+		if strings.HasSuffix(infos[i].Name, "/") {
+			return nil
+		}
+		sec, err := b.GetSecret(dom, infos[i].Name)
+		if err != nil {
+			return err
+		}
+		infos[i].Version = SecretVersion(sec)
+		if m := sec.Metadata; m != nil {
+			infos[i].Description = m.Description
+			infos[i].Owner = m.Owner
+			infos[i].Labels = m.Labels
+			infos[i].Created = m.Created
+			infos[i].Updated = m.Updated
+			infos[i].ModifiedBy = m.ModifiedBy
+			infos[i].Expires = m.Expires
+		}
+		return nil
+	})
+
+	loaded := make([]SecretInfo, 0, len(infos))
+	for i, err := range errs {
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, infos[i])
+	}
+	return loaded, nil
+}
+
+// filterLabels returns the secrets that match the label selectors.
+// Folders have no labels, so they never match
+func filterLabels(infos []SecretInfo, selectors []string) []SecretInfo {
+
+	matched := infos[:0]
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name, "/") && matchLabels(&Metadata{Labels: info.Labels}, selectors) {
+			matched = append(matched, info)
+		}
+	}
+	return matched
+}
+
+// loadMatching reads the secrets in the given order until more than
+// limit of them match the label selectors, so that a page selected by
+// label does not read the whole domain. A limit of zero reads all
+func loadMatching(b SecretBackend, dom string, infos []SecretInfo, selectors []string, limit int) ([]SecretInfo, error) {
+
+	matched := []SecretInfo{}
+	for len(infos) > 0 && (limit == 0 || len(matched) <= limit) {
+		n := len(infos)
+		if limit > 0 && n > limit+1-len(matched) {
+			n = limit + 1 - len(matched)
+		}
+		chunk, err := loadSecretInfo(b, dom, infos[:n])
+		if err != nil {
+			return nil, err
+		}
+		infos = infos[n:]
+		matched = append(matched, filterLabels(chunk, selectors)...)
+	}
+	return matched, nil
+}
+
+// ListSecrets returns one page of the secrets of a domain that start
+// with the prefix and match the glob and the label selectors.
+// Sorting by name only needs the names: versions and metadata are
+// read for the secrets on the page when requested and, when selecting
+// by label, for as many secrets in name order as needed to fill the
+// page. Sorting by time reads them for all matching secrets. Folders
+// have no labels, so they are left out when selecting by label. An
+// empty domain has no secrets
+func ListSecrets(b SecretBackend, dom string, opts ListOptions) (SecretList, error) {
+
+	field, desc, err := opts.sortField()
+	if err != nil {
+		return SecretList{}, err
+	}
+	if opts.Limit < 0 {
+		return SecretList{}, invalidSecret("Invalid limit: %d", opts.Limit)
+	}
+	if opts.Match != "" {
+		_, err = path.Match(opts.Match, "")
+		if err != nil {
+			return SecretList{}, invalidSecret("Invalid match pattern: %s", opts.Match)
+		}
+	}
+	err = checkLabelSelectors(opts.Labels)
+	if err != nil {
+		return SecretList{}, err
+	}
+	order := field
+	if desc {
+		order = "-" + field
+	}
+	cursor, err := decodeCursor(opts.Cursor, order)
+	if err != nil {
+		return SecretList{}, err
+	}
+
+	folder, err := secretFolder(opts.Path)
+	if err != nil {
+		return SecretList{}, err
+	}
+
+	names, err := b.ListSecret(dom)
+	if smslogger.CheckError(err, "ListSecrets") != nil {
+		return SecretList{}, err
+	}
+
+	infos := []SecretInfo{}
+	seen := map[string]bool{}
+	for _, n := range names {
+		if !strings.HasPrefix(n, folder) {
+			continue
+		}
+		if i := strings.Index(n[len(folder):], "/"); !opts.Recursive && i >= 0 {
+			n = n[:len(folder)+i+1]
+		}
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+
+		if !strings.HasPrefix(n, opts.Prefix) {
+			continue
+		}
+		if ok, _ := path.Match(opts.Match, n); opts.Match != "" && !ok {
+			continue
+		}
+		infos = append(infos, SecretInfo{Name: n})
+	}
+
+	loaded := false
+	if field != SortName {
+		infos, err = loadSecretInfo(b, dom, infos)
+		if smslogger.CheckError(err, "ListSecrets") != nil {
+			return SecretList{}, err
+		}
+		infos = filterLabels(infos, opts.Labels)
+		loaded = true
+	}
+
+	// Secrets are ordered by the sort key and then by name so that
+	// the order, and with it the cursor, is always well defined
+	less := func(ka, na, kb, nb string) bool {
+		if desc {
+			ka, na, kb, nb = kb, nb, ka, na
+		}
+		return ka < kb || (ka == kb && na < nb)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return less(sortKey(field, infos[i]), infos[i].Name, sortKey(field, infos[j]), infos[j].Name)
+	})
+
+	if cursor != nil {
+		start := sort.Search(len(infos), func(i int) bool {
+			return less(cursor.Key, cursor.Name, sortKey(field, infos[i]), infos[i].Name)
+		})
+		infos = infos[start:]
+	}
+
+	if field == SortName && len(opts.Labels) > 0 {
+		infos, err = loadMatching(b, dom, infos, opts.Labels, opts.Limit)
+		if smslogger.CheckError(err, "ListSecrets") != nil {
+			return SecretList{}, err
+		}
+		loaded = true
+	}
+
+	list := SecretList{Secrets: infos}
+	if opts.Limit > 0 && len(infos) > opts.Limit {
+		list.Secrets = infos[:opts.Limit]
+		last := list.Secrets[opts.Limit-1]
+		list.Next = encodeCursor(listCursor{order, sortKey(field, last), last.Name})
+	}
+
+	if opts.Metadata && !loaded {
+		list.Secrets, err = loadSecretInfo(b, dom, list.Secrets)
+		if smslogger.CheckError(err, "ListSecrets") != nil {
+			return SecretList{}, err
+		}
+	}
+	if !opts.Metadata {
+		for i := range list.Secrets {
+			list.Secrets[i] = SecretInfo{Name: list.Secrets[i].Name}
+		}
+	}
+
+	return list, nil
+}
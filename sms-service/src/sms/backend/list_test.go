@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func listNames(list SecretList) []string {
+	names := []string{}
+	for _, s := range list.Secrets {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestListSecrets(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+	for i, n := range []string{"db-primary", "db-replica", "mq", "db-backup"} {
+		m.CreateSecret("dom1", Secret{
+			Name:     n,
+			Values:   map[string]interface{}{"k": n},
+			Metadata: &Metadata{Updated: fmt.Sprintf("2018-01-%02dT00:00:00Z", i+1)},
+		})
+	}
+
+	list, err := ListSecrets(m, "empty", ListOptions{})
+	if err != nil || list.Secrets == nil || len(list.Secrets) != 0 {
+		t.Fatal("ListSecrets: Expected empty list for empty domain")
+	}
+	_, err = ListSecrets(m, "missing", ListOptions{})
+	if !IsNotFound(err) {
+		t.Fatal("ListSecrets: Expected error for missing domain")
+	}
+
+	list, _ = ListSecrets(m, "dom1", ListOptions{Prefix: "db-", Limit: 2})
+	if !reflect.DeepEqual(listNames(list), []string{"db-backup", "db-primary"}) || list.Next == "" {
+		t.Fatalf("ListSecrets: Returned incorrect first page %v", listNames(list))
+	}
+	list, _ = ListSecrets(m, "dom1", ListOptions{Prefix: "db-", Limit: 2, Cursor: list.Next})
+	if !reflect.DeepEqual(listNames(list), []string{"db-replica"}) || list.Next != "" {
+		t.Fatalf("ListSecrets: Returned incorrect last page %v", listNames(list))
+	}
+
+	list, _ = ListSecrets(m, "dom1", ListOptions{Match: "*r*", Sort: "-name"})
+	if !reflect.DeepEqual(listNames(list), []string{"db-replica", "db-primary"}) {
+		t.Fatalf("ListSecrets: Returned incorrect matches %v", listNames(list))
+	}
+
+	list, _ = ListSecrets(m, "dom1", ListOptions{Sort: "-updated", Limit: 1, Metadata: true})
+	if list.Secrets[0].Name != "db-backup" || list.Secrets[0].Updated != "2018-01-04T00:00:00Z" ||
+		list.Secrets[0].Version == "" {
+		t.Fatalf("ListSecrets: Returned incorrect metadata %v", list.Secrets)
+	}
+	next := list.Next
+	list, _ = ListSecrets(m, "dom1", ListOptions{Sort: "-updated", Cursor: next})
+	if !reflect.DeepEqual(listNames(list), []string{"mq", "db-replica", "db-primary", "b", "a"}) ||
+		list.Secrets[0].Updated != "" {
+		t.Fatalf("ListSecrets: Returned incorrect order %v", listNames(list))
+	}
+
+	invalid := []ListOptions{
+		{Sort: "size"},
+		{Match: "[a"},
+		{Limit: -1},
+		{Cursor: "not a cursor"},
+		{Cursor: next, Sort: "name"},
+	}
+	for _, opts := range invalid {
+		_, err = ListSecrets(m, "dom1", opts)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Fatalf("ListSecrets: Expected validation error for %v", opts)
+		}
+	}
+}
+
+// readCounter counts the secrets read from a backend
+type readCounter struct {
+	SecretBackend
+	reads int32
+}
+
+func (r *readCounter) GetSecret(dom string, name string) (Secret, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.SecretBackend.GetSecret(dom, name)
+}
+
+func TestListSecretsReads(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+	for i := 0; i < 20; i++ {
+		sec := Secret{Name: fmt.Sprintf("s%02d", i), Values: map[string]interface{}{}}
+		if i%5 == 0 {
+			sec.Metadata = &Metadata{Labels: map[string]string{"env": "prod"}}
+		}
+		m.CreateSecret("dom1", sec)
+	}
+	r := &readCounter{SecretBackend: m}
+
+	list, _ := ListSecrets(r, "dom1", ListOptions{Limit: 3})
+	if len(list.Secrets) != 3 || r.reads != 0 {
+		t.Fatalf("ListSecrets: Read %d secrets for a page of names", r.reads)
+	}
+
+	list, _ = ListSecrets(r, "dom1", ListOptions{Limit: 3, Cursor: list.Next, Metadata: true})
+	if !reflect.DeepEqual(listNames(list), []string{"s01", "s02", "s03"}) || r.reads != 3 {
+		t.Fatalf("ListSecrets: Read %d secrets for a page of 3", r.reads)
+	}
+
+	// s00, s05 and s10 fill the page and s15 shows there is a next
+	// one. a, b and s00 to s15 are read, but nothing after s15
+	r.reads = 0
+	list, _ = ListSecrets(r, "dom1", ListOptions{Labels: []string{"env=prod"}, Limit: 3})
+	if !reflect.DeepEqual(listNames(list), []string{"s00", "s05", "s10"}) || list.Next == "" {
+		t.Fatalf("ListSecrets: Returned incorrect labelled page %v", listNames(list))
+	}
+	if r.reads > 18 {
+		t.Fatalf("ListSecrets: Read %d secrets, beyond the first match past the page", r.reads)
+	}
+
+	list, _ = ListSecrets(r, "dom1", ListOptions{Labels: []string{"env=prod"}, Limit: 3, Cursor: list.Next})
+	if !reflect.DeepEqual(listNames(list), []string{"s15"}) || list.Next != "" {
+		t.Fatalf("ListSecrets: Returned incorrect last labelled page %v", listNames(list))
+	}
+}
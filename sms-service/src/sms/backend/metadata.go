@@ -0,0 +1,220 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	smslogger "sms/log"
+)
+
+// Limits for the metadata provided by clients. Label keys follow the
+// character rules for domain names
+const (
+	MaxDescriptionLength = 1024
+	MaxOwnerLength       = 255
+	MaxLabels            = 64
+	MaxLabelKeyLength    = 63
+	MaxLabelValueLength  = 255
+)
+
+// Validate checks the fields of the metadata that are provided by
+// clients. A nil Metadata is valid
+func (m *Metadata) Validate() error {
+
+	if m == nil {
+		return nil
+	}
+	if len(m.Description) > MaxDescriptionLength {
+		return invalidSecret("Description is longer than %d characters", MaxDescriptionLength)
+	}
+	if len(m.Owner) > MaxOwnerLength {
+		return invalidSecret("Owner is longer than %d characters", MaxOwnerLength)
+	}
+	if len(m.Labels) > MaxLabels {
+		return invalidSecret("More than %d labels", MaxLabels)
+	}
+
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(k) > MaxLabelKeyLength {
+			return invalidSecret("Label key %q is longer than %d characters", k, MaxLabelKeyLength)
+		}
+		err := checkNameChars("Label key", k)
+		if err != nil {
+			return err
+		}
+		if len(m.Labels[k]) > MaxLabelValueLength {
+			return invalidSecret("Value of label %s is longer than %d characters", k, MaxLabelValueLength)
+		}
+	}
+
+	if m.Expires != "" {
+		_, err := time.Parse(time.RFC3339, m.Expires)
+		if err != nil {
+			return invalidSecret("Invalid expiry time: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// stampMetadata returns the metadata for a change made by the client
+// by. Only the fields a client can provide are taken from meta and
+// the creation time is kept from prev, which is nil for new entries
+func stampMetadata(meta *Metadata, prev *Metadata, by string) *Metadata {
+
+	t := now()
+	stamped := Metadata{Created: t, Updated: t, ModifiedBy: by}
+	if meta != nil {
+		stamped.Description = meta.Description
+		stamped.Owner = meta.Owner
+		stamped.Labels = meta.Labels
+		stamped.Expires = meta.Expires
+	}
+	if prev != nil && prev.Created != "" {
+		stamped.Created = prev.Created
+	}
+	return &stamped
+}
+
+// checkLabelSelectors rejects selectors without a label key
+func checkLabelSelectors(selectors []string) error {
+	for _, s := range selectors {
+		if strings.SplitN(s, "=", 2)[0] == "" {
+			return invalidSecret("Invalid label selector: %q", s)
+		}
+	}
+	return nil
+}
+
+// matchLabels reports whether the labels of meta match every
+// selector. A selector is key=value or just a key, which matches
+// any value of that label
+func matchLabels(meta *Metadata, selectors []string) bool {
+
+	for _, s := range selectors {
+		if meta == nil {
+			return false
+		}
+		kv := strings.SplitN(s, "=", 2)
+		v, ok := meta.Labels[kv[0]]
+		if !ok || (len(kv) == 2 && v != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateDomain creates a secret domain with the metadata of d for the
+// client by. The domain is deleted again if its metadata cannot be
+// stored
+func CreateDomain(b SecretBackend, d SecretDomain, by string) (SecretDomain, error) {
+
+	d.Name = strings.TrimSpace(d.Name)
+	err := ValidateDomainName(d.Name)
+	if err == nil {
+		err = d.Metadata.Validate()
+	}
+	if smslogger.CheckError(err, "CreateDomain") != nil {
+		return SecretDomain{}, err
+	}
+
+	dom, err := b.CreateSecretDomain(d.Name)
+	if smslogger.CheckError(err, "CreateDomain") != nil {
+		return SecretDomain{}, err
+	}
+
+	dom.Metadata = stampMetadata(d.Metadata, nil, by)
+	err = b.UpdateSecretDomain(dom)
+	if smslogger.CheckError(err, "CreateDomain") != nil {
+		b.DeleteSecretDomain(dom.Name)
+		return SecretDomain{}, err
+	}
+	return dom, nil
+}
+
+// UpdateDomainMetadata replaces the metadata of an existing domain
+// with meta for the client by and returns the updated domain
+func UpdateDomainMetadata(b SecretBackend, name string, meta *Metadata, by string) (SecretDomain, error) {
+
+	err := CheckDomainName(name)
+	if err == nil {
+		err = meta.Validate()
+	}
+	if smslogger.CheckError(err, "UpdateDomainMetadata") != nil {
+		return SecretDomain{}, err
+	}
+
+	// Domains share the secret locks under an empty domain name
+	defer lockSecret("", name)()
+
+	dom, err := b.GetSecretDomain(name)
+	if smslogger.CheckError(err, "UpdateDomainMetadata") != nil {
+		return SecretDomain{}, err
+	}
+
+	dom.Metadata = stampMetadata(meta, dom.Metadata, by)
+	err = b.UpdateSecretDomain(dom)
+	if smslogger.CheckError(err, "UpdateDomainMetadata") != nil {
+		return SecretDomain{}, err
+	}
+	return dom, nil
+}
+
+// ListDomains returns the secret domains, with their metadata, whose
+// labels match all selectors. Domains are sorted by name
+func ListDomains(b SecretBackend, selectors []string) ([]SecretDomain, error) {
+
+	err := checkLabelSelectors(selectors)
+	if smslogger.CheckError(err, "ListDomains") != nil {
+		return nil, err
+	}
+
+	names, err := b.ListSecretDomain()
+	if smslogger.CheckError(err, "ListDomains") != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	doms := make([]SecretDomain, len(names))
+	errs := runBatch(len(names), false, func(i int) error {
+		var err error
+		doms[i], err = b.GetSecretDomain(names[i])
+		return err
+	})
+
+	matched := []SecretDomain{}
+	for i, err := range errs {
+		// Domains deleted since they were listed are skipped
+		if IsNotFound(err) {
+			continue
+		}
+		if smslogger.CheckError(err, "ListDomains") != nil {
+			return nil, err
+		}
+		if matchLabels(doms[i].Metadata, selectors) {
+			matched = append(matched, doms[i])
+		}
+	}
+	return matched, nil
+}
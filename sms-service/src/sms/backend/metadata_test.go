@@ -0,0 +1,177 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMetadataValidate(t *testing.T) {
+
+	valid := []*Metadata{
+		nil,
+		{},
+		{Description: "Database credentials", Owner: "team-a", Labels: map[string]string{"env": "prod", "tier.db": ""}},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Validate: Unexpected error for %v: %v", m, err)
+		}
+	}
+
+	many := map[string]string{}
+	for i := 0; i <= MaxLabels; i++ {
+		many[strings.Repeat("k", i+1)] = "v"
+	}
+	invalid := []*Metadata{
+		{Description: strings.Repeat("d", MaxDescriptionLength+1)},
+		{Owner: strings.Repeat("o", MaxOwnerLength+1)},
+		{Labels: many},
+		{Labels: map[string]string{"": "v"}},
+		{Labels: map[string]string{"bad key": "v"}},
+		{Labels: map[string]string{strings.Repeat("k", MaxLabelKeyLength+1): "v"}},
+		{Labels: map[string]string{"k": strings.Repeat("v", MaxLabelValueLength+1)}},
+		{Expires: "tomorrow"},
+	}
+	for _, m := range invalid {
+		if _, ok := m.Validate().(*ValidationError); !ok {
+			t.Fatalf("Validate: Expected validation error for %v", m)
+		}
+	}
+}
+
+func TestSecretMetadata(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+
+	defer func(orig func() string) { now = orig }(now)
+	now = func() string { return "2018-01-01T00:00:00Z" }
+	meta := &Metadata{Description: "Primary database", Owner: "team-a",
+		Labels: map[string]string{"env": "prod"}, Created: "2000-01-01T00:00:00Z", ModifiedBy: "someone"}
+	_, err := ReplaceSecret(m, "dom1", Secret{Name: "db", Values: map[string]interface{}{"k": "v"}, Metadata: meta}, "", "client1")
+	if err != nil {
+		t.Fatal("ReplaceSecret: Error writing secret with metadata")
+	}
+	got, _ := m.GetSecret("dom1", "db")
+	expected := &Metadata{Description: "Primary database", Owner: "team-a", Labels: map[string]string{"env": "prod"},
+		Created: "2018-01-01T00:00:00Z", Updated: "2018-01-01T00:00:00Z", ModifiedBy: "client1"}
+	if reflect.DeepEqual(got.Metadata, expected) == false {
+		t.Fatalf("ReplaceSecret: Stored metadata %v, expected %v", got.Metadata, expected)
+	}
+
+	// Values can be patched without touching the metadata
+	now = func() string { return "2018-02-01T00:00:00Z" }
+	sec, err := PatchSecret(m, "dom1", "db", Secret{Values: map[string]interface{}{"k": "w"}}, "", "client2")
+	if err != nil || sec.Metadata.Owner != "team-a" || sec.Metadata.Created != "2018-01-01T00:00:00Z" ||
+		sec.Metadata.Updated != "2018-02-01T00:00:00Z" || sec.Metadata.ModifiedBy != "client2" {
+		t.Fatalf("PatchSecret: Returned incorrect metadata %v", sec.Metadata)
+	}
+
+	sec, err = PatchSecret(m, "dom1", "db", Secret{Metadata: &Metadata{Labels: map[string]string{"env": "test"}}}, "", "client2")
+	if err != nil || sec.Metadata.Owner != "" || sec.Metadata.Labels["env"] != "test" || sec.Values["k"] != "w" {
+		t.Fatalf("PatchSecret: Metadata was not replaced: %v", sec.Metadata)
+	}
+
+	_, err = PatchSecret(m, "dom1", "db", Secret{Metadata: &Metadata{Labels: map[string]string{"a b": ""}}}, "", "")
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("PatchSecret: Expected validation error for invalid label")
+	}
+
+	ReplaceSecret(m, "dom1", Secret{Name: "mq", Values: map[string]interface{}{},
+		Metadata: &Metadata{Labels: map[string]string{"env": "prod", "team": "a"}}}, "", "")
+	ReplaceSecret(m, "dom1", Secret{Name: "app/db", Values: map[string]interface{}{},
+		Metadata: &Metadata{Labels: map[string]string{"env": "test"}}}, "", "")
+
+	tests := []struct {
+		labels   []string
+		expected []string
+	}{
+		{[]string{"env=prod"}, []string{"mq"}},
+		{[]string{"env"}, []string{"db", "mq"}},
+		{[]string{"env=test"}, []string{"db"}},
+		{[]string{"env", "team=a"}, []string{"mq"}},
+		{[]string{"missing"}, []string{}},
+	}
+	for _, test := range tests {
+		list, err := ListSecrets(m, "dom1", ListOptions{Labels: test.labels})
+		if err != nil || !reflect.DeepEqual(listNames(list), test.expected) {
+			t.Fatalf("ListSecrets: Labels %v returned %v, expected %v", test.labels, listNames(list), test.expected)
+		}
+	}
+
+	list, _ := ListSecrets(m, "dom1", ListOptions{Recursive: true, Labels: []string{"env=test"}, Metadata: true})
+	if !reflect.DeepEqual(listNames(list), []string{"app/db", "db"}) || list.Secrets[1].ModifiedBy != "client2" {
+		t.Fatalf("ListSecrets: Returned unexpected secrets %v", list.Secrets)
+	}
+
+	_, err = ListSecrets(m, "dom1", ListOptions{Labels: []string{"=prod"}})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("ListSecrets: Expected validation error for invalid label selector")
+	}
+}
+
+func TestDomainMetadata(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+
+	defer func(orig func() string) { now = orig }(now)
+	now = func() string { return "2018-01-01T00:00:00Z" }
+	dom, err := CreateDomain(m, SecretDomain{Name: "labeled",
+		Metadata: &Metadata{Owner: "team-a", Labels: map[string]string{"env": "prod"}}}, "client1")
+	if err != nil || dom.UUID == "" || dom.Metadata.Created != "2018-01-01T00:00:00Z" ||
+		dom.Metadata.ModifiedBy != "client1" {
+		t.Fatalf("CreateDomain: Returned incorrect domain %v", dom)
+	}
+	got, _ := m.GetSecretDomain("labeled")
+	if reflect.DeepEqual(got, dom) == false {
+		t.Fatalf("CreateDomain: Stored %v, expected %v", got, dom)
+	}
+
+	_, err = CreateDomain(m, SecretDomain{Name: "invalid", Metadata: &Metadata{Labels: map[string]string{"": ""}}}, "")
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("CreateDomain: Expected validation error for invalid label")
+	}
+	if _, err = m.GetSecretDomain("invalid"); err == nil {
+		t.Fatal("CreateDomain: Domain was created with invalid metadata")
+	}
+
+	now = func() string { return "2018-02-01T00:00:00Z" }
+	dom, err = UpdateDomainMetadata(m, "labeled", &Metadata{Description: "Production"}, "client2")
+	if err != nil || dom.Metadata.Description != "Production" || dom.Metadata.Owner != "" ||
+		dom.Metadata.Created != "2018-01-01T00:00:00Z" || dom.Metadata.Updated != "2018-02-01T00:00:00Z" ||
+		dom.Metadata.ModifiedBy != "client2" {
+		t.Fatalf("UpdateDomainMetadata: Returned incorrect metadata %v", dom.Metadata)
+	}
+	_, err = UpdateDomainMetadata(m, "missing", &Metadata{}, "")
+	if !IsNotFound(err) {
+		t.Fatal("UpdateDomainMetadata: Expected not found error")
+	}
+
+	UpdateDomainMetadata(m, "dom1", &Metadata{Labels: map[string]string{"env": "prod"}}, "")
+	doms, err := ListDomains(m, []string{"env=prod"})
+	if err != nil || len(doms) != 1 || doms[0].Name != "dom1" {
+		t.Fatalf("ListDomains: Returned unexpected domains %v", doms)
+	}
+	doms, _ = ListDomains(m, nil)
+	if len(doms) != 4 || doms[3].Metadata.Description != "Production" {
+		t.Fatalf("ListDomains: Returned unexpected domains %v", doms)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+
+	smslogger "sms/log"
+)
+
+// Actions reported while migrating domains and secrets
+const (
+	MigrateCreated   = "created"
+	MigrateUpdated   = "updated"
+	MigrateUnchanged = "unchanged"
+)
+
+// MigrateEvent describes a domain or, when Secret is set, a secret
+// that has been migrated. Index counts the domains from 1 to Total
+type MigrateEvent struct {
+	Domain string
+	Secret string
+	Action string
+	Index  int
+	Total  int
+}
+
+// MigrateResult counts the secrets by the action taken for them
+type MigrateResult struct {
+	Domains   int `json:"domains"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (r *MigrateResult) add(action string) {
+	switch action {
+	case MigrateCreated:
+		r.Created++
+	case MigrateUpdated:
+		r.Updated++
+	default:
+		r.Unchanged++
+	}
+}
+
+// sameSecret compares the values, declared types and metadata of
+// two secrets
+func sameSecret(a Secret, b Secret) bool {
+	return reflect.DeepEqual(a.Values, b.Values) && reflect.DeepEqual(a.Types, b.Types) &&
+		reflect.DeepEqual(a.Metadata, b.Metadata)
+}
+
+// listDomainSecrets returns the sorted secret names of a domain.
+// An empty domain has no secrets rather than an error
+func listDomainSecrets(b SecretBackend, dom string) ([]string, error) {
+
+	names, err := b.ListSecret(dom)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Migrate copies all domains, with their UUIDs and metadata, and all
+// secrets from src to dst. Domains and secrets that already match are
+// left alone so that an interrupted migration can be run again. With
+// dryRun set nothing is written to dst. progress is called for every
+// domain and secret and may be nil
+func Migrate(src SecretBackend, dst SecretBackend, dryRun bool, progress func(MigrateEvent)) (MigrateResult, error) {
+
+	var result MigrateResult
+	if progress == nil {
+		progress = func(MigrateEvent) {}
+	}
+
+	doms, err := src.ListSecretDomain()
+	if smslogger.CheckError(err, "Migrate") != nil {
+		return result, err
+	}
+	sort.Strings(doms)
+
+	for i, name := range doms {
+		dom, err := src.GetSecretDomain(name)
+		if smslogger.CheckError(err, "Migrate") != nil {
+			return result, err
+		}
+
+		action := MigrateUnchanged
+		existing, err := dst.GetSecretDomain(name)
+		switch {
+		case err == nil && existing.UUID != dom.UUID:
+			return result, errors.New("Domain " + name + " exists in the destination with a different UUID")
+		case err == nil && !reflect.DeepEqual(existing.Metadata, dom.Metadata):
+			action = MigrateUpdated
+			if !dryRun {
+				err = dst.UpdateSecretDomain(dom)
+				if smslogger.CheckError(err, "Migrate") != nil {
+					return result, err
+				}
+			}
+		case err != nil && IsNotFound(err):
+			action = MigrateCreated
+			if !dryRun {
+				err = dst.RestoreSecretDomain(dom)
+				if smslogger.CheckError(err, "Migrate") != nil {
+					return result, err
+				}
+			}
+		case err != nil:
+			smslogger.WriteError(err.Error())
+			return result, err
+		}
+		event := MigrateEvent{Domain: name, Action: action, Index: i + 1, Total: len(doms)}
+		progress(event)
+
+		names, err := listDomainSecrets(src, name)
+		if smslogger.CheckError(err, "Migrate") != nil {
+			return result, err
+		}
+
+		for _, secName := range names {
+			sec, err := src.GetSecret(name, secName)
+			if smslogger.CheckError(err, "Migrate") != nil {
+				return result, err
+			}
+
+			event.Secret = secName
+			event.Action = MigrateCreated
+			cur, err := dst.GetSecret(name, secName)
+			if err == nil {
+				event.Action = MigrateUpdated
+				if sameSecret(cur, sec) {
+					event.Action = MigrateUnchanged
+				}
+			} else if !IsNotFound(err) {
+				smslogger.WriteError(err.Error())
+				return result, err
+			}
+
+			if !dryRun && event.Action != MigrateUnchanged {
+				err = dst.CreateSecret(name, sec)
+				if smslogger.CheckError(err, "Migrate") != nil {
+					return result, err
+				}
+			}
+			result.add(event.Action)
+			progress(event)
+		}
+		result.Domains++
+	}
+
+	return result, nil
+}
+
+// VerifyMigration compares every domain UUID, domain metadata and
+// secret of src with dst and returns a description of each
+// difference. Extra domains and secrets in dst are not reported
+func VerifyMigration(src SecretBackend, dst SecretBackend) ([]string, error) {
+
+	problems := []string{}
+	doms, err := src.ListSecretDomain()
+	if smslogger.CheckError(err, "VerifyMigration") != nil {
+		return nil, err
+	}
+	sort.Strings(doms)
+
+	for _, name := range doms {
+		dom, err := src.GetSecretDomain(name)
+		if smslogger.CheckError(err, "VerifyMigration") != nil {
+			return nil, err
+		}
+
+		existing, err := dst.GetSecretDomain(name)
+		if err != nil {
+			problems = append(problems, name+": "+err.Error())
+			continue
+		}
+		if existing.UUID != dom.UUID {
+			problems = append(problems, name+": UUID "+existing.UUID+" does not match "+dom.UUID)
+		}
+		if !reflect.DeepEqual(existing.Metadata, dom.Metadata) {
+			problems = append(problems, name+": metadata does not match")
+		}
+
+		names, err := listDomainSecrets(src, name)
+		if smslogger.CheckError(err, "VerifyMigration") != nil {
+			return nil, err
+		}
+
+		for _, secName := range names {
+			sec, err := src.GetSecret(name, secName)
+			if smslogger.CheckError(err, "VerifyMigration") != nil {
+				return nil, err
+			}
+
+			cur, err := dst.GetSecret(name, secName)
+			if err != nil {
+				problems = append(problems, name+"/"+secName+": "+err.Error())
+				continue
+			}
+			if !sameSecret(cur, sec) {
+				problems = append(problems, name+"/"+secName+": values do not match")
+			}
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+
+	src := newMemBackend()
+	populateBackend(t, src)
+	dst := newMemBackend()
+
+	var events []MigrateEvent
+	res, err := Migrate(src, dst, true, func(e MigrateEvent) {
+		events = append(events, e)
+	})
+	if err != nil || res.Domains != 3 || res.Created != 3 {
+		t.Fatal("Migrate: Incorrect dry run result")
+	}
+	if len(dst.uuids) != 0 {
+		t.Fatal("Migrate: Dry run wrote to the destination")
+	}
+	// One event per domain and one per secret
+	if len(events) != 6 || events[0].Index != 1 || events[0].Total != 3 {
+		t.Fatal("Migrate: Incorrect progress events")
+	}
+
+	res, err = Migrate(src, dst, false, nil)
+	if err != nil || res.Created != 3 {
+		t.Fatal("Migrate: Error migrating backend")
+	}
+	if reflect.DeepEqual(dst.uuids, src.uuids) == false ||
+		reflect.DeepEqual(dst.secrets, src.secrets) == false {
+		t.Fatal("Migrate: Destination does not match source")
+	}
+
+	problems, err := VerifyMigration(src, dst)
+	if err != nil || len(problems) != 0 {
+		t.Fatal("VerifyMigration: Unexpected differences after migration")
+	}
+
+	// Running again only copies what changed
+	src.CreateSecret("dom2", Secret{Name: "c", Values: map[string]interface{}{"port": "9090"}})
+	problems, _ = VerifyMigration(src, dst)
+	if len(problems) != 1 {
+		t.Fatal("VerifyMigration: Expected changed secret to be reported")
+	}
+	res, err = Migrate(src, dst, false, nil)
+	if err != nil || res.Updated != 1 || res.Unchanged != 2 || res.Created != 0 {
+		t.Fatal("Migrate: Incorrect result for second run")
+	}
+
+	// Domain metadata is copied to domains that already exist
+	src.UpdateSecretDomain(SecretDomain{Name: "empty", Metadata: &Metadata{Owner: "team-a"}})
+	problems, _ = VerifyMigration(src, dst)
+	if len(problems) != 1 {
+		t.Fatal("VerifyMigration: Expected changed domain metadata to be reported")
+	}
+	_, err = Migrate(src, dst, false, nil)
+	if err != nil || reflect.DeepEqual(dst.meta, src.meta) == false {
+		t.Fatal("Migrate: Domain metadata was not copied")
+	}
+
+	dst.uuids["dom1"] = "other-uuid"
+	_, err = Migrate(src, dst, false, nil)
+	if err == nil {
+		t.Fatal("Migrate: Expected error for conflicting UUID")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"strings"
+)
+
+// Naming rules for domains and secrets. Names end up in backend
+// paths, so they are checked before they reach a backend.
+//
+// A domain name is 1 to MaxDomainNameLength letters, digits, '-', '_'
+// or '.', starts with a letter or a digit and is not one of
+// ReservedDomainNames, ignoring case.
+//
+// A secret name is a path of segments separated by '/' and at most
+// MaxSecretNameLength characters long. Each segment follows the
+// character rules for domain names, so it cannot be empty, '.' or
+// '..'. Every segment but the last is a folder, and 'key' cannot be
+// a folder name because the key endpoint follows the secret path.
+//
+// These rules only apply when a domain or secret is created. Names
+// created before the rules existed can contain spaces and other
+// characters, so existing domains and secrets are looked up with the
+// CheckDomainName and CheckSecretName checks, which only refuse
+// names that cannot form a safe backend path.
+const (
+	MaxDomainNameLength = 64
+	MaxSecretNameLength = 255
+)
+
+// ReservedDomainNames are used by SMS itself
+var ReservedDomainNames = []string{"smsinternaldomain"}
+
+// keySegment is the path segment the key endpoint uses after a
+// secret path. It cannot be used as a folder name
+const keySegment = "key"
+
+// checkNameChars checks the characters of a domain name or of one
+// segment of a secret name
+func checkNameChars(kind string, name string) error {
+
+	if name == "" {
+		return invalidSecret("%s cannot be empty", kind)
+	}
+	for i, c := range name {
+		alnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if i == 0 && !alnum {
+			return invalidSecret("%s %q must start with a letter or a digit", kind, name)
+		}
+		if !alnum && c != '-' && c != '_' && c != '.' {
+			return invalidSecret("%s %q can only contain letters, digits, '-', '_' and '.'", kind, name)
+		}
+	}
+	return nil
+}
+
+// checkPathChars refuses the names that cannot be used as a single
+// path segment, whatever the rules were when they were created
+func checkPathChars(kind string, name string) error {
+
+	switch {
+	case name == "":
+		return invalidSecret("%s cannot be empty", kind)
+	case name == "." || name == "..":
+		return invalidSecret("%s cannot be . or ..", kind)
+	case strings.ContainsAny(name, "/\x00"):
+		return invalidSecret("%s %q cannot contain '/' or NUL", kind, name)
+	}
+	return nil
+}
+
+// checkReserved refuses the domain names used by SMS itself
+func checkReserved(name string) error {
+
+	for _, r := range ReservedDomainNames {
+		if strings.EqualFold(name, r) {
+			return invalidSecret("Domain name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// ValidateDomainName checks the name of a new domain against the
+// naming rules
+func ValidateDomainName(name string) error {
+
+	if len(name) > MaxDomainNameLength {
+		return invalidSecret("Domain name is longer than %d characters", MaxDomainNameLength)
+	}
+	err := checkNameChars("Domain name", name)
+	if err != nil {
+		return err
+	}
+	return checkReserved(name)
+}
+
+// CheckDomainName checks the name of an existing domain. Surrounding
+// spaces are ignored as backends trim them
+func CheckDomainName(name string) error {
+
+	name = strings.TrimSpace(name)
+	err := checkPathChars("Domain name", name)
+	if err != nil {
+		return err
+	}
+	return checkReserved(name)
+}
+
+// checkDomainPath checks a domain name before a backend uses it in a
+// path. Unlike CheckDomainName it allows the reserved domains
+func checkDomainPath(name string) error {
+	return checkPathChars("Domain name", strings.TrimSpace(name))
+}
+
+// checkBackendPath checks the domain and secret name of an existing
+// secret before a backend joins them into a path
+func checkBackendPath(dom string, name string) error {
+
+	err := checkDomainPath(dom)
+	if err != nil {
+		return err
+	}
+	return CheckSecretName(name)
+}
+
+// checkSegments checks every segment of a secret path with check.
+// With folders set every segment is a folder name
+func checkSegments(p string, folders bool, check func(string, string) error) error {
+
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		err := check("Secret path segment", s)
+		if err != nil {
+			return err
+		}
+		if s == keySegment && (folders || i < len(segs)-1) {
+			return invalidSecret("Secret path %q cannot use %s as a folder name", p, keySegment)
+		}
+	}
+	return nil
+}
+
+// ValidateSecretName checks the name of a new secret against the
+// naming rules. Names can be paths such as db/primary/creds that
+// place the secret in folders
+func ValidateSecretName(name string) error {
+
+	if name == "" {
+		return invalidSecret("Secret name cannot be empty")
+	}
+	if len(name) > MaxSecretNameLength {
+		return invalidSecret("Secret name is longer than %d characters", MaxSecretNameLength)
+	}
+	return checkSegments(name, false, checkNameChars)
+}
+
+// CheckSecretName checks the name of an existing secret
+func CheckSecretName(name string) error {
+
+	if name == "" {
+		return invalidSecret("Secret name cannot be empty")
+	}
+	return checkSegments(name, false, checkPathChars)
+}
+
+// CheckSecretFolder checks a folder path. A trailing slash is
+// allowed and an empty folder is the root of the domain
+func CheckSecretFolder(folder string) error {
+	_, err := secretFolder(folder)
+	return err
+}
+
+// secretFolder checks a folder path and returns it with a trailing
+// slash, or empty for the root of the domain
+func secretFolder(folder string) (string, error) {
+	folder = strings.TrimSuffix(folder, "/")
+	if folder == "" {
+		return "", nil
+	}
+	err := checkSegments(folder, true, checkPathChars)
+	if err != nil {
+		return "", err
+	}
+	return folder + "/", nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDomainName(t *testing.T) {
+
+	valid := []string{"dom1", "my-domain", "A_b.c", "9", strings.Repeat("d", MaxDomainNameLength)}
+	for _, n := range valid {
+		if err := ValidateDomainName(n); err != nil {
+			t.Fatalf("ValidateDomainName: Unexpected error for %q: %v", n, err)
+		}
+	}
+
+	invalid := []string{"", " dom", "dom ", "a/b", "..", ".hidden", "-dom", "dom%2F", "dömain",
+		"smsinternaldomain", "SMSInternalDomain", strings.Repeat("d", MaxDomainNameLength+1)}
+	for _, n := range invalid {
+		if _, ok := ValidateDomainName(n).(*ValidationError); !ok {
+			t.Fatalf("ValidateDomainName: Expected validation error for %q", n)
+		}
+	}
+}
+
+func TestValidateSecretName(t *testing.T) {
+
+	valid := []string{"creds", "db/primary/creds", "db/key", "key", "smsinternaldomain"}
+	for _, p := range valid {
+		if err := ValidateSecretName(p); err != nil {
+			t.Fatalf("ValidateSecretName: Unexpected error for %q: %v", p, err)
+		}
+	}
+
+	invalid := []string{"", "/db", "db/", "db//creds", "../creds", "db/../../creds", "./creds",
+		"db/key/creds", "a b/c", "creds\n", strings.Repeat("a/", 128) + "a"}
+	for _, p := range invalid {
+		if _, ok := ValidateSecretName(p).(*ValidationError); !ok {
+			t.Fatalf("ValidateSecretName: Expected validation error for %q", p)
+		}
+	}
+
+	for _, f := range []string{"", "db", "db/primary/", "old folder/"} {
+		if err := CheckSecretFolder(f); err != nil {
+			t.Fatalf("CheckSecretFolder: Unexpected error for %q: %v", f, err)
+		}
+	}
+	for _, f := range []string{"db/key", "db//", "../db"} {
+		if _, ok := CheckSecretFolder(f).(*ValidationError); !ok {
+			t.Fatalf("CheckSecretFolder: Expected validation error for %q", f)
+		}
+	}
+}
+
+func TestCheckExistingNames(t *testing.T) {
+
+	// Names created before the naming rules can still be used
+	for _, n := range []string{"old domain", " dom", "user@host:1", "dömain", strings.Repeat("d", 100)} {
+		if err := CheckDomainName(n); err != nil {
+			t.Fatalf("CheckDomainName: Unexpected error for %q: %v", n, err)
+		}
+	}
+	for _, n := range []string{"", "  ", "a/b", "..", "smsinternaldomain", "a\x00b"} {
+		if _, ok := CheckDomainName(n).(*ValidationError); !ok {
+			t.Fatalf("CheckDomainName: Expected validation error for %q", n)
+		}
+	}
+	if err := checkDomainPath("smsinternaldomain"); err != nil {
+		t.Fatal("checkDomainPath: Backends must be able to use the internal domain")
+	}
+
+	for _, n := range []string{"my secret@x:1", "db/old creds", "key", strings.Repeat("a/", 128) + "a"} {
+		if err := CheckSecretName(n); err != nil {
+			t.Fatalf("CheckSecretName: Unexpected error for %q: %v", n, err)
+		}
+	}
+	for _, n := range []string{"", "/db", "db//creds", "../creds", "db/./creds", "db/key/creds"} {
+		if _, ok := CheckSecretName(n).(*ValidationError); !ok {
+			t.Fatalf("CheckSecretName: Expected validation error for %q", n)
+		}
+	}
+
+	if _, ok := checkBackendPath("a/b", "creds").(*ValidationError); !ok {
+		t.Fatal("checkBackendPath: Expected validation error for the domain")
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	smslogger "sms/log"
+)
+
+// ErrVersionMismatch is returned by PatchSecret and DeleteSecretVersion
+// when the stored secret no longer has the expected version
+var ErrVersionMismatch = errors.New("Secret has been modified")
+
+// AnyVersion matches any version of an existing secret
+const AnyVersion = "*"
+
+// secretLocks serialize conditional updates so that a secret cannot
+// change between comparing its version and writing it. Secrets are
+// spread over a fixed set of locks so unrelated writes can run in
+// parallel. The locks only cover this process and the Vault kv mounts
+// used for domains have no check-and-set, so two SMS replicas can
+// still overwrite each other's changes
+var secretLocks [64]sync.Mutex
+
+// lockSecret locks the secret and returns the function unlocking it
+func lockSecret(dom string, name string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(dom + "/" + name))
+	l := &secretLocks[h.Sum32()%uint32(len(secretLocks))]
+	l.Lock()
+	return l.Unlock
+}
+
+// now returns the current time in the format used by Metadata
+var now = func() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// stampSecret sets the metadata of a secret written by the client by
+// that replaces prev, which is nil for a new secret
+func stampSecret(sec Secret, prev *Secret, by string) Secret {
+
+	var prevMeta *Metadata
+	if prev != nil {
+		prevMeta = prev.Metadata
+	}
+	sec.Metadata = stampMetadata(sec.Metadata, prevMeta, by)
+	return sec
+}
+
+// SecretVersion returns a version string for the values and metadata
+// of a secret. It changes whenever a value or the metadata set by
+// clients changes and is used as the ETag. The times and modifier
+// SMS stamps on every write are not part of it
+func SecretVersion(sec Secret) string {
+
+	// encoding/json sorts map keys so equal values give equal versions
+	data, _ := json.Marshal(packTypes(sec))
+	if m := sec.Metadata; m != nil {
+		meta, _ := json.Marshal(Metadata{
+			Description: m.Description,
+			Owner:       m.Owner,
+			Labels:      m.Labels,
+			Expires:     m.Expires,
+		})
+		// Secrets without client metadata keep the version they had
+		// before metadata existed
+		if string(meta) != "{}" {
+			data = append(data, meta...)
+		}
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// checkVersion reads a secret and compares its version. An empty
+// version skips the comparison
+func checkVersion(b SecretBackend, dom string, name string, version string) (Secret, error) {
+
+	sec, err := b.GetSecret(dom, name)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	if version != "" && version != AnyVersion && version != SecretVersion(sec) {
+		return Secret{}, ErrVersionMismatch
+	}
+	return sec, nil
+}
+
+// mergeValues applies a JSON merge patch (RFC 7396) to values. Keys
+// set to nil are removed and nested objects are merged recursively
+func mergeValues(values map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+
+	merged := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		merged[k] = v
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			merged[k] = v
+			continue
+		}
+		cur, _ := merged[k].(map[string]interface{})
+		merged[k] = mergeValues(cur, p)
+	}
+
+	return merged
+}
+
+// mergeTypes sets the types declared in the patch and drops the
+// types of keys that no longer exist
+func mergeTypes(sec Secret, patch map[string]string) map[string]string {
+
+	types := map[string]string{}
+	for k, t := range sec.Types {
+		types[k] = t
+	}
+	for k, t := range patch {
+		types[k] = t
+	}
+	for k := range types {
+		if _, ok := sec.Values[k]; !ok {
+			delete(types, k)
+		}
+	}
+
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
+
+// PatchSecret merges the values and types of patch into an existing
+// secret for the client by and returns the updated secret. Metadata
+// in the patch replaces the one of the secret. When version is not
+// empty the secret is only written if it still has that version
+func PatchSecret(b SecretBackend, dom string, name string, patch Secret, version string, by string) (Secret, error) {
+
+	err := CheckSecretName(name)
+	if smslogger.CheckError(err, "PatchSecret") != nil {
+		return Secret{}, err
+	}
+
+	defer lockSecret(dom, name)()
+
+	sec, err := checkVersion(b, dom, name, version)
+	if err != nil {
+		smslogger.WriteError(err.Error())
+		return Secret{}, err
+	}
+
+	prev := sec
+	sec.Values = mergeValues(sec.Values, patch.Values)
+	sec.Types = mergeTypes(sec, patch.Types)
+	if patch.Metadata != nil {
+		sec.Metadata = patch.Metadata
+	}
+	sec = stampSecret(sec, &prev, by)
+	err = sec.Validate()
+	if smslogger.CheckError(err, "PatchSecret") != nil {
+		return Secret{}, err
+	}
+
+	err = b.CreateSecret(dom, sec)
+	if smslogger.CheckError(err, "PatchSecret") != nil {
+		return Secret{}, err
+	}
+
+	return sec, nil
+}
+
+// DeleteSecretVersion deletes a secret only if it still has the given
+// version. An empty version deletes it unconditionally
+func DeleteSecretVersion(b SecretBackend, dom string, name string, version string) error {
+
+	if version == "" {
+		return b.DeleteSecret(dom, name)
+	}
+
+	defer lockSecret(dom, name)()
+
+	_, err := checkVersion(b, dom, name, version)
+	if err != nil {
+		smslogger.WriteError(err.Error())
+		return err
+	}
+
+	return b.DeleteSecret(dom, name)
+}
+
+// ReplaceSecret writes a secret for the client by and returns the
+// secret as stored. When version is not empty the secret must already
+// exist with that version. Existing secrets can be overwritten even
+// when their names do not follow the rules for new secrets
+func ReplaceSecret(b SecretBackend, dom string, sec Secret, version string, by string) (Secret, error) {
+
+	err := CheckSecretName(sec.Name)
+	if err == nil {
+		err = sec.Validate()
+	}
+	if smslogger.CheckError(err, "ReplaceSecret") != nil {
+		return Secret{}, err
+	}
+
+	defer lockSecret(dom, sec.Name)()
+
+	var prev *Secret
+	if version != "" {
+		cur, err := checkVersion(b, dom, sec.Name, version)
+		if err != nil {
+			smslogger.WriteError(err.Error())
+			return Secret{}, err
+		}
+		prev = &cur
+	} else {
+		cur, err := b.GetSecret(dom, sec.Name)
+		if err == nil {
+			prev = &cur
+		} else if !IsNotFound(err) {
+			smslogger.WriteError(err.Error())
+			return Secret{}, err
+		}
+	}
+
+	if prev == nil {
+		err = ValidateSecretName(sec.Name)
+		if smslogger.CheckError(err, "ReplaceSecret") != nil {
+			return Secret{}, err
+		}
+	}
+
+	sec = stampSecret(sec, prev, by)
+	err = b.CreateSecret(dom, sec)
+	if smslogger.CheckError(err, "ReplaceSecret") != nil {
+		return Secret{}, err
+	}
+	return sec, nil
+}
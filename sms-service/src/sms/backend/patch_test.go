@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPatchSecret(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+	m.CreateSecret("dom1", Secret{Name: "db", Values: map[string]interface{}{
+		"user":   "admin",
+		"passwd": "secret",
+		"conn":   map[string]interface{}{"host": "db", "port": "5432"},
+	}})
+
+	orig, _ := m.GetSecret("dom1", "db")
+	version := SecretVersion(orig)
+
+	sec, err := PatchSecret(m, "dom1", "db", Secret{Values: map[string]interface{}{
+		"passwd": nil,
+		"token":  "abc",
+		"conn":   map[string]interface{}{"port": "6432"},
+	}}, version, "")
+	if err != nil {
+		t.Fatal("PatchSecret: Error patching secret")
+	}
+	expected := map[string]interface{}{
+		"user":  "admin",
+		"token": "abc",
+		"conn":  map[string]interface{}{"host": "db", "port": "6432"},
+	}
+	stored, _ := m.GetSecret("dom1", "db")
+	if reflect.DeepEqual(sec.Values, expected) == false ||
+		reflect.DeepEqual(stored.Values, expected) == false {
+		t.Fatal("PatchSecret: Values were not merged")
+	}
+	if reflect.DeepEqual(orig.Values["conn"], map[string]interface{}{"host": "db", "port": "5432"}) == false {
+		t.Fatal("PatchSecret: Original values were modified")
+	}
+
+	_, err = PatchSecret(m, "dom1", "db", Secret{Values: map[string]interface{}{"user": "x"}}, version, "")
+	if err != ErrVersionMismatch {
+		t.Fatal("PatchSecret: Expected version mismatch for stale version")
+	}
+
+	_, err = PatchSecret(m, "dom1", "missing", Secret{Values: map[string]interface{}{"user": "x"}}, AnyVersion, "")
+	if !IsNotFound(err) {
+		t.Fatal("PatchSecret: Expected not found error for missing secret")
+	}
+
+	err = DeleteSecretVersion(m, "dom1", "db", version)
+	if err != ErrVersionMismatch {
+		t.Fatal("DeleteSecretVersion: Expected version mismatch for stale version")
+	}
+	err = DeleteSecretVersion(m, "dom1", "db", SecretVersion(stored))
+	if err != nil {
+		t.Fatal("DeleteSecretVersion: Error deleting secret")
+	}
+	if _, err = m.GetSecret("dom1", "db"); err == nil {
+		t.Fatal("DeleteSecretVersion: Secret was not deleted")
+	}
+}
+
+func TestReplaceSecret(t *testing.T) {
+
+	m := newMemBackend()
+	populateBackend(t, m)
+
+	sec := Secret{Name: "c", Values: map[string]interface{}{"port": "9090"}}
+	_, err := ReplaceSecret(m, "dom2", sec, "0000", "")
+	if err != ErrVersionMismatch {
+		t.Fatal("ReplaceSecret: Expected version mismatch")
+	}
+
+	cur, _ := m.GetSecret("dom2", "c")
+	_, err = ReplaceSecret(m, "dom2", sec, SecretVersion(cur), "")
+	if err != nil {
+		t.Fatal("ReplaceSecret: Error replacing secret")
+	}
+
+	_, err = ReplaceSecret(m, "dom2", Secret{Name: "new", Values: map[string]interface{}{}}, AnyVersion, "")
+	if !IsNotFound(err) {
+		t.Fatal("ReplaceSecret: If-Match * should require an existing secret")
+	}
+	_, err = ReplaceSecret(m, "dom2", Secret{Name: "new", Values: map[string]interface{}{}}, "", "")
+	if err != nil {
+		t.Fatal("ReplaceSecret: Unconditional write failed")
+	}
+
+	// Names from before the naming rules can still be overwritten
+	// but not created
+	m.CreateSecret("dom2", Secret{Name: "old name", Values: map[string]interface{}{}})
+	_, err = ReplaceSecret(m, "dom2", Secret{Name: "old name", Values: map[string]interface{}{"k": "v"}}, "", "")
+	if err != nil {
+		t.Fatal("ReplaceSecret: Existing secret with a legacy name was not replaced")
+	}
+	_, err = ReplaceSecret(m, "dom2", Secret{Name: "new name", Values: map[string]interface{}{}}, "", "")
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("ReplaceSecret: Expected invalid name for a new secret")
+	}
+
+	defer func(orig func() string) { now = orig }(now)
+	now = func() string { return "2018-01-01T00:00:00Z" }
+	ReplaceSecret(m, "dom2", Secret{Name: "timed", Values: map[string]interface{}{}}, "", "")
+	now = func() string { return "2018-02-01T00:00:00Z" }
+	ReplaceSecret(m, "dom2", Secret{Name: "timed", Values: map[string]interface{}{"k": "v"}}, "", "")
+	got, _ := m.GetSecret("dom2", "timed")
+	if got.Metadata == nil || got.Metadata.Created != "2018-01-01T00:00:00Z" ||
+		got.Metadata.Updated != "2018-02-01T00:00:00Z" {
+		t.Fatalf("ReplaceSecret: Returned incorrect metadata %v", got.Metadata)
+	}
+}
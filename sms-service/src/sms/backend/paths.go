@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"sort"
+	"strings"
+
+	smslogger "sms/log"
+)
+
+// DeleteSecretFolder deletes every secret below a folder of a domain
+// and returns the names of the deleted secrets, also when some of
+// them could not be deleted
+func DeleteSecretFolder(b SecretBackend, dom string, folder string) ([]string, error) {
+
+	folder, err := secretFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	if folder == "" {
+		return nil, invalidSecret("Use the domain endpoint to delete all secrets of a domain")
+	}
+
+	names, err := b.ListSecret(dom)
+	if smslogger.CheckError(err, "DeleteSecretFolder") != nil {
+		return nil, err
+	}
+
+	matched := []string{}
+	for _, n := range names {
+		if strings.HasPrefix(n, folder) {
+			matched = append(matched, n)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, ErrFolderNotFound
+	}
+	sort.Strings(matched)
+
+	errs := runBatch(len(matched), false, func(i int) error {
+		defer lockSecret(dom, matched[i])()
+		return b.DeleteSecret(dom, matched[i])
+	})
+
+	// Report the first error together with everything that was deleted
+	deleted := []string{}
+	err = nil
+	for i, e := range errs {
+		if smslogger.CheckError(e, "DeleteSecretFolder") != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		deleted = append(deleted, matched[i])
+	}
+	return deleted, err
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecretFolders(t *testing.T) {
+
+	m := newSyncBackend(t)
+	for _, n := range []string{"db/primary/creds", "db/primary/tls", "db/replica/creds", "mq/creds"} {
+		_, err := ReplaceSecret(m, "dom1", Secret{Name: n, Values: map[string]interface{}{"k": n}}, "", "")
+		if err != nil {
+			t.Fatal("ReplaceSecret: Error writing secret in folder")
+		}
+	}
+
+	list, _ := ListSecrets(m, "dom1", ListOptions{})
+	if !reflect.DeepEqual(listNames(list), []string{"a", "b", "db/", "mq/"}) {
+		t.Fatalf("ListSecrets: Returned incorrect root %v", listNames(list))
+	}
+	list, _ = ListSecrets(m, "dom1", ListOptions{Path: "db", Metadata: true})
+	if !reflect.DeepEqual(listNames(list), []string{"db/primary/", "db/replica/"}) {
+		t.Fatalf("ListSecrets: Returned incorrect folder %v", listNames(list))
+	}
+	list, _ = ListSecrets(m, "dom1", ListOptions{Path: "db/", Recursive: true, Match: "*/*/creds"})
+	if !reflect.DeepEqual(listNames(list), []string{"db/primary/creds", "db/replica/creds"}) {
+		t.Fatalf("ListSecrets: Returned incorrect recursive list %v", listNames(list))
+	}
+	_, err := ListSecrets(m, "dom1", ListOptions{Path: "../dom2"})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("ListSecrets: Expected validation error for path")
+	}
+
+	deleted, err := DeleteSecretFolder(m, "dom1", "db/primary/")
+	if err != nil || !reflect.DeepEqual(deleted, []string{"db/primary/creds", "db/primary/tls"}) {
+		t.Fatalf("DeleteSecretFolder: Returned incorrect secrets %v", deleted)
+	}
+	_, err = DeleteSecretFolder(m, "dom1", "db/primary")
+	if !IsNotFound(err) {
+		t.Fatal("DeleteSecretFolder: Expected error for empty folder")
+	}
+	for _, folder := range []string{"", "/", "db/../mq"} {
+		_, err = DeleteSecretFolder(m, "dom1", folder)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Fatalf("DeleteSecretFolder: Expected validation error for %q", folder)
+		}
+	}
+
+	list, _ = ListSecrets(m, "dom1", ListOptions{Recursive: true})
+	if !reflect.DeepEqual(listNames(list), []string{"a", "b", "db/replica/creds", "mq/creds"}) {
+		t.Fatalf("ListSecrets: Returned incorrect secrets after delete %v", listNames(list))
+	}
+}
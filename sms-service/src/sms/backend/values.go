@@ -0,0 +1,306 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	smsconfig "sms/config"
+)
+
+// Value types that can be declared in Secret.Types. Values without
+// a declared type keep whatever JSON type they were written with.
+// Binary values are transported and stored as base64 strings
+const (
+	ValueString = "string"
+	ValueNumber = "number"
+	ValueBool   = "bool"
+	ValueBinary = "binary"
+	ValueJSON   = "json"
+)
+
+// typesKey and metaKey are the reserved keys under which backends
+// that can only store values keep the declared types and the
+// metadata of a secret
+const (
+	typesKey = "_sms_types"
+	metaKey  = "_sms_meta"
+)
+
+// Default size limits used when no configuration has been loaded
+const (
+	defaultMaxValueBytes  = 1 << 16
+	defaultMaxSecretBytes = 1 << 19
+)
+
+// ValidationError is returned by Validate. TooLarge is set when a
+// value or the whole secret exceeds the configured size limits
+type ValidationError struct {
+	Message  string
+	TooLarge bool
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalidSecret(format string, a ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, a...)}
+}
+
+// sizeLimits returns the configured value and secret size limits
+func sizeLimits() (int, int) {
+	maxValue, maxSecret := defaultMaxValueBytes, defaultMaxSecretBytes
+	if conf := smsconfig.Current(); conf != nil {
+		if conf.MaxValueBytes > 0 {
+			maxValue = conf.MaxValueBytes
+		}
+		if conf.MaxSecretBytes > 0 {
+			maxSecret = conf.MaxSecretBytes
+		}
+	}
+	return maxValue, maxSecret
+}
+
+// ValueType returns the declared type of a value or, without a
+// declaration, the type of its JSON representation
+func (s Secret) ValueType(key string) string {
+	if t, ok := s.Types[key]; ok {
+		return t
+	}
+
+	switch s.Values[key].(type) {
+	case string:
+		return ValueString
+	case bool:
+		return ValueBool
+	case float64, json.Number, int, int64:
+		return ValueNumber
+	}
+	return ValueJSON
+}
+
+// Binary returns the decoded bytes of a binary value
+func (s Secret) Binary(key string) ([]byte, error) {
+	str, ok := s.Values[key].(string)
+	if !ok {
+		return nil, errors.New("Value of " + key + " is not base64 encoded")
+	}
+	return base64.StdEncoding.DecodeString(str)
+}
+
+// checkType reports whether val matches the declared type t and
+// returns the size of the value
+func checkType(t string, val interface{}) (int, error) {
+
+	switch t {
+	case ValueString:
+		if s, ok := val.(string); ok {
+			return len(s), nil
+		}
+	case ValueNumber:
+		switch val.(type) {
+		case float64, json.Number, int, int64:
+			return 0, nil
+		}
+	case ValueBool:
+		if _, ok := val.(bool); ok {
+			return 0, nil
+		}
+	case ValueBinary:
+		if s, ok := val.(string); ok {
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return 0, errors.New("binary values must be base64 encoded")
+			}
+			return len(data), nil
+		}
+	case ValueJSON:
+		data, err := json.Marshal(val)
+		return len(data), err
+	default:
+		return 0, errors.New("unknown type " + t)
+	}
+
+	return 0, fmt.Errorf("value is not of type %s", t)
+}
+
+// Validate checks the declared types and the size limits of a secret
+func (s Secret) Validate() error {
+
+	maxValue, maxSecret := sizeLimits()
+
+	keys := make([]string, 0, len(s.Types))
+	for k := range s.Types {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := s.Values[k]; !ok {
+			return invalidSecret("Type declared for missing key %s", k)
+		}
+	}
+
+	err := s.Metadata.Validate()
+	if err != nil {
+		return err
+	}
+
+	for k, v := range s.Values {
+		if k == typesKey || k == metaKey {
+			return invalidSecret("Key %s is reserved", k)
+		}
+		size, err := checkType(s.ValueType(k), v)
+		if err != nil {
+			return invalidSecret("Invalid value for %s: %s", k, err.Error())
+		}
+		if size > maxValue {
+			return &ValidationError{
+				Message:  fmt.Sprintf("Value of %s is %d bytes, the limit is %d", k, size, maxValue),
+				TooLarge: true,
+			}
+		}
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return invalidSecret("Invalid secret: %s", err.Error())
+	}
+	if len(data) > maxSecret {
+		return &ValidationError{
+			Message:  fmt.Sprintf("Secret is %d bytes, the limit is %d", len(data), maxSecret),
+			TooLarge: true,
+		}
+	}
+
+	return nil
+}
+
+// packTypes returns the values of a secret with its declared types
+// stored under typesKey
+func packTypes(sec Secret) map[string]interface{} {
+
+	if len(sec.Types) == 0 {
+		return sec.Values
+	}
+
+	values := make(map[string]interface{}, len(sec.Values)+1)
+	for k, v := range sec.Values {
+		values[k] = v
+	}
+	types := make(map[string]interface{}, len(sec.Types))
+	for k, t := range sec.Types {
+		types[k] = t
+	}
+	values[typesKey] = types
+	return values
+}
+
+// unpackTypes is the reverse of packTypes
+func unpackTypes(name string, values map[string]interface{}) Secret {
+
+	sec := Secret{Name: name, Values: values}
+	raw, ok := values[typesKey].(map[string]interface{})
+	if !ok {
+		return sec
+	}
+
+	sec.Values = make(map[string]interface{}, len(values)-1)
+	for k, v := range values {
+		if k != typesKey {
+			sec.Values[k] = v
+		}
+	}
+	sec.Types = make(map[string]string, len(raw))
+	for k, t := range raw {
+		sec.Types[k] = fmt.Sprint(t)
+	}
+	return sec
+}
+
+// packSecret returns the values of a secret as packTypes does and
+// adds its metadata under metaKey
+func packSecret(sec Secret) map[string]interface{} {
+
+	values := packTypes(sec)
+	if sec.Metadata == nil {
+		return values
+	}
+
+	packed := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		packed[k] = v
+	}
+	meta := map[string]interface{}{
+		"description": sec.Metadata.Description,
+		"owner":       sec.Metadata.Owner,
+		"created":     sec.Metadata.Created,
+		"updated":     sec.Metadata.Updated,
+		"modifiedby":  sec.Metadata.ModifiedBy,
+		"expires":     sec.Metadata.Expires,
+	}
+	if len(sec.Metadata.Labels) > 0 {
+		labels := make(map[string]interface{}, len(sec.Metadata.Labels))
+		for k, v := range sec.Metadata.Labels {
+			labels[k] = v
+		}
+		meta["labels"] = labels
+	}
+	packed[metaKey] = meta
+	return packed
+}
+
+// unpackSecret is the reverse of packSecret
+func unpackSecret(name string, values map[string]interface{}) Secret {
+
+	raw, ok := values[metaKey].(map[string]interface{})
+	if !ok {
+		return unpackTypes(name, values)
+	}
+
+	rest := make(map[string]interface{}, len(values)-1)
+	for k, v := range values {
+		if k != metaKey {
+			rest[k] = v
+		}
+	}
+
+	sec := unpackTypes(name, rest)
+	str := func(k string) string {
+		s, _ := raw[k].(string)
+		return s
+	}
+	sec.Metadata = &Metadata{
+		Description: str("description"),
+		Owner:       str("owner"),
+		Created:     str("created"),
+		Updated:     str("updated"),
+		ModifiedBy:  str("modifiedby"),
+		Expires:     str("expires"),
+	}
+	if labels, ok := raw["labels"].(map[string]interface{}); ok && len(labels) > 0 {
+		sec.Metadata.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			sec.Metadata.Labels[k] = fmt.Sprint(v)
+		}
+	}
+	return sec
+}
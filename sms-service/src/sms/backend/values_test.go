@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	smsconfig "sms/config"
+)
+
+func TestSecretValidate(t *testing.T) {
+
+	blob := []byte{0x00, 0xfe, 0xed, 0xfe, 0xed}
+	sec := Secret{
+		Name: "typed",
+		Values: map[string]interface{}{
+			"user":     "admin",
+			"port":     json.Number("5432"),
+			"enabled":  true,
+			"keystore": base64.StdEncoding.EncodeToString(blob),
+			"conf":     map[string]interface{}{"a": "b"},
+		},
+		Types: map[string]string{"keystore": ValueBinary, "port": ValueNumber},
+	}
+	err := sec.Validate()
+	if err != nil {
+		t.Fatal("Validate: Returned error for valid secret: " + err.Error())
+	}
+
+	types := map[string]string{}
+	for k := range sec.Values {
+		types[k] = sec.ValueType(k)
+	}
+	expected := map[string]string{"user": ValueString, "port": ValueNumber,
+		"enabled": ValueBool, "keystore": ValueBinary, "conf": ValueJSON}
+	if reflect.DeepEqual(types, expected) == false {
+		t.Fatalf("ValueType: Returned incorrect types %v", types)
+	}
+
+	data, err := sec.Binary("keystore")
+	if err != nil || reflect.DeepEqual(data, blob) == false {
+		t.Fatal("Binary: Returned incorrect data")
+	}
+
+	invalid := []Secret{
+		{Values: map[string]interface{}{"k": "not base64!"}, Types: map[string]string{"k": ValueBinary}},
+		{Values: map[string]interface{}{"k": "1"}, Types: map[string]string{"k": ValueNumber}},
+		{Values: map[string]interface{}{"k": "v"}, Types: map[string]string{"k": "date"}},
+		{Values: map[string]interface{}{"k": "v"}, Types: map[string]string{"other": ValueString}},
+		{Values: map[string]interface{}{typesKey: "v"}},
+		{Values: map[string]interface{}{metaKey: "v"}},
+		{Values: map[string]interface{}{"k": "v"}, Metadata: &Metadata{Expires: "tomorrow"}},
+	}
+	for _, s := range invalid {
+		err = s.Validate()
+		if verr, ok := err.(*ValidationError); !ok || verr.TooLarge {
+			t.Fatalf("Validate: Expected validation error for %v", s)
+		}
+	}
+}
+
+func TestSecretSizeLimits(t *testing.T) {
+
+	old := smsconfig.SMSConfig
+	defer func() { smsconfig.SMSConfig = old }()
+	smsconfig.SMSConfig = &smsconfig.SMSConfiguration{MaxValueBytes: 8, MaxSecretBytes: 100}
+
+	sec := Secret{Values: map[string]interface{}{
+		"k": base64.StdEncoding.EncodeToString([]byte("12345678")),
+	}, Types: map[string]string{"k": ValueBinary}}
+	if err := sec.Validate(); err != nil {
+		t.Fatal("Validate: Decoded binary size should be within the limit")
+	}
+
+	sec.Values["k"] = "123456789"
+	sec.Types = nil
+	err := sec.Validate()
+	if verr, ok := err.(*ValidationError); !ok || !verr.TooLarge {
+		t.Fatal("Validate: Expected value size error")
+	}
+
+	sec.Values = map[string]interface{}{}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		sec.Values[k] = "12345678"
+	}
+	err = sec.Validate()
+	if verr, ok := err.(*ValidationError); !ok || !verr.TooLarge ||
+		!strings.Contains(err.Error(), "Secret is") {
+		t.Fatal("Validate: Expected secret size error")
+	}
+}
+
+func TestPackTypes(t *testing.T) {
+
+	sec := Secret{
+		Name:   "typed",
+		Values: map[string]interface{}{"k": "AAEC"},
+		Types:  map[string]string{"k": ValueBinary},
+	}
+	values := packTypes(sec)
+	if _, ok := sec.Values[typesKey]; ok {
+		t.Fatal("packTypes: Modified the values of the secret")
+	}
+
+	// Simulate the JSON round trip done by backends
+	data, _ := json.Marshal(values)
+	stored := map[string]interface{}{}
+	json.Unmarshal(data, &stored)
+
+	got := unpackTypes("typed", stored)
+	if reflect.DeepEqual(got, sec) == false {
+		t.Fatalf("unpackTypes: Returned %v, expected %v", got, sec)
+	}
+
+	plain := Secret{Name: "plain", Values: map[string]interface{}{"k": "v"}}
+	if reflect.DeepEqual(unpackTypes("plain", packTypes(plain)), plain) == false {
+		t.Fatal("unpackTypes: Secret without types was changed")
+	}
+
+	sec.Metadata = &Metadata{Created: "2018-01-01T00:00:00Z", Expires: "2019-01-01T00:00:00Z",
+		Owner: "team-a", ModifiedBy: "client1", Labels: map[string]string{"env": "prod"}}
+	data, _ = json.Marshal(packSecret(sec))
+	stored = map[string]interface{}{}
+	json.Unmarshal(data, &stored)
+	if reflect.DeepEqual(unpackSecret("typed", stored), sec) == false {
+		t.Fatal("unpackSecret: Metadata was not restored")
+	}
+	stamped := sec
+	stamped.Metadata = &Metadata{Created: "2018-02-01T00:00:00Z", Updated: "2018-03-01T00:00:00Z",
+		Expires: "2019-01-01T00:00:00Z", Owner: "team-a", ModifiedBy: "client2",
+		Labels: map[string]string{"env": "prod"}}
+	if SecretVersion(sec) != SecretVersion(stamped) {
+		t.Fatal("SecretVersion: Version depends on times or modifier")
+	}
+	stamped.Metadata.Owner = "team-b"
+	if SecretVersion(sec) == SecretVersion(stamped) {
+		t.Fatal("SecretVersion: Version does not depend on client metadata")
+	}
+	plain = Secret{Name: "typed", Values: sec.Values, Types: sec.Types}
+	if SecretVersion(plain) != SecretVersion(Secret{Name: "typed", Values: sec.Values, Types: sec.Types,
+		Metadata: &Metadata{Created: "2018-01-01T00:00:00Z"}}) {
+		t.Fatal("SecretVersion: Version of a secret without client metadata changed")
+	}
+}
@@ -24,6 +24,8 @@ import (
 
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -37,6 +39,7 @@ type Vault struct {
 	roleID                string
 	secretID              string
 	vaultAddress          string
+	authDir               string
 	vaultClient           *vaultapi.Client
 	vaultMountPrefix      string
 	internalDomain        string
@@ -67,6 +70,9 @@ func (v *Vault) initVaultClient() error {
 	v.internalDomain = "smsinternaldomain"
 	v.internalDomainMounted = false
 	v.prkey = ""
+	if v.authDir == "" {
+		v.authDir = "auth"
+	}
 	return nil
 }
 
@@ -89,6 +95,44 @@ func (v *Vault) Init() error {
 	return nil
 }
 
+// open connects to a Vault that is already initialized and unsealed.
+// It is used when SMS is not running, such as for backups, and never
+// initializes Vault as the unseal shards would be lost
+func (v *Vault) open() error {
+
+	err := v.initVaultClient()
+	if err != nil {
+		return err
+	}
+
+	init, err := v.vaultClient.Sys().InitStatus()
+	if smslogger.CheckError(err, "Get Vault Init Status") != nil {
+		return errors.New("Unable to get init status of Vault at " + v.vaultAddress)
+	}
+	if !init {
+		return errors.New("Vault at " + v.vaultAddress +
+			" is not initialized. Start SMS once to initialize it")
+	}
+
+	sealed, err := v.GetStatus()
+	if err != nil {
+		return err
+	}
+	if sealed {
+		return errors.New("Vault at " + v.vaultAddress + " is sealed. Unseal it first")
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	err = v.initRole()
+	if smslogger.CheckError(err, "InitRole") != nil {
+		return errors.New("Unable to log in to Vault at " + v.vaultAddress)
+	}
+
+	return nil
+}
+
 // GetStatus returns the current seal status of vault
 func (v *Vault) GetStatus() (bool, error) {
 
@@ -145,7 +189,13 @@ func (v *Vault) Unseal(shard string) error {
 // a mount path in vault
 func (v *Vault) GetSecret(dom string, name string) (Secret, error) {
 
-	err := v.checkToken()
+	// Domain and secret names become part of the Vault path
+	err := checkBackendPath(dom, name)
+	if smslogger.CheckError(err, "Secret Path") != nil {
+		return Secret{}, err
+	}
+
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Tocken Check") != nil {
 		return Secret{}, errors.New("Token check failed")
 	}
@@ -161,39 +211,102 @@ func (v *Vault) GetSecret(dom string, name string) (Secret, error) {
 	// sec and err are nil in the case where a path does not exist
 	if sec == nil {
 		smslogger.WriteWarn("Vault read was empty. Invalid Path")
-		return Secret{}, errors.New("Secret not found at the provided path")
+		return Secret{}, ErrSecretNotFound
 	}
 
-	return Secret{Name: name, Values: sec.Data}, nil
+	return unpackSecret(name, sec.Data), nil
 }
 
 // ListSecret returns a list of secret names on a particular domain
-// The values of the secret are not returned
+// The values of the secret are not returned. Folders are walked so
+// that secrets in them are returned with their full path
 func (v *Vault) ListSecret(dom string) ([]string, error) {
 
-	err := v.checkToken()
+	err := checkDomainPath(dom)
+	if smslogger.CheckError(err, "Domain Name") != nil {
+		return nil, err
+	}
+
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Token Check") != nil {
 		return nil, errors.New("Token check failed")
 	}
 
-	dom = strings.TrimSpace(dom)
-	dom = v.vaultMountPrefix + "/" + dom
+	name := strings.TrimSpace(dom)
+	dom = v.vaultMountPrefix + "/" + name
 
-	sec, err := v.vaultClient.Logical().List(dom)
-	if smslogger.CheckError(err, "Read Secret") != nil {
-		return nil, errors.New("Unable to read Secret at provided path")
+	retval := []string{}
+	folders := []string{""}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+
+		path := dom
+		if folder != "" {
+			path = dom + "/" + strings.TrimSuffix(folder, "/")
+		}
+		sec, err := v.vaultClient.Logical().List(path)
+		if smslogger.CheckError(err, "Read Secret") != nil {
+			return nil, errors.New("Unable to read Secret at provided path")
+		}
+
+		// sec and err are nil in the case where a path does not exist.
+		// This is also the case for a domain without any secrets or
+		// a folder whose last secret was just deleted
+		if sec == nil {
+			if folder != "" {
+				continue
+			}
+			_, err = v.GetSecretDomain(name)
+			if err == nil {
+				return retval, nil
+			}
+			smslogger.WriteWarn("Vaultclient returned empty data")
+			return nil, err
+		}
+
+		val, ok := sec.Data["keys"].([]interface{})
+		if !ok {
+			smslogger.WriteError("Secret not found at the provided path")
+			return nil, ErrSecretNotFound
+		}
+
+		// Vault lists folders with a trailing slash
+		for _, v := range val {
+			key := folder + fmt.Sprint(v)
+			if strings.HasSuffix(key, "/") {
+				folders = append(folders, key)
+				continue
+			}
+			retval = append(retval, key)
+		}
 	}
 
-	// sec and err are nil in the case where a path does not exist
+	return retval, nil
+}
+
+// ListSecretDomain returns the names of all secret domains.
+// Domain names are stored as secrets in the internal domain
+func (v *Vault) ListSecretDomain() ([]string, error) {
+
+	err := v.checkToken()
+	if smslogger.CheckError(err, "Token Check") != nil {
+		return nil, errors.New("Token check failed")
+	}
+
+	sec, err := v.vaultClient.Logical().List(v.vaultMountPrefix + "/" + v.internalDomain)
+	if smslogger.CheckError(err, "List Domains") != nil {
+		return nil, errors.New("Unable to list domains")
+	}
+
+	// sec is nil when no domains have been created yet
 	if sec == nil {
-		smslogger.WriteWarn("Vaultclient returned empty data")
-		return nil, errors.New("Secret not found at the provided path")
+		return []string{}, nil
 	}
 
 	val, ok := sec.Data["keys"].([]interface{})
 	if !ok {
-		smslogger.WriteError("Secret not found at the provided path")
-		return nil, errors.New("Secret not found at the provided path")
+		return []string{}, nil
 	}
 
 	retval := make([]string, len(val))
@@ -238,7 +351,8 @@ func (v *Vault) mountInternalDomain(name string) error {
 
 // Stores the UUID created for secretdomain in vault
 // under v.vaultMountPrefix / smsinternal domain
-func (v *Vault) storeUUID(uuid string, name string) error {
+// together with the metadata of the domain
+func (v *Vault) storeUUID(dom SecretDomain) error {
 
 	// Check if token is still valid
 	err := v.checkToken()
@@ -252,10 +366,11 @@ func (v *Vault) storeUUID(uuid string, name string) error {
 	}
 
 	secret := Secret{
-		Name: name,
+		Name: dom.Name,
 		Values: map[string]interface{}{
-			"uuid": uuid,
+			"uuid": dom.UUID,
 		},
+		Metadata: dom.Metadata,
 	}
 
 	err = v.CreateSecret(v.internalDomain, secret)
@@ -266,16 +381,97 @@ func (v *Vault) storeUUID(uuid string, name string) error {
 	return nil
 }
 
+// GetSecretDomain returns the UUID and metadata stored for a secret domain
+func (v *Vault) GetSecretDomain(name string) (SecretDomain, error) {
+
+	name = strings.TrimSpace(name)
+	err := checkDomainPath(name)
+	if smslogger.CheckError(err, "Domain Name") != nil {
+		return SecretDomain{}, err
+	}
+
+	sec, err := v.GetSecret(v.internalDomain, name)
+	if smslogger.CheckError(err, "Get Domain") != nil {
+		if IsNotFound(err) {
+			return SecretDomain{}, ErrDomainNotFound
+		}
+		return SecretDomain{}, err
+	}
+
+	id, ok := sec.Values["uuid"].(string)
+	if !ok {
+		smslogger.WriteError("No UUID stored for domain " + name)
+		return SecretDomain{}, ErrDomainNotFound
+	}
+
+	return SecretDomain{UUID: id, Name: name, Metadata: sec.Metadata}, nil
+}
+
 // CreateSecretDomain mounts the kv backend on a path with the given name
 func (v *Vault) CreateSecretDomain(name string) (SecretDomain, error) {
 
+	// Only new domains have to follow the naming rules
+	name = strings.TrimSpace(name)
+	err := ValidateDomainName(name)
+	if smslogger.CheckError(err, "Domain Name") != nil {
+		return SecretDomain{}, err
+	}
+
+	uuid, _ := uuid.GenerateUUID()
+	dom := SecretDomain{UUID: uuid, Name: name}
+	err = v.createSecretDomain(dom)
+	if err != nil {
+		return SecretDomain{}, err
+	}
+	return dom, nil
+}
+
+// RestoreSecretDomain creates a secret domain keeping the UUID and
+// metadata it had when it was backed up
+func (v *Vault) RestoreSecretDomain(dom SecretDomain) error {
+
+	if dom.UUID == "" {
+		return errors.New("Missing UUID for domain " + dom.Name)
+	}
+
+	return v.createSecretDomain(dom)
+}
+
+// UpdateSecretDomain replaces the metadata stored for an existing
+// secret domain. The UUID of the domain is kept
+func (v *Vault) UpdateSecretDomain(dom SecretDomain) error {
+
+	cur, err := v.GetSecretDomain(dom.Name)
+	if smslogger.CheckError(err, "Update Domain") != nil {
+		return err
+	}
+
+	cur.Metadata = dom.Metadata
+	err = v.storeUUID(cur)
+	if smslogger.CheckError(err, "Update Domain") != nil {
+		return errors.New("Unable to update Secret Domain")
+	}
+	return nil
+}
+
+// createSecretDomain mounts the kv backend for a domain and stores
+// its UUID and metadata. Restored domains may have been created
+// before the naming rules, so only the path is checked here
+func (v *Vault) createSecretDomain(dom SecretDomain) error {
+
+	dom.Name = strings.TrimSpace(dom.Name)
+	name := dom.Name
+	err := checkDomainPath(name)
+	if smslogger.CheckError(err, "Domain Name") != nil {
+		return err
+	}
+
 	// Check if token is still valid
-	err := v.checkToken()
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Token Check") != nil {
-		return SecretDomain{}, errors.New("Token Check failed")
+		return errors.New("Token Check failed")
 	}
 
-	name = strings.TrimSpace(name)
 	mountPath := v.vaultMountPrefix + "/" + name
 	mountInput := &vaultapi.MountInput{
 		Type:        "kv",
@@ -289,29 +485,34 @@ func (v *Vault) CreateSecretDomain(name string) (SecretDomain, error) {
 	if smslogger.CheckError(err, "Create Domain") != nil {
 		if strings.Contains(err.Error(), "existing mount") {
 			//It is already mounted
-			return SecretDomain{}, errors.New("existing domain")
+			return errors.New("existing domain")
 		}
-		return SecretDomain{}, errors.New("Unable to create Secret Domain")
+		return errors.New("Unable to create Secret Domain")
 	}
 
-	uuid, _ := uuid.GenerateUUID()
-	err = v.storeUUID(uuid, name)
+	err = v.storeUUID(dom)
 	if smslogger.CheckError(err, "Store UUID") != nil {
 		// Mount was successful at this point.
 		// Rollback the mount operation since we could not
 		// store the UUID for the mount.
 		v.vaultClient.Sys().Unmount(mountPath)
-		return SecretDomain{}, errors.New("Unable to store Secret Domain UUID. Retry")
+		return errors.New("Unable to store Secret Domain UUID. Retry")
 	}
 
-	return SecretDomain{uuid, name}, nil
+	return nil
 }
 
 // CreateSecret creates a secret mounted on a particular domain name
 // The secret itself is mounted on a path specified by name
 func (v *Vault) CreateSecret(dom string, sec Secret) error {
 
-	err := v.checkToken()
+	// Domain and secret names become part of the Vault path
+	err := checkBackendPath(dom, sec.Name)
+	if smslogger.CheckError(err, "Secret Path") != nil {
+		return err
+	}
+
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Token Check") != nil {
 		return errors.New("Token check failed")
 	}
@@ -321,7 +522,8 @@ func (v *Vault) CreateSecret(dom string, sec Secret) error {
 
 	// Vault return is empty on successful write
 	// TODO: Check if values is not empty
-	_, err = v.vaultClient.Logical().Write(dom+"/"+sec.Name, sec.Values)
+	// Declared value types are stored next to the values
+	_, err = v.vaultClient.Logical().Write(dom+"/"+sec.Name, packSecret(sec))
 	if smslogger.CheckError(err, "Create Secret") != nil {
 		return errors.New("Unable to create Secret at provided path")
 	}
@@ -333,7 +535,12 @@ func (v *Vault) CreateSecret(dom string, sec Secret) error {
 // an unmount operation on the given path in Vault
 func (v *Vault) DeleteSecretDomain(dom string) error {
 
-	err := v.checkToken()
+	err := checkDomainPath(dom)
+	if smslogger.CheckError(err, "Domain Name") != nil {
+		return err
+	}
+
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Token Check") != nil {
 		return errors.New("Token Check Failed")
 	}
@@ -341,18 +548,38 @@ func (v *Vault) DeleteSecretDomain(dom string) error {
 	dom = strings.TrimSpace(dom)
 	mountPath := v.vaultMountPrefix + "/" + dom
 
+	// Unmounting a path that is not mounted succeeds, so report
+	// unknown domains here
+	_, err = v.GetSecretDomain(dom)
+	if IsNotFound(err) {
+		return err
+	}
+
 	err = v.vaultClient.Sys().Unmount(mountPath)
 	if smslogger.CheckError(err, "Delete Domain") != nil {
 		return errors.New("Unable to delete domain specified")
 	}
 
+	// Remove the UUID stored for the domain so that it is no
+	// longer listed
+	_, err = v.vaultClient.Logical().Delete(v.vaultMountPrefix + "/" + v.internalDomain + "/" + dom)
+	if smslogger.CheckError(err, "Delete Domain UUID") != nil {
+		smslogger.WriteWarn("Unable to remove UUID for deleted domain " + dom)
+	}
+
 	return nil
 }
 
 // DeleteSecret deletes a secret mounted on the path provided
 func (v *Vault) DeleteSecret(dom string, name string) error {
 
-	err := v.checkToken()
+	// Domain and secret names become part of the Vault path
+	err := checkBackendPath(dom, name)
+	if smslogger.CheckError(err, "Secret Path") != nil {
+		return err
+	}
+
+	err = v.checkToken()
 	if smslogger.CheckError(err, "Token Check") != nil {
 		return errors.New("Token check failed")
 	}
@@ -369,6 +596,26 @@ func (v *Vault) DeleteSecret(dom string, name string) error {
 	return nil
 }
 
+// Close revokes the temporary token currently held by the client
+// so that it cannot be reused after SMS has exited
+func (v *Vault) Close() error {
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.vaultClient == nil || v.vaultClient.Token() == "" {
+		return nil
+	}
+
+	err := v.vaultClient.Auth().Token().RevokeSelf("")
+	if smslogger.CheckError(err, "Revoke Temporary Token") != nil {
+		return errors.New("Unable to revoke temporary token")
+	}
+
+	v.vaultClient.ClearToken()
+	return nil
+}
+
 // initRole is called only once during SMS bring up
 // It initially creates a role and secret id associated with
 // that role. Later restarts will use the existing role-id
@@ -384,17 +631,19 @@ func (v *Vault) initRole() error {
 	defer v.vaultClient.ClearToken()
 
 	// Check if roleID and secretID has already been created
-	rID, error := smsauth.ReadFromFile("auth/role")
+	rID, error := smsauth.ReadFromFile(filepath.Join(v.authDir, "role"))
 	if error != nil {
 		smslogger.WriteWarn("Unable to find RoleID. Generating...")
 	} else {
-		sID, error := smsauth.ReadFromFile("auth/secret")
+		sID, error := smsauth.ReadFromFile(filepath.Join(v.authDir, "secret"))
 		if error != nil {
 			smslogger.WriteWarn("Unable to find secretID. Generating...")
 		} else {
 			v.roleID = rID
 			v.secretID = sID
 			v.initRoleDone = true
+			// Root token is not needed once the role exists
+			v.vaultToken = ""
 			return nil
 		}
 	}
@@ -456,15 +705,16 @@ func (v *Vault) initRole() error {
 	err = v.vaultClient.Auth().Token().RevokeSelf(v.vaultToken)
 	if smslogger.CheckError(err, "Revoke Root Token") != nil {
 		smslogger.WriteWarn("Unable to Revoke Token")
-	} else {
-		// Revoked successfully and clear it
-		v.vaultToken = ""
 	}
+	// Clear the token even if revocation failed. It is not
+	// used again after the role has been created
+	v.vaultToken = ""
 
 	// Store the role-id and secret-id
 	// We will need this if SMS restarts
-	smsauth.WriteToFile(v.roleID, "auth/role")
-	smsauth.WriteToFile(v.secretID, "auth/secret")
+	os.MkdirAll(v.authDir, 0700)
+	smsauth.WriteToFile(v.roleID, filepath.Join(v.authDir, "role"))
+	smsauth.WriteToFile(v.secretID, filepath.Join(v.authDir, "secret"))
 
 	return nil
 }
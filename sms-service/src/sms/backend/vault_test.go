@@ -25,6 +25,7 @@ import (
 	vaulttesting "github.com/hashicorp/vault/vault"
 	"reflect"
 	smslog "sms/log"
+	"sort"
 	"testing"
 )
 
@@ -90,6 +91,10 @@ func TestInitRole(t *testing.T) {
 	if err != nil {
 		t.Fatal("InitRole: InitRole() failed to create roles")
 	}
+
+	if v.vaultToken != "" {
+		t.Fatal("InitRole: Root token was not cleared")
+	}
 }
 
 func TestGetStatus(t *testing.T) {
@@ -128,6 +133,31 @@ func TestCreateSecretDomain(t *testing.T) {
 	}
 }
 
+func TestListSecretDomain(t *testing.T) {
+
+	tc, v := createLocalVaultServer(t)
+	defer tc.Cleanup()
+
+	domains, err := v.ListSecretDomain()
+	if err != nil || len(domains) != 0 {
+		t.Fatal("ListSecretDomain: Expected empty list before creating domains")
+	}
+
+	_, err = v.CreateSecretDomain("testdomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domains, err = v.ListSecretDomain()
+	if err != nil {
+		t.Fatal("ListSecretDomain: Returned error")
+	}
+
+	if reflect.DeepEqual(domains, []string{"testdomain"}) == false {
+		t.Fatal("ListSecretDomain: Returned incorrect domains")
+	}
+}
+
 func TestDeleteSecretDomain(t *testing.T) {
 
 	tc, v := createLocalVaultServer(t)
@@ -142,6 +172,11 @@ func TestDeleteSecretDomain(t *testing.T) {
 	if err != nil {
 		t.Fatal("DeleteSecretDomain: Unable to delete domain")
 	}
+
+	domains, err := v.ListSecretDomain()
+	if err != nil || len(domains) != 0 {
+		t.Fatal("DeleteSecretDomain: Deleted domain is still listed")
+	}
 }
 
 func TestCreateSecret(t *testing.T) {
@@ -200,6 +235,16 @@ func TestListSecret(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	names, err := v.ListSecret("testdomain")
+	if err != nil || len(names) != 0 {
+		t.Fatal("ListSecret: Expected empty list for empty domain")
+	}
+
+	_, err = v.ListSecret("nodomain")
+	if err == nil {
+		t.Fatal("ListSecret: Expected error for missing domain")
+	}
+
 	err = v.CreateSecret("testdomain", secret)
 	if err != nil {
 		t.Fatal(err)
@@ -209,6 +254,23 @@ func TestListSecret(t *testing.T) {
 	if err != nil {
 		t.Fatal("ListSecret: Returned error")
 	}
+
+	nested := Secret{Name: "db/primary/creds", Values: secret.Values}
+	err = v.CreateSecret("testdomain", nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err = v.ListSecret("testdomain")
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"db/primary/creds", secret.Name}) {
+		t.Fatalf("ListSecret: Returned incorrect names %v", names)
+	}
+
+	err = v.CreateSecret("testdomain", Secret{Name: "../escape", Values: secret.Values})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatal("CreateSecret: Expected validation error for path traversal")
+	}
 }
 
 func TestDeleteSecret(t *testing.T) {
@@ -267,3 +329,116 @@ func TestInitializeVault(t *testing.T) {
 		t.Fatal("InitializeVault: Error initializing Vault")
 	}
 }
+
+func TestClose(t *testing.T) {
+
+	tc, v := createLocalVaultServer(t)
+	defer tc.Cleanup()
+
+	_, err := v.CreateSecretDomain("testdomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = v.Close()
+	if err != nil {
+		t.Fatal("Close: Unable to revoke temporary token")
+	}
+
+	if v.vaultClient.Token() != "" {
+		t.Fatal("Close: Token was not cleared")
+	}
+}
+
+func TestExportImportDomain(t *testing.T) {
+
+	tc, v := createLocalVaultServer(t)
+	defer tc.Cleanup()
+
+	_, err := v.CreateSecretDomain("testdomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := Secret{
+		Name:   "testsecret",
+		Values: map[string]interface{}{"name": "john", "profession": "engineer"},
+	}
+	err = v.CreateSecret("testdomain", sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := ExportDomain(v, "testdomain")
+	if err != nil {
+		t.Fatal("ExportDomain: Error exporting domain")
+	}
+	if bundle.Domain != "testdomain" || len(bundle.Secrets) != 1 ||
+		reflect.DeepEqual(bundle.Secrets[0], sec) == false {
+		t.Fatal("ExportDomain: Returned incorrect bundle")
+	}
+
+	count, err := ImportDomain(v, "otherdomain", bundle, false, "")
+	if err != nil || count != 1 {
+		t.Fatal("ImportDomain: Error importing into new domain")
+	}
+	got, err := v.GetSecret("otherdomain", "testsecret")
+	if err != nil || reflect.DeepEqual(got, sec) == false {
+		t.Fatal("ImportDomain: Imported secret does not match")
+	}
+
+	_, err = ImportDomain(v, "otherdomain", bundle, false, "")
+	if err != ErrSecretExists {
+		t.Fatal("ImportDomain: Expected conflict for existing secret")
+	}
+
+	_, err = ImportDomain(v, "otherdomain", bundle, true, "")
+	if err != nil {
+		t.Fatal("ImportDomain: Error overwriting existing secret")
+	}
+}
+
+func TestRestoreSecretDomain(t *testing.T) {
+
+	tc, v := createLocalVaultServer(t)
+	defer tc.Cleanup()
+
+	dom, err := v.CreateSecretDomain("testdomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := v.GetSecretDomain("testdomain")
+	if err != nil || got != dom {
+		t.Fatal("GetSecretDomain: Returned incorrect domain")
+	}
+
+	restored := SecretDomain{UUID: "b7f3c0a2-5c8e-4d0b-9a55-0e1c7f6f2f10", Name: "restored"}
+	err = v.RestoreSecretDomain(restored)
+	if err != nil {
+		t.Fatal("RestoreSecretDomain: Error restoring domain")
+	}
+	got, err = v.GetSecretDomain("restored")
+	if err != nil || got != restored {
+		t.Fatal("RestoreSecretDomain: UUID was not preserved")
+	}
+
+	_, err = v.GetSecretDomain("missing")
+	if err == nil {
+		t.Fatal("GetSecretDomain: Expected error for missing domain")
+	}
+
+	restored.Metadata = &Metadata{Owner: "team-a", Labels: map[string]string{"env": "prod"}}
+	err = v.UpdateSecretDomain(restored)
+	if err != nil {
+		t.Fatal("UpdateSecretDomain: Error updating domain")
+	}
+	got, err = v.GetSecretDomain("restored")
+	if err != nil || got.UUID != restored.UUID || got.Metadata == nil ||
+		got.Metadata.Owner != "team-a" || got.Metadata.Labels["env"] != "prod" {
+		t.Fatal("UpdateSecretDomain: Metadata was not stored")
+	}
+
+	err = v.UpdateSecretDomain(SecretDomain{Name: "missing"})
+	if err == nil {
+		t.Fatal("UpdateSecretDomain: Expected error for missing domain")
+	}
+}
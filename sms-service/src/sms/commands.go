@@ -0,0 +1,361 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	smsauth "sms/auth"
+	smsbackend "sms/backend"
+	smsconfig "sms/config"
+	smslogger "sms/log"
+)
+
+// commandOutput is where the maintenance commands print their results
+var commandOutput io.Writer = os.Stdout
+
+// policyNote tells operators what a backup does not contain. Vault
+// policies are not data of the SecretBackend interface, a restore
+// target may not have policies at all and writing them needs the
+// Vault root token
+const policyNote = "Vault policies are not part of the backup. SMS creates its own policy " +
+	"again when it first starts on the restored backend; back up any other " +
+	"policies with Vault's own tools"
+
+// commandUsage prints the description of a maintenance command
+// followed by its flags
+func commandUsage(fs *flag.FlagSet, text string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n%s\n\n", fs.Name(), text)
+		fs.PrintDefaults()
+	}
+}
+
+// initBackend is replaced in tests to avoid a running Vault. The
+// commands only open a backend that is initialized and unsealed
+var initBackend = func(configFile string) (smsbackend.SecretBackend, error) {
+
+	conf, err := loadConfiguration(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return smsbackend.OpenSecretBackend(conf)
+}
+
+// initTargetBackend loads a second configuration for the backend
+// that data is migrated to. Overrides from the environment and the
+// command line only apply to the main configuration
+var initTargetBackend = func(configFile string) (smsbackend.SecretBackend, error) {
+
+	conf, err := smsconfig.LoadConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = smsauth.LoadConfigSecrets(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each backend stores the credentials it creates for itself
+	if smsconfig.SMSConfig != nil && conf.AuthDir == smsconfig.SMSConfig.AuthDir {
+		return nil, errors.New("auth_dir of " + configFile + " must differ from the source configuration")
+	}
+
+	// The target is opened like the source. An uninitialized or
+	// sealed target is refused instead of being initialized
+	return smsbackend.OpenSecretBackend(conf)
+}
+
+// runCommand runs one of the maintenance commands. They talk to
+// the backend directly and do not need a running SMS instance
+func runCommand(configFile string, args []string) error {
+
+	switch args[0] {
+	case "backup":
+		return backupCommand(configFile, args[1:])
+	case "restore":
+		return restoreCommand(configFile, args[1:])
+	case "verify":
+		return verifyCommand(args[1:])
+	case "migrate":
+		return migrateCommand(configFile, args[1:])
+	}
+
+	return errors.New("Unknown command " + args[0] + ". Valid commands are backup, restore, verify and migrate")
+}
+
+func readArchive(file string) (smsbackend.BackupArchive, error) {
+
+	var archive smsbackend.BackupArchive
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return archive, err
+	}
+
+	err = json.Unmarshal(data, &archive)
+	if err != nil {
+		return archive, errors.New("Invalid backup archive " + file + ": " + err.Error())
+	}
+	return archive, nil
+}
+
+// openArchive reads, verifies and decrypts a backup archive
+func openArchive(file string, keyFile string) (smsbackend.Backup, error) {
+
+	archive, err := readArchive(file)
+	if err != nil {
+		return smsbackend.Backup{}, err
+	}
+
+	prKey, err := smsauth.ReadFromFile(keyFile)
+	if err != nil {
+		return smsbackend.Backup{}, err
+	}
+
+	return smsbackend.OpenBackup(archive, prKey)
+}
+
+// backupCommand writes all domains and secrets to an archive
+// encrypted with a PGP public key
+func backupCommand(configFile string, args []string) error {
+
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.Usage = commandUsage(fs, "Writes every domain with its UUID and metadata and all of "+
+		"its secrets to an encrypted archive.\n"+policyNote)
+	out := fs.String("out", "", "File to write the encrypted backup to")
+	keyFile := fs.String("pgpkey", "", "File with the base64 encoded PGP public key to encrypt with")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *out == "" || *keyFile == "" {
+		return errors.New("backup requires -out and -pgpkey")
+	}
+
+	pbKey, err := smsauth.ReadFromFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	backend, err := initBackend(configFile)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	bk, err := smsbackend.CreateBackup(backend)
+	if err != nil {
+		return err
+	}
+
+	archive, err := smsbackend.SealBackup(bk, pbKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = smsauth.WriteToFile(string(data), *out)
+	if err != nil {
+		return err
+	}
+
+	secrets := 0
+	for _, d := range bk.Domains {
+		secrets += len(d.Secrets)
+	}
+	fmt.Fprintf(commandOutput, "Backed up %d domains and %d secrets to %s\n", len(bk.Domains), secrets, *out)
+	fmt.Fprintf(commandOutput, "Checksum: %s\n", archive.Checksum)
+	fmt.Fprintln(commandOutput, policyNote)
+	return nil
+}
+
+// restoreCommand recreates all domains and secrets of an archive
+// on an empty backend
+func restoreCommand(configFile string, args []string) error {
+
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = commandUsage(fs, "Recreates every domain of an archive with its UUID, "+
+		"metadata and secrets on a backend without domains.\n"+policyNote)
+	in := fs.String("in", "", "Encrypted backup file to restore")
+	keyFile := fs.String("pgpkey", "", "File with the base64 encoded PGP private key to decrypt with")
+	dryRun := fs.Bool("dry-run", false, "Verify the backup and the target backend without writing")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *in == "" || *keyFile == "" {
+		return errors.New("restore requires -in and -pgpkey")
+	}
+
+	bk, err := openArchive(*in, *keyFile)
+	if err != nil {
+		return err
+	}
+
+	backend, err := initBackend(configFile)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	res, err := smsbackend.RestoreBackup(backend, bk, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "Restored"
+	if *dryRun {
+		verb = "Dry run: would restore"
+	}
+	for _, d := range res.Domains {
+		fmt.Fprintln(commandOutput, "  "+d)
+	}
+	fmt.Fprintf(commandOutput, "%s %d domains and %d secrets from backup created %s\n",
+		verb, len(res.Domains), res.Secrets, bk.Created)
+	fmt.Fprintln(commandOutput, policyNote)
+	return nil
+}
+
+// verifyCommand checks an archive without a backend. Without a
+// private key only the checksum of the encrypted data is checked
+func verifyCommand(args []string) error {
+
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	in := fs.String("in", "", "Encrypted backup file to verify")
+	keyFile := fs.String("pgpkey", "", "Optional file with the base64 encoded PGP private key")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("verify requires -in")
+	}
+
+	archive, err := readArchive(*in)
+	if err != nil {
+		return err
+	}
+	err = archive.Verify()
+	if err != nil {
+		return err
+	}
+
+	if *keyFile == "" {
+		fmt.Fprintf(commandOutput, "Archive checksum OK: %d domains, created %s\n", archive.Domains, archive.Created)
+		return nil
+	}
+
+	bk, err := openArchive(*in, *keyFile)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range bk.Domains {
+		fmt.Fprintf(commandOutput, "  %s (%s): %d secrets\n", d.Name, d.UUID, len(d.Secrets))
+	}
+	fmt.Fprintf(commandOutput, "Backup OK: %d domains, created %s\n", len(bk.Domains), bk.Created)
+	smslogger.WriteInfo("Verified backup " + *in)
+	return nil
+}
+
+// printMigrateEvent reports progress for every domain and for
+// every secret that is written
+func printMigrateEvent(e smsbackend.MigrateEvent) {
+
+	if e.Secret == "" {
+		fmt.Fprintf(commandOutput, "[%d/%d] %s (%s)\n", e.Index, e.Total, e.Domain, e.Action)
+		return
+	}
+
+	switch e.Action {
+	case smsbackend.MigrateCreated:
+		fmt.Fprintln(commandOutput, "  + "+e.Secret)
+	case smsbackend.MigrateUpdated:
+		fmt.Fprintln(commandOutput, "  ~ "+e.Secret)
+	}
+}
+
+// migrateCommand copies all domains and secrets from the backend in
+// the main configuration to the backend in the target configuration
+// and then checks that both match
+func migrateCommand(configFile string, args []string) error {
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	to := fs.String("to", "", "Configuration file of the backend to migrate to")
+	dryRun := fs.Bool("dry-run", false, "Report what would be copied without writing")
+	verifyOnly := fs.Bool("verify-only", false, "Only compare the source and target backends")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("migrate requires -to")
+	}
+
+	src, err := initBackend(configFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := initTargetBackend(*to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if !*verifyOnly {
+		res, err := smsbackend.Migrate(src, dst, *dryRun, printMigrateEvent)
+		if err != nil {
+			return err
+		}
+
+		prefix := ""
+		if *dryRun {
+			prefix = "Dry run: "
+		}
+		fmt.Fprintf(commandOutput, "%s%d domains, %d secrets created, %d updated, %d unchanged\n",
+			prefix, res.Domains, res.Created, res.Updated, res.Unchanged)
+		if *dryRun {
+			return nil
+		}
+	}
+
+	problems, err := smsbackend.VerifyMigration(src, dst)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(commandOutput, "  "+p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Verification failed: %d differences", len(problems))
+	}
+
+	fmt.Fprintln(commandOutput, "Verification passed")
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	smsauth "sms/auth"
+	smsbackend "sms/backend"
+)
+
+// emptyBackend is a backend without any domains
+type emptyBackend struct {
+	smsbackend.SecretBackend
+}
+
+func (e *emptyBackend) ListSecretDomain() ([]string, error) {
+	return []string{}, nil
+}
+
+func (e *emptyBackend) Close() error {
+	return nil
+}
+
+func TestBackupCommands(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "smsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pbkey, prkey, err := smsauth.GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbFile := filepath.Join(dir, "pbkey")
+	prFile := filepath.Join(dir, "prkey")
+	smsauth.WriteToFile(pbkey, pbFile)
+	smsauth.WriteToFile(prkey, prFile)
+	archive := filepath.Join(dir, "backup.json")
+
+	backend := &emptyBackend{}
+	oldInit, oldOut := initBackend, commandOutput
+	defer func() { initBackend, commandOutput = oldInit, oldOut }()
+	initBackend = func(string) (smsbackend.SecretBackend, error) {
+		return backend, nil
+	}
+	var out bytes.Buffer
+	commandOutput = &out
+
+	err = runCommand("", []string{"backup", "-out", archive, "-pgpkey", pbFile})
+	if err != nil {
+		t.Fatal("backup: " + err.Error())
+	}
+	if !strings.Contains(out.String(), "Vault policies are not part of the backup") {
+		t.Fatal("backup: Expected output to say that policies are not backed up")
+	}
+
+	err = runCommand("", []string{"verify", "-in", archive})
+	if err != nil || !strings.Contains(out.String(), "Archive checksum OK") {
+		t.Fatal("verify: Expected archive to be valid")
+	}
+
+	err = runCommand("", []string{"verify", "-in", archive, "-pgpkey", prFile})
+	if err != nil || !strings.Contains(out.String(), "Backup OK: 0 domains") {
+		t.Fatal("verify: Expected backup to decrypt")
+	}
+
+	err = runCommand("", []string{"restore", "-in", archive, "-pgpkey", prFile, "-dry-run"})
+	if err != nil || !strings.Contains(out.String(), "Dry run") {
+		t.Fatal("restore: Expected dry run to succeed")
+	}
+
+	data, _ := ioutil.ReadFile(archive)
+	ioutil.WriteFile(archive, bytes.Replace(data, []byte(`"data": "`), []byte(`"data": "AAAA`), 1), 0600)
+	err = runCommand("", []string{"verify", "-in", archive})
+	if err == nil {
+		t.Fatal("verify: Expected checksum mismatch")
+	}
+
+	initBackend = func(string) (smsbackend.SecretBackend, error) {
+		return nil, errors.New("unreachable")
+	}
+	err = runCommand("", []string{"backup", "-out", archive, "-pgpkey", pbFile})
+	if err == nil {
+		t.Fatal("backup: Expected backend error")
+	}
+
+	err = runCommand("", []string{"unknown"})
+	if err == nil {
+		t.Fatal("runCommand: Expected error for unknown command")
+	}
+}
+
+// copyBackend is a single domain backend used as migration target
+type copyBackend struct {
+	smsbackend.SecretBackend
+	uuid    string
+	secrets map[string]smsbackend.Secret
+}
+
+func (c *copyBackend) ListSecretDomain() ([]string, error) {
+	if c.uuid == "" {
+		return []string{}, nil
+	}
+	return []string{"dom"}, nil
+}
+
+func (c *copyBackend) GetSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	if c.uuid == "" {
+		return smsbackend.SecretDomain{}, smsbackend.ErrDomainNotFound
+	}
+	return smsbackend.SecretDomain{UUID: c.uuid, Name: name}, nil
+}
+
+func (c *copyBackend) RestoreSecretDomain(dom smsbackend.SecretDomain) error {
+	c.uuid = dom.UUID
+	return nil
+}
+
+func (c *copyBackend) ListSecret(dom string) ([]string, error) {
+	names := []string{}
+	for n := range c.secrets {
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func (c *copyBackend) GetSecret(dom string, name string) (smsbackend.Secret, error) {
+	sec, ok := c.secrets[name]
+	if !ok {
+		return sec, smsbackend.ErrSecretNotFound
+	}
+	return sec, nil
+}
+
+func (c *copyBackend) CreateSecret(dom string, sec smsbackend.Secret) error {
+	c.secrets[sec.Name] = sec
+	return nil
+}
+
+func (c *copyBackend) Close() error {
+	return nil
+}
+
+func TestMigrateCommand(t *testing.T) {
+
+	src := &copyBackend{uuid: "1234", secrets: map[string]smsbackend.Secret{
+		"sec": {Name: "sec", Values: map[string]interface{}{"key": "value"}},
+	}}
+	dst := &copyBackend{secrets: map[string]smsbackend.Secret{}}
+
+	oldInit, oldTarget, oldOut := initBackend, initTargetBackend, commandOutput
+	defer func() { initBackend, initTargetBackend, commandOutput = oldInit, oldTarget, oldOut }()
+	initBackend = func(string) (smsbackend.SecretBackend, error) {
+		return src, nil
+	}
+	initTargetBackend = func(string) (smsbackend.SecretBackend, error) {
+		return dst, nil
+	}
+	var out bytes.Buffer
+	commandOutput = &out
+
+	err := runCommand("", []string{"migrate", "-to", "target.json", "-verify-only"})
+	if err == nil {
+		t.Fatal("migrate: Expected verification to fail before migrating")
+	}
+
+	out.Reset()
+	err = runCommand("", []string{"migrate", "-to", "target.json"})
+	if err != nil || !strings.Contains(out.String(), "+ sec") ||
+		!strings.Contains(out.String(), "Verification passed") {
+		t.Fatal("migrate: Expected migration to succeed")
+	}
+	if dst.uuid != "1234" || len(dst.secrets) != 1 {
+		t.Fatal("migrate: Destination does not match source")
+	}
+
+	out.Reset()
+	err = runCommand("", []string{"migrate", "-to", "target.json"})
+	if err != nil || !strings.Contains(out.String(), "0 secrets created, 0 updated, 1 unchanged") {
+		t.Fatal("migrate: Expected second run to change nothing")
+	}
+}
@@ -18,51 +18,365 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	smslogger "sms/log"
+	"strings"
+	"sync"
+	"time"
 )
 
 // SMSConfiguration loads up all the values that are used to configure
 // backend implementations
 // TODO: Review these and see if they can be created/discovered dynamically
 type SMSConfiguration struct {
-	CAFile     string `json:"cafile"`
-	ServerCert string `json:"servercert"`
-	ServerKey  string `json:"serverkey"`
-	Password   string `json:"password"`
-
-	BackendAddress            string `json:"smsdbaddress"`
-	VaultToken                string `json:"vaulttoken"`
-	DisableTLS                bool   `json:"disable_tls"`
-	BackendAddressEnvVariable string `json:"smsdburlenv"`
+	CAFile     string `json:"cafile" yaml:"cafile"`
+	ServerCert string `json:"servercert" yaml:"servercert"`
+	ServerKey  string `json:"serverkey" yaml:"serverkey"`
+	Password   string `json:"password" yaml:"password"`
+
+	// Backend selects the SecretBackend implementation and AuthDir
+	// is where it keeps the credentials it creates for itself
+	Backend                   string `json:"backend" yaml:"backend"`
+	AuthDir                   string `json:"auth_dir" yaml:"auth_dir"`
+	BackendAddress            string `json:"smsdbaddress" yaml:"smsdbaddress"`
+	VaultToken                string `json:"vaulttoken" yaml:"vaulttoken"`
+	DisableTLS                bool   `json:"disable_tls" yaml:"disable_tls"`
+	BackendAddressEnvVariable string `json:"smsdburlenv" yaml:"smsdburlenv"`
+
+	// VaultToken and Password can be loaded from a file, a named
+	// environment variable or a base64 PGP encrypted blob instead of
+	// being stored in plain text. PGP blobs are decrypted with the
+	// private key stored in PGPKeyFile
+	VaultTokenFile string `json:"vaulttoken_file" yaml:"vaulttoken_file"`
+	VaultTokenEnv  string `json:"vaulttoken_env" yaml:"vaulttoken_env"`
+	VaultTokenPGP  string `json:"vaulttoken_pgp" yaml:"vaulttoken_pgp"`
+	PasswordFile   string `json:"password_file" yaml:"password_file"`
+	PasswordEnv    string `json:"password_env" yaml:"password_env"`
+	PasswordPGP    string `json:"password_pgp" yaml:"password_pgp"`
+	PGPKeyFile     string `json:"pgp_private_key_file" yaml:"pgp_private_key_file"`
+
+	// Listener configuration for the main TLS server and the
+	// optional plaintext admin server used for health and metrics.
+	// Timeouts are specified as duration strings such as "30s"
+	ListenAddress      string `json:"listen_address" yaml:"listen_address"`
+	AdminListenAddress string `json:"admin_listen_address" yaml:"admin_listen_address"`
+	ReadTimeout        string `json:"read_timeout" yaml:"read_timeout"`
+	ReadHeaderTimeout  string `json:"read_header_timeout" yaml:"read_header_timeout"`
+	WriteTimeout       string `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout        string `json:"idle_timeout" yaml:"idle_timeout"`
+	MaxHeaderBytes     int    `json:"max_header_bytes" yaml:"max_header_bytes"`
+	MaxBodyBytes       int64  `json:"max_body_bytes" yaml:"max_body_bytes"`
+
+	// Size limits for secrets. Binary values are counted after
+	// decoding and a whole secret is counted as stored JSON
+	MaxValueBytes  int `json:"max_value_bytes" yaml:"max_value_bytes"`
+	MaxSecretBytes int `json:"max_secret_bytes" yaml:"max_secret_bytes"`
+
+	// Batch requests are limited to MaxBatchItems items which are
+	// sent to the backend by at most BatchConcurrency workers
+	MaxBatchItems    int `json:"max_batch_items" yaml:"max_batch_items"`
+	BatchConcurrency int `json:"batch_concurrency" yaml:"batch_concurrency"`
+
+	// ShutdownTimeout is how long in-flight requests are given
+	// to finish once a SIGTERM or SIGINT is received
+	ShutdownTimeout string `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+
+	// Client certificate verification. When RequireClientCert is
+	// set, connections without a certificate signed by CAFile are
+	// rejected. Certificates listed in CRLFile or reported as
+	// revoked by their OCSP responder are always rejected
+	RequireClientCert bool   `json:"require_client_cert" yaml:"require_client_cert"`
+	CRLFile           string `json:"crl_file" yaml:"crl_file"`
+	OCSPCheck         bool   `json:"ocsp_check" yaml:"ocsp_check"`
+
+	// LogLevel is one of error, warn or info
+	LogLevel string `json:"log_level" yaml:"log_level"`
+	// CertReloadInterval enables polling of the certificate files
+	// for changes. Certificates are always reloaded on SIGHUP
+	CertReloadInterval string `json:"cert_reload_interval" yaml:"cert_reload_interval"`
 }
 
+// Default listener values that are used when the configuration
+// file does not specify them
+const (
+	defaultListenAddress     = ":10443"
+	defaultReadTimeout       = "30s"
+	defaultReadHeaderTimeout = "10s"
+	defaultWriteTimeout      = "30s"
+	defaultIdleTimeout       = "120s"
+	defaultShutdownTimeout   = "30s"
+	defaultMaxHeaderBytes    = 1 << 16
+	defaultMaxBodyBytes      = 1 << 20
+	defaultLogLevel          = "info"
+	defaultMaxValueBytes     = 1 << 16
+	defaultMaxSecretBytes    = 1 << 19
+	defaultMaxBatchItems     = 100
+	defaultBatchConcurrency  = 8
+	defaultBackend           = "vault"
+	defaultAuthDir           = "auth"
+)
+
 // SMSConfig is the structure that stores the configuration
 var SMSConfig *SMSConfiguration
 
+// configLock guards SMSConfig. ReloadConfigFile replaces it while
+// requests are served, so code that runs during requests reads it
+// with Current
+var configLock sync.RWMutex
+
+// configFile is the file SMSConfig was read from
+var configFile string
+
+// Current returns the configuration in use. A reload replaces the
+// configuration instead of changing it, so the returned value does
+// not change while it is used
+func Current() *SMSConfiguration {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return SMSConfig
+}
+
 // ReadConfigFile reads the specified smsConfig file to setup some env variables
 func ReadConfigFile(file string) (*SMSConfiguration, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	if SMSConfig == nil {
-		f, err := os.Open(file)
+		conf, err := decodeConfigFile(file)
 		if err != nil {
 			return nil, err
 		}
-		defer f.Close()
+		SMSConfig = conf
+		SMSConfig.resolveBackendAddress()
+		configFile = file
+		smslogger.SetLevel(conf.LogLevel)
+	}
+
+	return SMSConfig, nil
+}
+
+// LoadConfigFile reads a configuration file without applying
+// environment or command line overrides and without changing
+// SMSConfig. It is used for additional backends such as the
+// target of a migration
+func LoadConfigFile(file string) (*SMSConfiguration, error) {
+	conf, err := parseConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.resolveBackendAddress()
+	return conf, nil
+}
+
+// resolveBackendAddress reads the backend address from the
+// configured environment variable when it is not set directly
+func (c *SMSConfiguration) resolveBackendAddress() {
+	if c.BackendAddress == "" && c.BackendAddressEnvVariable != "" {
+		// Get the value from ENV variable
+		smslogger.WriteInfo("Using Environment Variable: " + c.BackendAddressEnvVariable)
+		c.BackendAddress = os.Getenv(c.BackendAddressEnvVariable)
+	}
+}
+
+// ReloadConfigFile reads the configuration file again and returns a
+// copy of the current configuration with only the settings that are
+// safe to change while SMS is running: log level, CA bundle and server
+// certificate paths, the client certificate policy and the secret
+// size and batch limits. Listener, backend and token settings need a
+// restart to take effect. The returned configuration is not used until
+// it is passed to SetCurrent, so the caller can first apply the new
+// certificates and keep the current configuration if that fails
+func ReloadConfigFile(file string) (*SMSConfiguration, error) {
+	if Current() == nil {
+		return ReadConfigFile(file)
+	}
+
+	conf, err := decodeConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests may still use the current configuration, so the
+	// changes are made to a copy that later replaces it
+	next := *Current()
+	next.LogLevel = conf.LogLevel
+	next.CAFile = conf.CAFile
+	next.ServerCert = conf.ServerCert
+	next.ServerKey = conf.ServerKey
+	next.RequireClientCert = conf.RequireClientCert
+	next.CRLFile = conf.CRLFile
+	next.OCSPCheck = conf.OCSPCheck
+	next.MaxValueBytes = conf.MaxValueBytes
+	next.MaxSecretBytes = conf.MaxSecretBytes
+	next.MaxBatchItems = conf.MaxBatchItems
+	next.BatchConcurrency = conf.BatchConcurrency
+
+	return &next, nil
+}
+
+// SetCurrent makes conf the configuration in use and applies its
+// log level
+func SetCurrent(conf *SMSConfiguration) {
+	configLock.Lock()
+	SMSConfig = conf
+	configLock.Unlock()
+
+	smslogger.SetLevel(conf.LogLevel)
+}
+
+// ReadConfigPassword reads the plain text PEM password from the
+// configuration file again. The password is cleared from SMSConfig
+// once the certificates are loaded, so it is not kept in memory
+func ReadConfigPassword() (string, error) {
+	configLock.RLock()
+	file := configFile
+	configLock.RUnlock()
+
+	if file == "" {
+		return "", errors.New("No configuration file to read the password from")
+	}
+
+	conf, err := decodeConfigFile(file)
+	if err != nil {
+		return "", err
+	}
+	return conf.Password, nil
+}
 
-		// Default behaviour is to enable TLS
-		SMSConfig = &SMSConfiguration{DisableTLS: false}
-		decoder := json.NewDecoder(f)
-		err = decoder.Decode(SMSConfig)
+// decodeConfigFile reads the JSON or YAML file, fills in defaults
+// for any values that are missing, applies environment and command
+// line overrides and validates the result
+func decodeConfigFile(file string) (*SMSConfiguration, error) {
+	conf, err := parseConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conf.applyOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	err = conf.checkListenerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	err = smslogger.CheckLevel(conf.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// parseConfigFile reads the JSON or YAML file and fills in
+// defaults for any values that are missing
+func parseConfigFile(file string) (*SMSConfiguration, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default behaviour is to enable TLS
+	conf := &SMSConfiguration{
+		DisableTLS:        false,
+		ListenAddress:     defaultListenAddress,
+		ReadTimeout:       defaultReadTimeout,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		MaxHeaderBytes:    defaultMaxHeaderBytes,
+		MaxBodyBytes:      defaultMaxBodyBytes,
+		LogLevel:          defaultLogLevel,
+		MaxValueBytes:     defaultMaxValueBytes,
+		MaxSecretBytes:    defaultMaxSecretBytes,
+		MaxBatchItems:     defaultMaxBatchItems,
+		BatchConcurrency:  defaultBatchConcurrency,
+		Backend:           defaultBackend,
+		AuthDir:           defaultAuthDir,
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, conf)
+	default:
+		err = json.Unmarshal(data, conf)
+	}
+	if err != nil {
+		return nil, errors.New("Parsing " + file + ": " + err.Error())
+	}
+
+	return conf, nil
+}
+
+// checkListenerConfig validates the listener related values
+// so that a bad configuration fails at startup
+func (c *SMSConfiguration) checkListenerConfig() error {
+
+	if c.ListenAddress == "" {
+		return errors.New("listen_address cannot be empty")
+	}
+
+	if c.AdminListenAddress != "" && c.AdminListenAddress == c.ListenAddress {
+		return errors.New("admin_listen_address cannot be the same as listen_address")
+	}
+
+	durations := map[string]string{
+		"read_timeout":        c.ReadTimeout,
+		"read_header_timeout": c.ReadHeaderTimeout,
+		"write_timeout":       c.WriteTimeout,
+		"idle_timeout":        c.IdleTimeout,
+		"shutdown_timeout":    c.ShutdownTimeout,
+	}
+	for name, val := range durations {
+		d, err := time.ParseDuration(val)
 		if err != nil {
-			return nil, err
+			return errors.New("Invalid duration for " + name + ": " + err.Error())
 		}
+		if d <= 0 {
+			return errors.New(name + " must be greater than zero")
+		}
+	}
 
-		if SMSConfig.BackendAddress == "" && SMSConfig.BackendAddressEnvVariable != "" {
-			// Get the value from ENV variable
-			smslogger.WriteInfo("Using Environment Variable: " + SMSConfig.BackendAddressEnvVariable)
-			SMSConfig.BackendAddress = os.Getenv(SMSConfig.BackendAddressEnvVariable)
+	if c.CertReloadInterval != "" {
+		d, err := time.ParseDuration(c.CertReloadInterval)
+		if err != nil {
+			return errors.New("Invalid duration for cert_reload_interval: " + err.Error())
+		}
+		if d < time.Second {
+			return errors.New("cert_reload_interval must be at least 1s")
 		}
 	}
 
-	return SMSConfig, nil
+	if c.MaxHeaderBytes <= 0 {
+		return errors.New("max_header_bytes must be greater than zero")
+	}
+
+	if c.MaxBodyBytes <= 0 {
+		return errors.New("max_body_bytes must be greater than zero")
+	}
+
+	if c.MaxValueBytes <= 0 || c.MaxSecretBytes <= 0 {
+		return errors.New("max_value_bytes and max_secret_bytes must be greater than zero")
+	}
+
+	if c.MaxBatchItems <= 0 || c.BatchConcurrency <= 0 {
+		return errors.New("max_batch_items and batch_concurrency must be greater than zero")
+	}
+
+	return nil
+}
+
+// GetDuration parses a duration string that has already been
+// validated by ReadConfigFile
+func GetDuration(val string) time.Duration {
+	d, _ := time.ParseDuration(val)
+	return d
 }
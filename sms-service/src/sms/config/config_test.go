@@ -17,7 +17,10 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"runtime"
+	smslogger "sms/log"
 	"testing"
 )
 
@@ -37,4 +40,146 @@ func TestReadConfigurationFile(t *testing.T) {
 	if conf.CAFile != "testca.pem" {
 		t.Fatal("ReadConfigurationFile: Incorrect entry read from file")
 	}
+	if conf.ListenAddress != defaultListenAddress {
+		t.Fatal("ReadConfigurationFile: Default listen address not applied")
+	}
+	if GetDuration(conf.ReadHeaderTimeout).Seconds() != 10 {
+		t.Fatal("ReadConfigurationFile: Default read header timeout not applied")
+	}
+}
+
+func TestReadConfigFileInvalidListener(t *testing.T) {
+	SMSConfig = nil
+	defer func() { SMSConfig = nil }()
+
+	f, err := ioutil.TempFile("", "smsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"cafile": "testca.pem", "read_timeout": "forever"}`)
+	f.Close()
+
+	_, err = ReadConfigFile(f.Name())
+	if err == nil {
+		t.Fatal("ReadConfigFile: Expected error for invalid timeout, none found")
+	}
+	if SMSConfig != nil {
+		t.Fatal("ReadConfigFile: Invalid configuration should not be stored")
+	}
+}
+
+func TestReadConfigPassword(t *testing.T) {
+	SMSConfig, configFile = nil, ""
+	defer func() { SMSConfig, configFile = nil, "" }()
+
+	_, err := ReadConfigPassword()
+	if err == nil {
+		t.Fatal("ReadConfigPassword: Expected error without a configuration file")
+	}
+
+	f, err := ioutil.TempFile("", "smsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"smsdbaddress": "http://localhost:8200", "password": "c2VjcmV0"}`)
+	f.Close()
+
+	conf, err := ReadConfigFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Password = ""
+
+	password, err := ReadConfigPassword()
+	if err != nil || password != "c2VjcmV0" {
+		t.Fatal("ReadConfigPassword: Password was not read from the configuration file")
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	SMSConfig = nil
+	defer func() { SMSConfig = nil }()
+
+	f, err := ioutil.TempFile("", "smsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"cafile": "testca.pem", "smsdbaddress": "http://localhost:8200"}`)
+	f.Close()
+
+	_, err = ReadConfigFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"cafile": "newca.pem", "smsdbaddress": "http://otherhost:8200",
+		"log_level": "warn", "require_client_cert": true, "max_batch_items": 10}`), 0600)
+
+	// Requests read the configuration while it is reloaded
+	prev := Current()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = Current().CAFile
+		}
+	}()
+
+	conf, err := ReloadConfigFile(f.Name())
+	<-done
+	if err != nil {
+		t.Fatal("ReloadConfigFile: Returned error: " + err.Error())
+	}
+	if conf.CAFile != "newca.pem" || conf.LogLevel != "warn" || !conf.RequireClientCert ||
+		conf.MaxBatchItems != 10 {
+		t.Fatal("ReloadConfigFile: Reloadable values were not applied")
+	}
+	if prev.CAFile != "testca.pem" || Current() != prev {
+		t.Fatal("ReloadConfigFile: Configuration was replaced before SetCurrent")
+	}
+	SetCurrent(conf)
+	if Current() != conf {
+		t.Fatal("SetCurrent: Configuration was not replaced")
+	}
+	if conf.BackendAddress != "http://localhost:8200" {
+		t.Fatal("ReloadConfigFile: Backend address should not change on reload")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"log_level": "verbose"}`), 0600)
+	_, err = ReloadConfigFile(f.Name())
+	if err == nil {
+		t.Fatal("ReloadConfigFile: Expected error for invalid log level, none found")
+	}
+	if SMSConfig.LogLevel != "warn" {
+		t.Fatal("ReloadConfigFile: Invalid reload modified the configuration")
+	}
+	smslogger.SetLevel("info")
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	SMSConfig = nil
+	defer func() { SMSConfig = nil }()
+
+	os.Setenv("SMS_CAFILE", "override.pem")
+	defer os.Unsetenv("SMS_CAFILE")
+
+	conf, err := LoadConfigFile("../test/smsconfig_test.json")
+	if err != nil {
+		t.Fatal("LoadConfigFile: Error reading file")
+	}
+	if conf.CAFile != "testca.pem" {
+		t.Fatal("LoadConfigFile: Environment overrides should not be applied")
+	}
+	if conf.Backend != defaultBackend || conf.AuthDir != defaultAuthDir {
+		t.Fatal("LoadConfigFile: Default backend settings not applied")
+	}
+	if SMSConfig != nil {
+		t.Fatal("LoadConfigFile: Global configuration should not be set")
+	}
 }
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is prepended to the upper cased json name of a
+// configuration field to get its environment variable.
+// For example listen_address is overridden by SMS_LISTEN_ADDRESS
+const EnvPrefix = "SMS_"
+
+// redactedFields are never printed by Redacted and have no command
+// line flag, so they do not show up in process listings
+var redactedFields = map[string]bool{
+	"password":   true,
+	"vaulttoken": true,
+}
+
+// flagOverrides stores values set on the command line through
+// the flags registered by RegisterFlags
+var flagOverrides = map[string]string{}
+
+// overrideFlag is a flag.Value that records the value set for
+// a configuration field
+type overrideFlag struct {
+	name   string
+	isBool bool
+}
+
+func (f *overrideFlag) String() string {
+	return ""
+}
+
+func (f *overrideFlag) Set(val string) error {
+	flagOverrides[f.name] = val
+	return nil
+}
+
+func (f *overrideFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// configFields returns the settable struct fields of the
+// configuration indexed by their json name
+func (c *SMSConfiguration) configFields() map[string]reflect.Value {
+
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+// RegisterFlags adds a command line flag for every configuration
+// field but the redacted ones to fs. Flags use the json name with
+// dashes, such as --listen-address, and take precedence over
+// environment variables
+func RegisterFlags(fs *flag.FlagSet) {
+
+	for name, v := range (&SMSConfiguration{}).configFields() {
+		if redactedFields[name] {
+			continue
+		}
+		fs.Var(&overrideFlag{name: name, isBool: v.Kind() == reflect.Bool},
+			strings.Replace(name, "_", "-", -1),
+			"Overrides "+name+" from the configuration file")
+	}
+}
+
+// applyOverrides sets configuration values from SMS_* environment
+// variables and then from command line flags
+func (c *SMSConfiguration) applyOverrides() error {
+
+	fields := c.configFields()
+
+	for name, v := range fields {
+		val, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(name))
+		if !ok {
+			continue
+		}
+		err := setField(v, val)
+		if err != nil {
+			return errors.New("Invalid value in " + EnvPrefix + strings.ToUpper(name) + ": " + err.Error())
+		}
+	}
+
+	for name, val := range flagOverrides {
+		err := setField(fields[name], val)
+		if err != nil {
+			return errors.New("Invalid value for --" + strings.Replace(name, "_", "-", -1) + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func setField(v reflect.Value, val string) error {
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		return errors.New("Unsupported field type " + v.Kind().String())
+	}
+
+	return nil
+}
+
+// Validate checks that the files referenced by the configuration
+// exist and that the backend address is a valid URL.
+// All problems are reported together in the returned error
+func (c *SMSConfiguration) Validate() error {
+
+	var problems []string
+
+	if !c.DisableTLS {
+		files := []struct{ name, path string }{
+			{"cafile", c.CAFile},
+			{"servercert", c.ServerCert},
+			{"serverkey", c.ServerKey},
+			{"crl_file", c.CRLFile},
+		}
+		for _, f := range files {
+			if f.path == "" {
+				if f.name != "crl_file" {
+					problems = append(problems, f.name+" must be set when TLS is enabled")
+				}
+				continue
+			}
+			_, err := os.Stat(f.path)
+			if err != nil {
+				problems = append(problems, f.name+": "+err.Error())
+			}
+		}
+	}
+
+	if c.Backend != "" && c.Backend != defaultBackend {
+		problems = append(problems, "backend: unsupported secret backend "+c.Backend)
+	}
+
+	if c.BackendAddress == "" {
+		problems = append(problems, "smsdbaddress must be set")
+	} else {
+		u, err := url.Parse(c.BackendAddress)
+		if err != nil {
+			problems = append(problems, "smsdbaddress: "+err.Error())
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "smsdbaddress: "+c.BackendAddress+
+				" is not an http or https URL")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Redacted returns the configuration as indented JSON with
+// passwords and tokens replaced
+func (c *SMSConfiguration) Redacted() (string, error) {
+
+	conf := *c
+	for name, v := range conf.configFields() {
+		if redactedFields[name] && v.String() != "" {
+			v.SetString("<redacted>")
+		}
+	}
+
+	out, err := json.MarshalIndent(conf, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
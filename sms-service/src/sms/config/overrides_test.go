@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 Intel Corporation, Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadYAMLConfigFile(t *testing.T) {
+	SMSConfig = nil
+	defer func() { SMSConfig = nil }()
+
+	conf, err := ReadConfigFile("../test/smsconfig_test.yaml")
+	if err != nil {
+		t.Fatal("ReadConfigFile: Error reading YAML file: " + err.Error())
+	}
+	if conf.CAFile != "testca.pem" || conf.ListenAddress != ":10444" {
+		t.Fatal("ReadConfigFile: Incorrect entry read from YAML file")
+	}
+	if conf.ReadTimeout != defaultReadTimeout {
+		t.Fatal("ReadConfigFile: Defaults not applied to YAML file")
+	}
+}
+
+func TestEnvAndFlagOverrides(t *testing.T) {
+	SMSConfig = nil
+	defer func() {
+		SMSConfig = nil
+		flagOverrides = map[string]string{}
+		os.Unsetenv("SMS_LISTEN_ADDRESS")
+		os.Unsetenv("SMS_CAFILE")
+		os.Unsetenv("SMS_MAX_BODY_BYTES")
+	}()
+
+	os.Setenv("SMS_LISTEN_ADDRESS", ":9443")
+	os.Setenv("SMS_CAFILE", "envca.pem")
+	os.Setenv("SMS_MAX_BODY_BYTES", "2048")
+
+	fs := flag.NewFlagSet("sms", flag.ContinueOnError)
+	RegisterFlags(fs)
+	err := fs.Parse([]string{"--cafile", "flagca.pem", "--disable-tls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"vaulttoken", "password"} {
+		if fs.Lookup(secret) != nil {
+			t.Fatal("RegisterFlags: Secret field must not have a flag: " + secret)
+		}
+	}
+
+	conf, err := ReadConfigFile("../test/smsconfig_test.json")
+	if err != nil {
+		t.Fatal("ReadConfigFile: Returned error: " + err.Error())
+	}
+	if conf.ListenAddress != ":9443" || conf.MaxBodyBytes != 2048 {
+		t.Fatal("ReadConfigFile: Environment overrides not applied")
+	}
+	if conf.CAFile != "flagca.pem" || conf.DisableTLS != true {
+		t.Fatal("ReadConfigFile: Flag overrides not applied or lower precedence than env")
+	}
+}
+
+func TestInvalidEnvOverride(t *testing.T) {
+	SMSConfig = nil
+	defer func() {
+		SMSConfig = nil
+		os.Unsetenv("SMS_DISABLE_TLS")
+	}()
+
+	os.Setenv("SMS_DISABLE_TLS", "maybe")
+	_, err := ReadConfigFile("../test/smsconfig_test.json")
+	if err == nil || !strings.Contains(err.Error(), "SMS_DISABLE_TLS") {
+		t.Fatal("ReadConfigFile: Expected error naming SMS_DISABLE_TLS")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	conf := &SMSConfiguration{
+		CAFile:         "../test/auth_test_certificate.pem",
+		ServerCert:     "../test/auth_test_certificate.pem",
+		ServerKey:      "../test/auth_test_key.pem",
+		BackendAddress: "http://localhost:8200",
+	}
+	err := conf.Validate()
+	if err != nil {
+		t.Fatal("Validate: Returned error for valid config: " + err.Error())
+	}
+
+	conf.ServerKey = "filedoesnotexist.key"
+	conf.BackendAddress = "localhost:8200"
+	err = conf.Validate()
+	if err == nil {
+		t.Fatal("Validate: Expected error, none found")
+	}
+	if !strings.Contains(err.Error(), "serverkey") || !strings.Contains(err.Error(), "smsdbaddress") {
+		t.Fatal("Validate: Error does not describe all problems: " + err.Error())
+	}
+
+	conf.Backend = "etcd"
+	err = conf.Validate()
+	if err == nil || !strings.Contains(err.Error(), "backend") {
+		t.Fatal("Validate: Expected error for unsupported backend")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	conf := &SMSConfiguration{
+		CAFile:     "testca.pem",
+		Password:   "c2VjcmV0",
+		VaultToken: "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+	}
+
+	out, err := conf.Redacted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, conf.Password) || strings.Contains(out, conf.VaultToken) {
+		t.Fatal("Redacted: Secrets present in output")
+	}
+	if !strings.Contains(out, "testca.pem") {
+		t.Fatal("Redacted: Non secret values missing from output")
+	}
+	if conf.VaultToken == "<redacted>" {
+		t.Fatal("Redacted: Original configuration was modified")
+	}
+}
@@ -18,11 +18,16 @@ package handler
 
 import (
 	"encoding/json"
+	"expvar"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"strings"
 
 	uuid "github.com/hashicorp/go-uuid"
+	smsauth "sms/auth"
 	smsbackend "sms/backend"
+	smsconfig "sms/config"
 	smslogger "sms/log"
 )
 
@@ -33,7 +38,17 @@ type handler struct {
 	loginBackend  smsbackend.LoginBackend
 }
 
-// createSecretDomainHandler creates a secret domain with a name provided
+// clientName returns the common name of the client certificate. It
+// is recorded as the last modifier of secrets and domains
+func clientName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// createSecretDomainHandler creates a secret domain with a name and
+// optional metadata provided
 func (h handler) createSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
 	var d smsbackend.SecretDomain
 
@@ -43,9 +58,9 @@ func (h handler) createSecretDomainHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	dom, err := h.secretBackend.CreateSecretDomain(d.Name)
+	dom, err := smsbackend.CreateDomain(h.secretBackend, d, clientName(r))
 	if smslogger.CheckError(err, "CreateSecretDomainHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSecretError(w, err)
 		return
 	}
 
@@ -58,6 +73,94 @@ func (h handler) createSecretDomainHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// listSecretDomainHandler lists the names of all secret domains.
+// label parameters select domains by their labels and metadata=true
+// adds the UUID and metadata of each domain
+func (h handler) listSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	labels := query["label"]
+	withMeta := query.Get("metadata") == "true"
+
+	var domList []string
+	var doms []smsbackend.SecretDomain
+	var err error
+	if len(labels) > 0 || withMeta {
+		doms, err = smsbackend.ListDomains(h.secretBackend, labels)
+		domList = make([]string, len(doms))
+		for i, d := range doms {
+			domList[i] = d.Name
+		}
+	} else {
+		domList, err = h.secretBackend.ListSecretDomain()
+	}
+	if smslogger.CheckError(err, "ListSecretDomainHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	var retStruct = struct {
+		DomainNames []string                  `json:"domainnames"`
+		Domains     []smsbackend.SecretDomain `json:"domains,omitempty"`
+	}{
+		DomainNames: domList,
+	}
+	if withMeta {
+		retStruct.Domains = doms
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, "ListSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// getSecretDomainHandler returns the UUID and metadata of a domain
+func (h handler) getSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+
+	dom, err := h.secretBackend.GetSecretDomain(domName)
+	if smslogger.CheckError(err, "GetSecretDomainHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(dom)
+	if smslogger.CheckError(err, "GetSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// patchSecretDomainHandler replaces the metadata of a domain
+func (h handler) patchSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+
+	var d smsbackend.SecretDomain
+	err := json.NewDecoder(r.Body).Decode(&d)
+	if smslogger.CheckError(err, "PatchSecretDomainHandler") != nil || d.Metadata == nil {
+		http.Error(w, "Bad input JSON", http.StatusBadRequest)
+		return
+	}
+
+	dom, err := smsbackend.UpdateDomainMetadata(h.secretBackend, domName, d.Metadata, clientName(r))
+	if smslogger.CheckError(err, "PatchSecretDomainHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(dom)
+	if smslogger.CheckError(err, "PatchSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // deleteSecretDomainHandler deletes a secret domain with the name provided
 func (h handler) deleteSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -65,13 +168,127 @@ func (h handler) deleteSecretDomainHandler(w http.ResponseWriter, r *http.Reques
 
 	err := h.secretBackend.DeleteSecretDomain(domName)
 	if smslogger.CheckError(err, "DeleteSecretDomainHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSecretError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// exportSecretDomainHandler returns all secrets of a domain as a bundle
+// encrypted to the base64 PGP public key given in the pgpkey parameter
+func (h handler) exportSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+
+	pgpKey := strings.TrimSpace(r.URL.Query().Get("pgpkey"))
+	if pgpKey == "" {
+		http.Error(w, "Missing pgpkey parameter", http.StatusBadRequest)
+		return
+	}
+
+	bundle, err := smsbackend.ExportDomain(h.secretBackend, domName)
+	if smslogger.CheckError(err, "ExportSecretDomainHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	data, err := json.Marshal(bundle)
+	if smslogger.CheckError(err, "ExportSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	enc, err := smsauth.EncryptPGPString(string(data), pgpKey)
+	if smslogger.CheckError(err, "ExportSecretDomainHandler") != nil {
+		http.Error(w, "Unable to encrypt with pgpkey: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var retStruct = struct {
+		Domain string `json:"domain"`
+		Bundle string `json:"bundle"`
+	}{
+		bundle.Domain,
+		enc,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, "ExportSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// importSecretDomainHandler stores the secrets of an exported bundle in
+// a domain. The bundle is decrypted with the PGP private key configured
+// in pgp_private_key_file
+func (h handler) importSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+
+	var in struct {
+		Bundle string `json:"bundle"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil || in.Bundle == "" {
+		http.Error(w, "Bad input JSON", http.StatusBadRequest)
+		return
+	}
+
+	keyFile := smsconfig.Current().PGPKeyFile
+	if keyFile == "" {
+		http.Error(w, "No PGP private key configured for import", http.StatusNotImplemented)
+		return
+	}
+	prKey, err := smsauth.ReadFromFile(keyFile)
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil {
+		http.Error(w, "Unable to read PGP private key", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := smsauth.DecryptPGPString(strings.TrimSpace(in.Bundle), strings.TrimSpace(prKey))
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil {
+		http.Error(w, "Unable to decrypt bundle", http.StatusBadRequest)
+		return
+	}
+
+	var bundle smsbackend.DomainBundle
+	err = json.Unmarshal([]byte(data), &bundle)
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil {
+		http.Error(w, "Invalid bundle", http.StatusBadRequest)
+		return
+	}
+
+	count, err := smsbackend.ImportDomain(h.secretBackend, domName, bundle, overwrite, clientName(r))
+	if err == smsbackend.ErrSecretExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	var retStruct = struct {
+		Domain   string `json:"domain"`
+		Imported int    `json:"imported"`
+	}{
+		strings.TrimSpace(domName),
+		count,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, "ImportSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // createSecretHandler handles creation of secrets on a given domain name
 func (h handler) createSecretHandler(w http.ResponseWriter, r *http.Request) {
 	// Get domain name from URL
@@ -86,15 +303,108 @@ func (h handler) createSecretHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.secretBackend.CreateSecret(domName, b)
-	if smslogger.CheckError(err, "CreateSecretHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	sec, err := smsbackend.ReplaceSecret(h.secretBackend, domName, b, ifMatch(r), clientName(r))
+	if err != nil {
+		writeSecretError(w, err)
 		return
 	}
 
+	// The version of the stored secret, which is what a later If-Match
+	// is compared against
+	w.Header().Set("ETag", `"`+smsbackend.SecretVersion(sec)+`"`)
 	w.WriteHeader(http.StatusCreated)
 }
 
+// ifMatch returns the version from the If-Match header without quotes
+func ifMatch(r *http.Request) string {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	v = strings.TrimPrefix(v, "W/")
+	return strings.Trim(v, `"`)
+}
+
+// secretErrorStatus maps errors from validated, conditional and
+// batch secret operations to the matching status code
+func secretErrorStatus(err error) int {
+	verr, invalid := err.(*smsbackend.ValidationError)
+	switch {
+	case invalid && verr.TooLarge:
+		return http.StatusRequestEntityTooLarge
+	case invalid:
+		return http.StatusBadRequest
+	case err == smsbackend.ErrVersionMismatch:
+		return http.StatusPreconditionFailed
+	case err == smsbackend.ErrBatchAborted:
+		return http.StatusFailedDependency
+	case smsbackend.IsNotFound(err):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeSecretError writes err with the status from secretErrorStatus
+func writeSecretError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), secretErrorStatus(err))
+}
+
+// checkNames rejects requests whose domain or secret name in the
+// URL cannot name an existing domain or secret before they reach the
+// backend. The naming rules for new names are checked on create
+func checkNames(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		err := smsbackend.CheckDomainName(vars["domName"])
+		if err == nil {
+			if secName, ok := vars["secretName"]; ok {
+				if r.Method == "DELETE" && r.URL.Query().Get("recursive") == "true" {
+					err = smsbackend.CheckSecretFolder(secName)
+				} else {
+					err = smsbackend.CheckSecretName(secName)
+				}
+			}
+		}
+		if smslogger.CheckError(err, "CheckNames") != nil {
+			writeSecretError(w, err)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// patchSecretHandler merges keys into an existing secret or removes
+// them when they are set to null. Metadata, when given, replaces the
+// metadata of the secret. If-Match makes the update conditional on
+// the version returned in the ETag header. The check is only atomic
+// within one SMS instance, see secretLocks in the backend
+func (h handler) patchSecretHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+	secName := vars["secretName"]
+
+	var patch smsbackend.Secret
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if smslogger.CheckError(err, "PatchSecretHandler") != nil || (patch.Values == nil && patch.Types == nil && patch.Metadata == nil) {
+		http.Error(w, "Bad input JSON", http.StatusBadRequest)
+		return
+	}
+
+	sec, err := smsbackend.PatchSecret(h.secretBackend, domName, secName, patch, ifMatch(r), clientName(r))
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+smsbackend.SecretVersion(sec)+`"`)
+	err = json.NewEncoder(w).Encode(sec)
+	if smslogger.CheckError(err, "PatchSecretHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // getSecretHandler handles reading a secret by given domain name and secret name
 func (h handler) getSecretHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -103,11 +413,39 @@ func (h handler) getSecretHandler(w http.ResponseWriter, r *http.Request) {
 
 	sec, err := h.secretBackend.GetSecret(domName, secName)
 	if smslogger.CheckError(err, "GetSecretHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSecretError(w, err)
 		return
 	}
+	version := smsbackend.SecretVersion(sec)
+
+	// Return only the requested keys when filtered with ?keys=a,b
+	if keys := r.URL.Query().Get("keys"); keys != "" {
+		filtered := smsbackend.Secret{Name: sec.Name, Values: map[string]interface{}{}}
+		var missing []string
+		for _, k := range strings.Split(keys, ",") {
+			k = strings.TrimSpace(k)
+			v, ok := sec.Values[k]
+			if !ok {
+				missing = append(missing, k)
+				continue
+			}
+			filtered.Values[k] = v
+			if t, ok := sec.Types[k]; ok {
+				if filtered.Types == nil {
+					filtered.Types = map[string]string{}
+				}
+				filtered.Types[k] = t
+			}
+		}
+		if len(missing) > 0 {
+			http.Error(w, "Keys not found in secret: "+strings.Join(missing, ","), http.StatusNotFound)
+			return
+		}
+		sec = filtered
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+version+`"`)
 	err = json.NewEncoder(w).Encode(sec)
 	if smslogger.CheckError(err, "GetSecretHandler") != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -115,22 +453,127 @@ func (h handler) getSecretHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// listSecretHandler handles listing all secrets under a particular domain name
+// getSecretKeyHandler returns a single value of a secret. It is
+// returned as JSON unless the client accepts text/plain or
+// application/octet-stream, in which case the raw value is sent
+func (h handler) getSecretKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+	secName := vars["secretName"]
+	key := vars["key"]
+
+	sec, err := h.secretBackend.GetSecret(domName, secName)
+	if smslogger.CheckError(err, "GetSecretKeyHandler") != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	val, ok := sec.Values[key]
+	if !ok {
+		http.Error(w, "Key not found in secret: "+key, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", `"`+smsbackend.SecretVersion(sec)+`"`)
+
+	accept := r.Header.Get("Accept")
+	raw := ""
+	switch {
+	case strings.Contains(accept, "text/plain"):
+		raw = "text/plain; charset=utf-8"
+	case strings.Contains(accept, "application/octet-stream"):
+		raw = "application/octet-stream"
+	}
+
+	if raw != "" {
+		// Binary values are decoded, strings are sent as they are
+		// and other values as JSON
+		var data []byte
+		str, isString := val.(string)
+		switch {
+		case sec.ValueType(key) == smsbackend.ValueBinary:
+			data, err = sec.Binary(key)
+		case isString:
+			data = []byte(str)
+		default:
+			data, err = json.Marshal(val)
+		}
+		if smslogger.CheckError(err, "GetSecretKeyHandler") != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", raw)
+		w.Write(data)
+		return
+	}
+
+	var retStruct = struct {
+		Key   string      `json:"key"`
+		Type  string      `json:"type"`
+		Value interface{} `json:"value"`
+	}{
+		key,
+		sec.ValueType(key),
+		val,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, "GetSecretKeyHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// listSecretHandler handles listing secrets under a particular domain name.
+// The path and recursive parameters select a folder, the prefix,
+// match, label, sort, limit and cursor parameters select one page of
+// secrets and metadata=true adds the version and metadata of each secret
 func (h handler) listSecretHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	domName := vars["domName"]
+	query := r.URL.Query()
+
+	opts := smsbackend.ListOptions{
+		Path:      query.Get("path"),
+		Recursive: query.Get("recursive") == "true",
+		Prefix:    query.Get("prefix"),
+		Match:     query.Get("match"),
+		Labels:    query["label"],
+		Sort:      query.Get("sort"),
+		Cursor:    query.Get("cursor"),
+		Metadata:  query.Get("metadata") == "true",
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit: "+l, http.StatusBadRequest)
+			return
+		}
+		opts.Limit = limit
+	}
 
-	secList, err := h.secretBackend.ListSecret(domName)
+	list, err := smsbackend.ListSecrets(h.secretBackend, domName, opts)
 	if smslogger.CheckError(err, "ListSecretHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSecretError(w, err)
 		return
 	}
 
+	secList := make([]string, len(list.Secrets))
+	for i, s := range list.Secrets {
+		secList[i] = s.Name
+	}
+
 	// Creating an anonymous struct to store the returned list of data
 	var retStruct = struct {
-		SecretNames []string `json:"secretnames"`
+		SecretNames []string                `json:"secretnames"`
+		Secrets     []smsbackend.SecretInfo `json:"secrets,omitempty"`
+		Next        string                  `json:"next,omitempty"`
 	}{
-		secList,
+		SecretNames: secList,
+		Next:        list.Next,
+	}
+	if opts.Metadata {
+		retStruct.Secrets = list.Secrets
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -147,15 +590,187 @@ func (h handler) deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
 	domName := vars["domName"]
 	secName := vars["secretName"]
 
-	err := h.secretBackend.DeleteSecret(domName, secName)
+	if r.URL.Query().Get("recursive") == "true" {
+		h.deleteSecretFolder(w, r, domName, secName)
+		return
+	}
+
+	err := smsbackend.DeleteSecretVersion(h.secretBackend, domName, secName, ifMatch(r))
 	if smslogger.CheckError(err, "DeleteSecretHandler") != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSecretError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteSecretFolder deletes all secrets below a folder and returns
+// their names
+func (h handler) deleteSecretFolder(w http.ResponseWriter, r *http.Request, domName string, folder string) {
+
+	if ifMatch(r) != "" {
+		http.Error(w, "If-Match cannot be used with recursive", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := smsbackend.DeleteSecretFolder(h.secretBackend, domName, folder)
+	if smslogger.CheckError(err, "DeleteSecretHandler") != nil && len(deleted) == 0 {
+		writeSecretError(w, err)
+		return
+	}
+
+	var retStruct = struct {
+		Deleted []string `json:"deleted"`
+		Error   string   `json:"error,omitempty"`
+	}{
+		Deleted: deleted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		retStruct.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	err = json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, "DeleteSecretHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// batchRequest is the body of the batch endpoints. Secrets is used
+// to create secrets and Names for everything else
+type batchRequest struct {
+	Action  string              `json:"action"`
+	Atomic  bool                `json:"atomic"`
+	Names   []string            `json:"names"`
+	Secrets []smsbackend.Secret `json:"secrets"`
+}
+
+// writeBatchResults writes one entry with a status code per item.
+// The response is 200 when every item succeeded and 207 otherwise
+func writeBatchResults(w http.ResponseWriter, results []smsbackend.BatchResult,
+	rolledBack bool, okStatus int, topic string) {
+
+	type batchItem struct {
+		Name    string                   `json:"name"`
+		Status  int                      `json:"status"`
+		Error   string                   `json:"error,omitempty"`
+		Version string                   `json:"version,omitempty"`
+		Secret  *smsbackend.Secret       `json:"secret,omitempty"`
+		Domain  *smsbackend.SecretDomain `json:"domain,omitempty"`
+	}
+
+	var retStruct = struct {
+		Results    []batchItem `json:"results"`
+		Failed     int         `json:"failed"`
+		RolledBack bool        `json:"rolledback"`
+	}{
+		Results:    []batchItem{},
+		RolledBack: rolledBack,
+	}
+
+	for _, res := range results {
+		item := batchItem{
+			Name:    res.Name,
+			Status:  okStatus,
+			Version: res.Version,
+			Secret:  res.Secret,
+			Domain:  res.Domain,
+		}
+		if res.Err != nil {
+			item.Status = secretErrorStatus(res.Err)
+			item.Error = res.Err.Error()
+			retStruct.Failed++
+		}
+		retStruct.Results = append(retStruct.Results, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if retStruct.Failed > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+	err := json.NewEncoder(w).Encode(retStruct)
+	if smslogger.CheckError(err, topic) != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// batchSecretDomainHandler creates or deletes many secret domains
+func (h handler) batchSecretDomainHandler(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if smslogger.CheckError(err, "BatchSecretDomainHandler") != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var results []smsbackend.BatchResult
+	var rolledBack bool
+	okStatus := http.StatusOK
+
+	switch req.Action {
+	case "create":
+		okStatus = http.StatusCreated
+		results, rolledBack, err = smsbackend.CreateSecretDomains(h.secretBackend, req.Names, req.Atomic, clientName(r))
+	case "delete":
+		okStatus = http.StatusNoContent
+		results, rolledBack, err = smsbackend.DeleteSecretDomains(h.secretBackend, req.Names, req.Atomic)
+	default:
+		http.Error(w, "Unknown batch action: "+req.Action, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	writeBatchResults(w, results, rolledBack, okStatus, "BatchSecretDomainHandler")
+}
+
+// batchSecretHandler creates, reads or deletes many secrets of a domain
+func (h handler) batchSecretHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	domName := vars["domName"]
+
+	var req batchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if smslogger.CheckError(err, "BatchSecretHandler") != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var results []smsbackend.BatchResult
+	var rolledBack bool
+	okStatus := http.StatusOK
+
+	switch req.Action {
+	case "create":
+		okStatus = http.StatusCreated
+		results, rolledBack, err = smsbackend.CreateSecrets(h.secretBackend, domName, req.Secrets, req.Atomic, clientName(r))
+	case "get":
+		if req.Atomic {
+			http.Error(w, "atomic is not supported for get", http.StatusBadRequest)
+			return
+		}
+		results, err = smsbackend.GetSecrets(h.secretBackend, domName, req.Names)
+	case "delete":
+		okStatus = http.StatusNoContent
+		results, rolledBack, err = smsbackend.DeleteSecrets(h.secretBackend, domName, req.Names, req.Atomic)
+	default:
+		http.Error(w, "Unknown batch action: "+req.Action, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	writeBatchResults(w, results, rolledBack, okStatus, "BatchSecretHandler")
+}
+
 // statusHandler returns information related to SMS and SMS backend services
 func (h handler) statusHandler(w http.ResponseWriter, r *http.Request) {
 	s, err := h.secretBackend.GetStatus()
@@ -281,6 +896,25 @@ func (h handler) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// readinessHandler returns OK when the backend is unsealed. Unlike
+// healthCheckHandler it does not write to the backend, which makes
+// it safe to serve without authentication
+func (h handler) readinessHandler(w http.ResponseWriter, r *http.Request) {
+
+	sealed, err := h.secretBackend.GetStatus()
+	if smslogger.CheckError(err, "Readiness") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sealed == true {
+		http.Error(w, "Secret Backend is not ready for operations", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // CreateRouter returns an http.Handler for the registered URLs
 // Takes an interface implementation as input
 func CreateRouter(b smsbackend.SecretBackend) http.Handler {
@@ -299,12 +933,76 @@ func CreateRouter(b smsbackend.SecretBackend) http.Handler {
 
 	router.HandleFunc("/v1/sms/healthcheck", h.healthCheckHandler).Methods("GET")
 	router.HandleFunc("/v1/sms/domain", h.createSecretDomainHandler).Methods("POST")
-	router.HandleFunc("/v1/sms/domain/{domName}", h.deleteSecretDomainHandler).Methods("DELETE")
+	router.HandleFunc("/v1/sms/domain", h.listSecretDomainHandler).Methods("GET")
+	router.HandleFunc("/v1/sms/domain/{domName}", checkNames(h.getSecretDomainHandler)).Methods("GET")
+	router.HandleFunc("/v1/sms/domain/{domName}", checkNames(h.patchSecretDomainHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/sms/domain/{domName}", checkNames(h.deleteSecretDomainHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/sms/domain/{domName}/export", checkNames(h.exportSecretDomainHandler)).Methods("GET")
+	router.HandleFunc("/v1/sms/domain/{domName}/import", checkNames(h.importSecretDomainHandler)).Methods("POST")
+
+	router.HandleFunc("/v1/sms/domain/{domName}/secret", checkNames(h.createSecretHandler)).Methods("POST")
+	router.HandleFunc("/v1/sms/domain/{domName}/secret", checkNames(h.listSecretHandler)).Methods("GET")
+
+	// Secret names can be paths such as db/primary/creds. The key
+	// route is matched first, which is why key cannot be a folder name
+	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName:.+}/key/{key}", checkNames(h.getSecretKeyHandler)).Methods("GET")
+	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName:.+}", checkNames(h.getSecretHandler)).Methods("GET")
+	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName:.+}", checkNames(h.deleteSecretHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName:.+}", checkNames(h.patchSecretHandler)).Methods("PATCH")
+
+	// Batch APIs that run many operations in one request
+	router.HandleFunc("/v1/sms/batch/domain", h.batchSecretDomainHandler).Methods("POST")
+	router.HandleFunc("/v1/sms/batch/domain/{domName}/secret", checkNames(h.batchSecretHandler)).Methods("POST")
 
-	router.HandleFunc("/v1/sms/domain/{domName}/secret", h.createSecretHandler).Methods("POST")
-	router.HandleFunc("/v1/sms/domain/{domName}/secret", h.listSecretHandler).Methods("GET")
-	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName}", h.getSecretHandler).Methods("GET")
-	router.HandleFunc("/v1/sms/domain/{domName}/secret/{secretName}", h.deleteSecretHandler).Methods("DELETE")
+	return router
+}
+
+// CreateAdminRouter returns an http.Handler for the plaintext admin
+// listener. It only exposes health and metrics endpoints and never
+// any secret data. As the listener has no authentication, the
+// healthcheck only reads the seal status of the backend
+func CreateAdminRouter(b smsbackend.SecretBackend) http.Handler {
+	h := handler{secretBackend: b}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/sms/healthcheck", h.readinessHandler).Methods("GET")
+	router.HandleFunc("/debug/vars", varsHandler).Methods("GET")
 
 	return router
 }
+
+// adminVars are the expvar variables served on the admin listener.
+// cmdline is left out as command lines can carry credentials
+var adminVars = []string{"memstats"}
+
+// varsHandler serves adminVars in the format of expvar.Handler
+func varsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := map[string]json.RawMessage{}
+	for _, name := range adminVars {
+		if v := expvar.Get(name); v != nil {
+			vars[name] = json.RawMessage(v.String())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(vars)
+	if smslogger.CheckError(err, "VarsHandler") != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// LimitBodySize wraps a handler and restricts the size of incoming
+// request bodies to maxBytes
+func LimitBodySize(next http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
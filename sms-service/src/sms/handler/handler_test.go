@@ -18,13 +18,24 @@ package handler
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	smsauth "sms/auth"
 	smsbackend "sms/backend"
+	smsconfig "sms/config"
 	"strings"
+	"sync"
 	"testing"
+	"testing/quick"
 )
 
 var h handler
@@ -65,11 +76,24 @@ func (b *TestBackend) ListSecret(dom string) ([]string, error) {
 	return []string{"testsecret1", "testsecret2"}, nil
 }
 
+func (b *TestBackend) ListSecretDomain() ([]string, error) {
+	return []string{"testdomain1", "testdomain2"}, nil
+}
+
 func (b *TestBackend) CreateSecretDomain(name string) (smsbackend.SecretDomain, error) {
 	return smsbackend.SecretDomain{UUID: "123e4567-e89b-12d3-a456-426655440000",
 		Name: "testdomain"}, nil
 }
 
+func (b *TestBackend) GetSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	return smsbackend.SecretDomain{UUID: "123e4567-e89b-12d3-a456-426655440000",
+		Name: name}, nil
+}
+
+func (b *TestBackend) UpdateSecretDomain(dom smsbackend.SecretDomain) error {
+	return nil
+}
+
 func (b *TestBackend) CreateSecret(dom string, sec smsbackend.Secret) error {
 	return nil
 }
@@ -214,6 +238,10 @@ func TestCreateSecretDomainHandler(t *testing.T) {
 	got := smsbackend.SecretDomain{}
 	json.NewDecoder(rr.Body).Decode(&got)
 
+	if got.Metadata == nil || got.Metadata.Created == "" {
+		t.Errorf("CreateSecretDomainHandler returned no creation time: %v", got.Metadata)
+	}
+	got.Metadata = nil
 	if reflect.DeepEqual(expected, got) == false {
 		t.Errorf("CreateSecretDomainHandler returned unexpected body: got %v;"+
 			" expected %v", got, expected)
@@ -260,6 +288,53 @@ func TestDeleteSecretDomainHandler(t *testing.T) {
 	}
 }
 
+// missingBackend has no domains and refuses the domain "bad"
+type missingBackend struct {
+	TestBackend
+}
+
+func (b *missingBackend) domainError(name string) error {
+	if name == "bad" {
+		return &smsbackend.ValidationError{Message: "Invalid domain"}
+	}
+	return smsbackend.ErrDomainNotFound
+}
+
+func (b *missingBackend) GetSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	return smsbackend.SecretDomain{}, b.domainError(name)
+}
+
+func (b *missingBackend) ListSecret(dom string) ([]string, error) {
+	return nil, b.domainError(dom)
+}
+
+func (b *missingBackend) DeleteSecretDomain(name string) error {
+	return b.domainError(name)
+}
+
+func TestSecretDomainHandlerErrors(t *testing.T) {
+	router := CreateRouter(&missingBackend{})
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"DELETE", "/v1/sms/domain/missing", http.StatusNotFound},
+		{"DELETE", "/v1/sms/domain/bad", http.StatusBadRequest},
+		{"GET", "/v1/sms/domain/missing/export?pgpkey=key", http.StatusNotFound},
+		{"GET", "/v1/sms/domain/bad/export?pgpkey=key", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: Expected %v. Got: %v", tc.method, tc.url, tc.code, rr.Code)
+		}
+	}
+}
+
 func TestDeleteSecretHandler(t *testing.T) {
 	req, err := http.NewRequest("DELETE", "/v1/sms/domain/testdomain/secret/testsecret", nil)
 	if err != nil {
@@ -340,6 +415,38 @@ func TestListSecretHandler(t *testing.T) {
 	}
 }
 
+func TestListSecretDomainHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/sms/domain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	hr := http.HandlerFunc(h.listSecretDomainHandler)
+
+	hr.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected statusOK return code. Got: %v", rr.Code)
+	}
+
+	var expected = struct {
+		DomainNames []string `json:"domainnames"`
+	}{
+		[]string{"testdomain1", "testdomain2"},
+	}
+
+	var got struct {
+		DomainNames []string `json:"domainnames"`
+	}
+
+	json.NewDecoder(rr.Body).Decode(&got)
+
+	if reflect.DeepEqual(expected, got) == false {
+		t.Errorf("ListSecretDomainHandler returned unexpected body: got: %v"+
+			" expected: %v", got, expected)
+	}
+}
+
 func TestHealthCheckHandler(t *testing.T) {
 	req, err := http.NewRequest("GET", "/v1/sms/healthcheck", nil)
 	if err != nil {
@@ -358,3 +465,785 @@ func TestHealthCheckHandler(t *testing.T) {
 		t.Errorf("%s", rr.Body.String())
 	}
 }
+
+func TestAdminRouter(t *testing.T) {
+	router := CreateAdminRouter(&TestBackend{})
+
+	req := httptest.NewRequest("GET", "/debug/vars", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var vars map[string]json.RawMessage
+	err := json.NewDecoder(rr.Body).Decode(&vars)
+	if rr.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected JSON vars. Got: %v %v", rr.Code, err)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Errorf("Admin router must not expose the command line")
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Errorf("Admin router did not return memstats")
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Admin router exposed the secret API: %v", rr.Code)
+	}
+
+	// missingBackend fails to delete any domain, so the healthcheck
+	// only succeeds if it does not create a domain to delete
+	router = CreateAdminRouter(&missingBackend{})
+	req = httptest.NewRequest("GET", "/v1/sms/healthcheck", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Admin healthcheck must not write to the backend: %v", rr.Code)
+	}
+}
+
+func TestLimitBodySize(t *testing.T) {
+	body := strings.Repeat("a", 64)
+	req, err := http.NewRequest("POST", "/v1/sms/domain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	hr := LimitBodySize(http.HandlerFunc(h.createSecretDomainHandler), 16)
+
+	hr.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected StatusRequestEntityTooLarge return code. Got: %v", rr.Code)
+	}
+}
+
+func TestExportSecretDomainHandler(t *testing.T) {
+	pbKey, prKey, err := smsauth.GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/v1/sms/domain/testdomain/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	hr := http.HandlerFunc(h.exportSecretDomainHandler)
+
+	hr.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected statusBadRequest without pgpkey. Got: %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/v1/sms/domain/testdomain/export?pgpkey="+
+		url.QueryEscape(pbKey), nil)
+	rr = httptest.NewRecorder()
+	hr.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected statusOK return code. Got: %v", rr.Code)
+	}
+
+	var got struct {
+		Bundle string `json:"bundle"`
+	}
+	json.NewDecoder(rr.Body).Decode(&got)
+
+	data, err := smsauth.DecryptPGPString(got.Bundle, prKey)
+	if err != nil {
+		t.Fatal("ExportSecretDomainHandler: Unable to decrypt bundle")
+	}
+
+	var bundle smsbackend.DomainBundle
+	json.Unmarshal([]byte(data), &bundle)
+	if bundle.Version != smsbackend.BundleVersion || len(bundle.Secrets) != 2 ||
+		bundle.Secrets[0].Values["name"] != "john" {
+		t.Errorf("ExportSecretDomainHandler returned unexpected bundle: %v", bundle)
+	}
+}
+
+func TestImportSecretDomainHandler(t *testing.T) {
+	pbKey, prKey, err := smsauth.GeneratePGPKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "handler")
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "pgp.key")
+	smsauth.WriteToFile(prKey, keyFile)
+
+	oldConfig := smsconfig.SMSConfig
+	smsconfig.SMSConfig = &smsconfig.SMSConfiguration{PGPKeyFile: keyFile}
+	defer func() { smsconfig.SMSConfig = oldConfig }()
+
+	data, _ := json.Marshal(smsbackend.DomainBundle{
+		Version: smsbackend.BundleVersion,
+		Domain:  "sourcedomain",
+		Secrets: []smsbackend.Secret{{Name: "testsecret1", Values: map[string]interface{}{"a": "b"}}},
+	})
+	enc, err := smsauth.EncryptPGPString(string(data), pbKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"bundle": enc})
+
+	router := CreateRouter(h.secretBackend)
+
+	// testdomain1 already contains testsecret1
+	req, _ := http.NewRequest("POST", "/v1/sms/domain/testdomain1/import", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected statusConflict for existing secret. Got: %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/v1/sms/domain/testdomain1/import?overwrite=true",
+		bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected statusCreated return code. Got: %v", rr.Code)
+	}
+
+	var got struct {
+		Domain   string `json:"domain"`
+		Imported int    `json:"imported"`
+	}
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.Domain != "testdomain1" || got.Imported != 1 {
+		t.Errorf("ImportSecretDomainHandler returned unexpected body: %v", got)
+	}
+
+	req, _ = http.NewRequest("POST", "/v1/sms/domain/newdomain/import",
+		strings.NewReader(`{"bundle": "bm90IGVuY3J5cHRlZA=="}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected statusBadRequest for invalid bundle. Got: %v", rr.Code)
+	}
+}
+
+func TestPatchSecretHandler(t *testing.T) {
+	router := CreateRouter(h.secretBackend)
+	current, _ := h.secretBackend.GetSecret("testdomain", "testsecret")
+	version := `"` + smsbackend.SecretVersion(current) + `"`
+
+	body := `{"values":{"profession":null,"team":"aaf"}}`
+	req := httptest.NewRequest("PATCH", "/v1/sms/domain/testdomain/secret/testsecret", strings.NewReader(body))
+	req.Header.Set("If-Match", version)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("patchSecretHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusOK)
+	}
+
+	var got smsbackend.Secret
+	json.NewDecoder(rr.Body).Decode(&got)
+	expected := map[string]interface{}{"name": "john", "team": "aaf"}
+	if reflect.DeepEqual(got.Values, expected) == false {
+		t.Errorf("patchSecretHandler returned unexpected values: %v", got.Values)
+	}
+	if rr.Header().Get("ETag") != `"`+smsbackend.SecretVersion(got)+`"` {
+		t.Errorf("patchSecretHandler returned wrong ETag: %s", rr.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest("PATCH", "/v1/sms/domain/testdomain/secret/testsecret", strings.NewReader(body))
+	req.Header.Set("If-Match", `"stale"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("patchSecretHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusPreconditionFailed)
+	}
+
+	req = httptest.NewRequest("PATCH", "/v1/sms/domain/testdomain/secret/testsecret", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("patchSecretHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest("DELETE", "/v1/sms/domain/testdomain/secret/testsecret", nil)
+	req.Header.Set("If-Match", `"stale"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("deleteSecretHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusPreconditionFailed)
+	}
+}
+
+func TestGetSecretKeyHandler(t *testing.T) {
+	router := CreateRouter(h.secretBackend)
+
+	req := httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/testsecret/key/name", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"key":"name","type":"string","value":"john"}` {
+		t.Errorf("getSecretKeyHandler returned unexpected response: %v %s", rr.Code, rr.Body.String())
+	}
+
+	for _, accept := range []string{"text/plain", "application/octet-stream"} {
+		req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/testsecret/key/name", nil)
+		req.Header.Set("Accept", accept)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Body.String() != "john" || !strings.HasPrefix(rr.Header().Get("Content-Type"), accept) {
+			t.Errorf("getSecretKeyHandler returned unexpected raw value for %s: %s", accept, rr.Body.String())
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/testsecret/key/missing", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("getSecretKeyHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetSecretHandlerKeys(t *testing.T) {
+	router := CreateRouter(h.secretBackend)
+
+	req := httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/testsecret?keys=name", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	got := smsbackend.Secret{}
+	json.NewDecoder(rr.Body).Decode(&got)
+	expected := map[string]interface{}{"name": "john"}
+	if rr.Code != http.StatusOK || reflect.DeepEqual(got.Values, expected) == false {
+		t.Errorf("getSecretHandler returned unexpected values: %v", got.Values)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/testsecret?keys=name,missing", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "missing") {
+		t.Errorf("getSecretHandler returned wrong status code: %v vs %v",
+			rr.Code, http.StatusNotFound)
+	}
+}
+
+// binaryBackend returns a secret with a binary value
+type binaryBackend struct {
+	TestBackend
+}
+
+func (b *binaryBackend) GetSecret(dom string, sec string) (smsbackend.Secret, error) {
+	return smsbackend.Secret{
+		Name:   "keystore",
+		Values: map[string]interface{}{"jks": "AP7t/u0=", "port": 8080.0},
+		Types:  map[string]string{"jks": smsbackend.ValueBinary},
+	}, nil
+}
+
+func TestTypedSecretValues(t *testing.T) {
+	router := CreateRouter(&binaryBackend{})
+
+	req := httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/keystore/key/jks", nil)
+	req.Header.Set("Accept", "application/octet-stream")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), []byte{0x00, 0xfe, 0xed, 0xfe, 0xed}) {
+		t.Errorf("getSecretKeyHandler returned unexpected binary value: %v", rr.Body.Bytes())
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/keystore/key/port", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if strings.TrimSpace(rr.Body.String()) != `{"key":"port","type":"number","value":8080}` {
+		t.Errorf("getSecretKeyHandler returned unexpected value: %s", rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret/keystore?keys=jks", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	got := smsbackend.Secret{}
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.Types["jks"] != smsbackend.ValueBinary || len(got.Values) != 1 {
+		t.Errorf("getSecretHandler did not return the types of the filtered keys: %v", got)
+	}
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"name":"ks","values":{"jks":"AP7t/u0="},"types":{"jks":"binary"}}`, http.StatusCreated},
+		{`{"name":"ks","values":{"jks":"not base64"},"types":{"jks":"binary"}}`, http.StatusBadRequest},
+		{`{"name":"ks","values":{"port":"8080"},"types":{"port":"number"}}`, http.StatusBadRequest},
+		{`{"name":"ks","values":{"big":"` + strings.Repeat("a", 1<<17) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		req = httptest.NewRequest("POST", "/v1/sms/domain/testdomain/secret", strings.NewReader(test.body))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("createSecretHandler returned wrong status code: %v vs %v", rr.Code, test.code)
+		}
+	}
+}
+
+func TestBatchHandlers(t *testing.T) {
+	router := CreateRouter(h.secretBackend)
+
+	tests := []struct {
+		url   string
+		body  string
+		code  int
+		items []int
+	}{
+		{"/v1/sms/batch/domain", `{"action":"create","names":["d1","d2"]}`, http.StatusOK,
+			[]int{http.StatusCreated, http.StatusCreated}},
+		{"/v1/sms/batch/domain", `{"action":"delete","names":["d1"]}`, http.StatusOK,
+			[]int{http.StatusNoContent}},
+		{"/v1/sms/batch/domain/testdomain/secret",
+			`{"action":"create","atomic":true,"secrets":[{"name":"s1","values":{"a":"b"}},{"name":"s2","types":{"x":"string"}}]}`,
+			http.StatusMultiStatus, []int{http.StatusFailedDependency, http.StatusBadRequest}},
+		{"/v1/sms/batch/domain/testdomain/secret", `{"action":"get","names":["s1","s2"]}`, http.StatusOK,
+			[]int{http.StatusOK, http.StatusOK}},
+		{"/v1/sms/batch/domain/testdomain/secret", `{"action":"delete","names":["s1"]}`, http.StatusOK,
+			[]int{http.StatusNoContent}},
+		{"/v1/sms/batch/domain/testdomain/secret", `{"action":"get","names":["s1","s1"]}`, http.StatusBadRequest, nil},
+		{"/v1/sms/batch/domain/testdomain/secret", `{"action":"rename","names":["s1"]}`, http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.url, strings.NewReader(test.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("batch handler returned wrong status code for %s: %v vs %v",
+				test.body, rr.Code, test.code)
+			continue
+		}
+		if test.items == nil {
+			continue
+		}
+
+		var got struct {
+			Results []struct {
+				Status int                `json:"status"`
+				Secret *smsbackend.Secret `json:"secret"`
+			} `json:"results"`
+		}
+		json.NewDecoder(rr.Body).Decode(&got)
+		if len(got.Results) != len(test.items) {
+			t.Errorf("batch handler returned wrong number of results for %s", test.body)
+			continue
+		}
+		for i, res := range got.Results {
+			if res.Status != test.items[i] {
+				t.Errorf("batch handler returned wrong item status for %s: %v vs %v",
+					test.body, res.Status, test.items[i])
+			}
+		}
+	}
+}
+
+func TestListSecretHandlerPages(t *testing.T) {
+	router := CreateRouter(h.secretBackend)
+
+	req := httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret?limit=1&metadata=true", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var got struct {
+		SecretNames []string                `json:"secretnames"`
+		Secrets     []smsbackend.SecretInfo `json:"secrets"`
+		Next        string                  `json:"next"`
+	}
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(got.SecretNames, []string{"testsecret1"}) ||
+		got.Next == "" || len(got.Secrets) != 1 || got.Secrets[0].Version == "" {
+		t.Fatalf("listSecretHandler returned unexpected first page: %v %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret?limit=1&cursor="+got.Next, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	got.Next = ""
+	got.Secrets = nil
+	json.NewDecoder(rr.Body).Decode(&got)
+	if !reflect.DeepEqual(got.SecretNames, []string{"testsecret2"}) || got.Next != "" || got.Secrets != nil {
+		t.Errorf("listSecretHandler returned unexpected last page: %s", rr.Body.String())
+	}
+
+	for _, query := range []string{"limit=0", "sort=size", "match=[", "cursor=bad"} {
+		req = httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret?"+query, nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("listSecretHandler returned wrong status code for %s: %v vs %v",
+				query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// folderBackend has secrets in folders and records the secrets it
+// is asked for
+type folderBackend struct {
+	TestBackend
+	names []string
+}
+
+func (b *folderBackend) GetSecret(dom string, sec string) (smsbackend.Secret, error) {
+	b.names = append(b.names, sec)
+	return b.TestBackend.GetSecret(dom, sec)
+}
+
+func (b *folderBackend) ListSecret(dom string) ([]string, error) {
+	return []string{"db/primary/creds", "db/replica/creds", "mq"}, nil
+}
+
+func TestSecretPaths(t *testing.T) {
+	b := &folderBackend{}
+	router := CreateRouter(b)
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "/v1/sms/domain/testdomain/secret/db/primary/creds", http.StatusOK},
+		{"GET", "/v1/sms/domain/testdomain/secret/db%2Freplica%2Fcreds", http.StatusOK},
+		{"GET", "/v1/sms/domain/testdomain/secret/db/primary/creds/key/name", http.StatusOK},
+		{"GET", "/v1/sms/domain/testdomain/secret/db/../../other/secret/x", http.StatusMovedPermanently},
+		{"PATCH", "/v1/sms/domain/testdomain/secret/db/key/creds", http.StatusBadRequest},
+		{"DELETE", "/v1/sms/domain/testdomain/secret/db/?recursive=true", http.StatusOK},
+		{"DELETE", "/v1/sms/domain/testdomain/secret/db?recursive=true", http.StatusOK},
+		{"DELETE", "/v1/sms/domain/testdomain/secret/none?recursive=true", http.StatusNotFound},
+		{"GET", "/v1/sms/domain/testdomain/secret?path=db", http.StatusOK},
+		{"GET", "/v1/sms/domain/testdomain/secret?path=db/..", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(`{"values":{"a":"b"}}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("%s %s returned wrong status code: %v vs %v", test.method, test.url, rr.Code, test.code)
+		}
+	}
+
+	expected := []string{"db/primary/creds", "db/replica/creds", "db/primary/creds"}
+	if !reflect.DeepEqual(b.names[:3], expected) {
+		t.Errorf("Secret handlers read unexpected secrets: %v", b.names)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/sms/domain/testdomain/secret?recursive=true&path=db/", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `["db/primary/creds","db/replica/creds"]`) {
+		t.Errorf("listSecretHandler returned unexpected recursive list: %s", rr.Body.String())
+	}
+}
+
+// nameBackend records every domain and secret name that reaches it
+// and separately the names of created domains
+type nameBackend struct {
+	TestBackend
+	mu      sync.Mutex
+	names   []string
+	created []string
+}
+
+func (b *nameBackend) record(dom string, sec string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.names = append(b.names, dom, sec)
+}
+
+func (b *nameBackend) GetSecret(dom string, sec string) (smsbackend.Secret, error) {
+	b.record(dom, sec)
+	return smsbackend.Secret{Name: sec, Values: map[string]interface{}{"name": "john"}}, nil
+}
+
+func (b *nameBackend) ListSecret(dom string) ([]string, error) {
+	b.record(dom, "")
+	return []string{}, nil
+}
+
+func (b *nameBackend) CreateSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	b.record(name, "")
+	b.mu.Lock()
+	b.created = append(b.created, name)
+	b.mu.Unlock()
+	return smsbackend.SecretDomain{Name: name}, nil
+}
+
+func (b *nameBackend) CreateSecret(dom string, sec smsbackend.Secret) error {
+	b.record(dom, sec.Name)
+	return nil
+}
+
+func (b *nameBackend) DeleteSecretDomain(name string) error {
+	b.record(name, "")
+	return nil
+}
+
+func (b *nameBackend) DeleteSecret(dom string, name string) error {
+	b.record(dom, name)
+	return nil
+}
+
+func TestNameValidation(t *testing.T) {
+	b := &nameBackend{}
+	router := CreateRouter(b)
+
+	// Names are built from one to three parts. Valid parts are listed
+	// more than once so that enough requests reach the backend
+	parts := []string{"a", "a", "b", "Z9", "key", "-", "_", ".", "..", "/", "//", " ", "%2F", "%00",
+		"?", "#", "\\", "\n", "smsinternaldomain", "ü", strings.Repeat("x", 64)}
+	name := func(idx []byte) string {
+		if len(idx) > 0 {
+			count := 1 + int(idx[0])%3
+			idx = idx[1:]
+			if len(idx) > count {
+				idx = idx[:count]
+			}
+		}
+		n := ""
+		for _, i := range idx {
+			n += parts[int(i)%len(parts)]
+		}
+		return n
+	}
+	escape := func(p string) string {
+		segs := strings.Split(p, "/")
+		for i := range segs {
+			segs[i] = url.PathEscape(segs[i])
+		}
+		return strings.Join(segs, "/")
+	}
+
+	check := func(domIdx []byte, secIdx []byte) bool {
+		dom, sec := name(domIdx), name(secIdx)
+		domURL := "/v1/sms/domain/" + url.PathEscape(dom)
+		secURL := domURL + "/secret/" + escape(sec)
+		body, _ := json.Marshal(map[string]interface{}{"name": sec, "values": map[string]string{"a": "b"}})
+		domBody, _ := json.Marshal(map[string]string{"name": dom})
+
+		requests := []struct {
+			method string
+			url    string
+			body   []byte
+		}{
+			{"POST", "/v1/sms/domain", domBody},
+			{"DELETE", domURL, nil},
+			{"POST", domURL + "/secret", body},
+			{"GET", domURL + "/secret?path=" + url.QueryEscape(sec), nil},
+			{"GET", secURL, nil},
+			{"GET", secURL + "/key/name", nil},
+			{"PATCH", secURL, []byte(`{"values":{"a":"c"}}`)},
+			{"DELETE", secURL, nil},
+			{"DELETE", secURL + "?recursive=true", nil},
+		}
+		for _, r := range requests {
+			req := httptest.NewRequest(r.method, "/", bytes.NewReader(r.body))
+			req.URL, _ = url.Parse(r.url)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code >= http.StatusInternalServerError {
+				t.Errorf("%s %s returned %v: %s", r.method, r.url, rr.Code, rr.Body.String())
+				return false
+			}
+		}
+		return true
+	}
+
+	err := quick.Check(check, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Existing names only need to be safe paths. New domains must
+	// follow the naming rules
+	for i := 0; i < len(b.names); i += 2 {
+		if err := smsbackend.CheckDomainName(b.names[i]); err != nil {
+			t.Errorf("Backend received invalid domain name %q", b.names[i])
+		}
+		sec := b.names[i+1]
+		if sec == "" {
+			continue
+		}
+		if err := smsbackend.CheckSecretName(sec); err != nil {
+			t.Errorf("Backend received invalid secret name %q", b.names[i+1])
+		}
+	}
+	for _, n := range b.created {
+		if err := smsbackend.ValidateDomainName(n); err != nil {
+			t.Errorf("Backend created domain with invalid name %q", n)
+		}
+	}
+	if len(b.names) == 0 || len(b.created) == 0 {
+		t.Errorf("No request reached the backend")
+	}
+}
+
+// oldNameBackend holds secrets in memory by domain and name
+type oldNameBackend struct {
+	TestBackend
+	secrets map[string]smsbackend.Secret
+}
+
+func (b *oldNameBackend) GetSecret(dom string, name string) (smsbackend.Secret, error) {
+	sec, ok := b.secrets[dom+"|"+name]
+	if !ok {
+		return smsbackend.Secret{}, smsbackend.ErrSecretNotFound
+	}
+	return sec, nil
+}
+
+func (b *oldNameBackend) CreateSecret(dom string, sec smsbackend.Secret) error {
+	b.secrets[dom+"|"+sec.Name] = sec
+	return nil
+}
+
+func (b *oldNameBackend) DeleteSecret(dom string, name string) error {
+	delete(b.secrets, dom+"|"+name)
+	return nil
+}
+
+func TestOldNames(t *testing.T) {
+
+	// Created before the naming rules, which refuse both names
+	dom, name := "old domain", "my secret@x:1"
+	b := &oldNameBackend{secrets: map[string]smsbackend.Secret{
+		dom + "|" + name: {Name: name, Values: map[string]interface{}{"a": "b"}},
+	}}
+	router := CreateRouter(b)
+	secURL := "/v1/sms/domain/" + url.PathEscape(dom) + "/secret/" + url.PathEscape(name)
+
+	send := func(method string, u string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.URL, _ = url.Parse(u)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("GET", secURL, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"a":"b"`) {
+		t.Fatalf("GET old secret returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = send("PATCH", secURL, `{"values":{"a":"c"}}`)
+	if rr.Code != http.StatusOK || b.secrets[dom+"|"+name].Values["a"] != "c" {
+		t.Fatalf("PATCH old secret returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = send("DELETE", secURL, "")
+	if rr.Code != http.StatusNoContent || len(b.secrets) != 0 {
+		t.Fatalf("DELETE old secret returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// New secrets and domains have to follow the rules
+	rr = send("POST", "/v1/sms/domain/"+url.PathEscape(dom)+"/secret",
+		`{"name":"`+name+`","values":{"a":"b"}}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("POST secret with old name returned %v", rr.Code)
+	}
+	rr = send("POST", "/v1/sms/domain", `{"name":"`+dom+`"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("POST domain with old name returned %v", rr.Code)
+	}
+}
+
+// metaBackend keeps domains and the last secret written in memory
+type metaBackend struct {
+	TestBackend
+	domains map[string]smsbackend.SecretDomain
+	written smsbackend.Secret
+}
+
+func (b *metaBackend) ListSecretDomain() ([]string, error) {
+	names := []string{}
+	for n := range b.domains {
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func (b *metaBackend) GetSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	dom, ok := b.domains[name]
+	if !ok {
+		return dom, smsbackend.ErrDomainNotFound
+	}
+	return dom, nil
+}
+
+func (b *metaBackend) CreateSecretDomain(name string) (smsbackend.SecretDomain, error) {
+	dom := smsbackend.SecretDomain{UUID: "uuid-" + name, Name: name}
+	b.domains[name] = dom
+	return dom, nil
+}
+
+func (b *metaBackend) UpdateSecretDomain(dom smsbackend.SecretDomain) error {
+	b.domains[dom.Name] = dom
+	return nil
+}
+
+func (b *metaBackend) CreateSecret(dom string, sec smsbackend.Secret) error {
+	b.written = sec
+	return nil
+}
+
+func TestMetadataHandlers(t *testing.T) {
+	b := &metaBackend{domains: map[string]smsbackend.SecretDomain{}}
+	router := CreateRouter(b)
+	client := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "client1"}}},
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+		want   string
+	}{
+		{"POST", "/v1/sms/domain", `{"name":"dom1","metadata":{"owner":"team-a","labels":{"env":"prod"}}}`,
+			http.StatusCreated, `"modifiedby":"client1"`},
+		{"POST", "/v1/sms/domain", `{"name":"dom2","metadata":{"labels":{"env":"test"}}}`, http.StatusCreated, ""},
+		{"POST", "/v1/sms/domain", `{"name":"dom3","metadata":{"labels":{"bad key":""}}}`, http.StatusBadRequest, ""},
+		{"GET", "/v1/sms/domain/dom1", "", http.StatusOK, `"owner":"team-a"`},
+		{"GET", "/v1/sms/domain/missing", "", http.StatusNotFound, ""},
+		{"PATCH", "/v1/sms/domain/dom2", `{"metadata":{"description":"Testing","labels":{"env":"prod"}}}`,
+			http.StatusOK, `"description":"Testing"`},
+		{"PATCH", "/v1/sms/domain/dom2", `{}`, http.StatusBadRequest, ""},
+		{"GET", "/v1/sms/domain?label=env%3Dprod", "", http.StatusOK, `{"domainnames":["dom1","dom2"]}`},
+		{"GET", "/v1/sms/domain?metadata=true", "", http.StatusOK, `"domainnames":["dom1","dom2"],"domains":[{`},
+		{"GET", "/v1/sms/domain?label=%3Dprod", "", http.StatusBadRequest, ""},
+		{"POST", "/v1/sms/domain/dom1/secret", `{"name":"db","values":{},"metadata":{"description":"Database"}}`,
+			http.StatusCreated, ""},
+		{"GET", "/v1/sms/domain/dom1/secret?label=env", "", http.StatusOK, `"secretnames":[]`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		req.TLS = client
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.code || !strings.Contains(rr.Body.String(), test.want) {
+			t.Errorf("%s %s returned %v: %s", test.method, test.url, rr.Code, rr.Body.String())
+		}
+	}
+
+	if b.written.Metadata == nil || b.written.Metadata.Description != "Database" ||
+		b.written.Metadata.ModifiedBy != "client1" || b.written.Metadata.Created == "" {
+		t.Errorf("createSecretHandler wrote unexpected metadata: %v", b.written.Metadata)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/sms/domain/dom1/secret",
+		strings.NewReader(`{"name":"mq","values":{"k":"v"},"metadata":{"owner":"team-b"}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Header().Get("ETag") != `"`+smsbackend.SecretVersion(b.written)+`"` {
+		t.Errorf("createSecretHandler returned ETag %s for a different secret than stored",
+			rr.Header().Get("ETag"))
+	}
+}
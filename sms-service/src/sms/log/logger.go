@@ -17,13 +17,60 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
 var errL, warnL, infoL *log.Logger
 var stdErr, stdWarn, stdInfo *log.Logger
+var logFile *os.File
+
+// Log levels in increasing order of verbosity
+const (
+	levelError int32 = iota
+	levelWarn
+	levelInfo
+)
+
+var logLevel = levelInfo
+
+func parseLevel(level string) (int32, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "error":
+		return levelError, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "info", "":
+		return levelInfo, nil
+	}
+	return 0, errors.New("Unknown log level: " + level)
+}
+
+// CheckLevel returns an error if level is not a valid log level
+func CheckLevel(level string) error {
+	_, err := parseLevel(level)
+	return err
+}
+
+// SetLevel changes the verbosity of the logger at runtime.
+// Valid values are error, warn and info
+func SetLevel(level string) error {
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&logLevel, l)
+	return nil
+}
+
+func levelEnabled(l int32) bool {
+	return atomic.LoadInt32(&logLevel) >= l
+}
 
 // Init will be called by sms.go before any other packages use it
 func Init(filePath string) {
@@ -43,11 +90,25 @@ func Init(filePath string) {
 		return
 	}
 
+	logFile = f
 	errL = log.New(f, "ERROR: ", log.Lshortfile|log.LstdFlags)
 	warnL = log.New(f, "WARNING: ", log.Lshortfile|log.LstdFlags)
 	infoL = log.New(f, "INFO: ", log.Lshortfile|log.LstdFlags)
 }
 
+// Close flushes the log file to disk and closes it.
+// Further writes will only go to the std streams
+func Close() {
+	if logFile == nil {
+		return
+	}
+
+	errL, warnL, infoL = nil, nil, nil
+	logFile.Sync()
+	logFile.Close()
+	logFile = nil
+}
+
 // WriteError writes output to the writer we have
 // defined during its creation with ERROR prefix
 func WriteError(msg string) {
@@ -62,6 +123,9 @@ func WriteError(msg string) {
 // WriteWarn writes output to the writer we have
 // defined during its creation with WARNING prefix
 func WriteWarn(msg string) {
+	if !levelEnabled(levelWarn) {
+		return
+	}
 	if warnL != nil {
 		warnL.Output(2, fmt.Sprintln(msg))
 	}
@@ -73,6 +137,9 @@ func WriteWarn(msg string) {
 // WriteInfo writes output to the writer we have
 // defined during its creation with INFO prefix
 func WriteInfo(msg string) {
+	if !levelEnabled(levelInfo) {
+		return
+	}
 	if infoL != nil {
 		infoL.Output(2, fmt.Sprintln(msg))
 	}
@@ -18,10 +18,13 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	smsauth "sms/auth"
 	smsbackend "sms/backend"
@@ -30,12 +33,110 @@ import (
 	smslogger "sms/log"
 )
 
+// smsConfigFile is the default configuration file that is read
+// at startup and again on SIGHUP
+const smsConfigFile = "smsconfig.json"
+
+// reloadConfiguration applies the non-critical settings from the
+// configuration file and reloads the TLS certificates and the client
+// certificate policy from disk. The new configuration only replaces
+// the current one once the certificates and the policy are in use
+func reloadConfiguration(configFile string, certReloader *smsauth.CertReloader) {
+
+	prev := smsconfig.Current()
+	smsConf, err := smsconfig.ReloadConfigFile(configFile)
+	if smslogger.CheckError(err, "Reload Configuration") != nil {
+		smslogger.WriteWarn("Keeping previous configuration")
+		return
+	}
+
+	if certReloader != nil && prev != nil {
+		certReloader.SetFiles(smsConf.CAFile, smsConf.ServerCert, smsConf.ServerKey)
+		err = certReloader.Reload()
+		if smslogger.CheckError(err, "Reload TLS certificates") != nil {
+			certReloader.SetFiles(prev.CAFile, prev.ServerCert, prev.ServerKey)
+			smslogger.WriteWarn("Keeping previous configuration and certificates")
+			return
+		}
+
+		err = certReloader.SetClientAuth(smsauth.ClientAuthConfig{
+			RequireClientCert: smsConf.RequireClientCert,
+			CRLFile:           smsConf.CRLFile,
+			OCSPCheck:         smsConf.OCSPCheck,
+		})
+		if smslogger.CheckError(err, "Reload client certificate policy") != nil {
+			// The new certificates are already loaded, so go back
+			// to the files of the previous configuration
+			certReloader.SetFiles(prev.CAFile, prev.ServerCert, prev.ServerKey)
+			smslogger.CheckError(certReloader.Reload(), "Restore TLS certificates")
+			smslogger.WriteWarn("Keeping previous configuration and client certificate policy")
+			return
+		}
+	}
+
+	smsconfig.SetCurrent(smsConf)
+	smslogger.WriteInfo("Configuration reloaded")
+}
+
+// loadConfiguration reads and validates the configuration file
+// and loads the secrets it references
+func loadConfiguration(configFile string) (*smsconfig.SMSConfiguration, error) {
+
+	smsConf, err := smsconfig.ReadConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the token and PEM password from their configured sources
+	err = smsauth.LoadConfigSecrets(smsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = smsConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return smsConf, nil
+}
+
 func main() {
+	configFile := flag.String("config", smsConfigFile,
+		"Path to the JSON or YAML configuration file")
+	printConfig := flag.Bool("print-config", false,
+		"Print the effective configuration with secrets redacted and exit")
+	smsconfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *printConfig {
+		smslogger.Init("")
+		smsConf, err := smsconfig.ReadConfigFile(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := smsConf.Redacted()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(out)
+		return
+	}
+
+	// Offline maintenance commands such as backup and restore
+	if flag.NArg() > 0 {
+		smslogger.Init("")
+		err := runCommand(*configFile, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Initialize logger
 	smslogger.Init("sms.log")
 
-	// Read Configuration File
-	smsConf, err := smsconfig.ReadConfigFile("smsconfig.json")
+	smsConf, err := loadConfiguration(*configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,33 +149,101 @@ func main() {
 	httpRouter := smshandler.CreateRouter(backendImpl)
 
 	httpServer := &http.Server{
-		Handler: httpRouter,
-		Addr:    ":10443",
+		Handler:           smshandler.LimitBodySize(httpRouter, smsConf.MaxBodyBytes),
+		Addr:              smsConf.ListenAddress,
+		ReadTimeout:       smsconfig.GetDuration(smsConf.ReadTimeout),
+		ReadHeaderTimeout: smsconfig.GetDuration(smsConf.ReadHeaderTimeout),
+		WriteTimeout:      smsconfig.GetDuration(smsConf.WriteTimeout),
+		IdleTimeout:       smsconfig.GetDuration(smsConf.IdleTimeout),
+		MaxHeaderBytes:    smsConf.MaxHeaderBytes,
+	}
+
+	// Optional plaintext listener for health checks and metrics
+	var adminServer *http.Server
+	if smsConf.AdminListenAddress != "" {
+		adminServer = &http.Server{
+			Handler:           smshandler.CreateAdminRouter(backendImpl),
+			Addr:              smsConf.AdminListenAddress,
+			ReadTimeout:       httpServer.ReadTimeout,
+			ReadHeaderTimeout: httpServer.ReadHeaderTimeout,
+			WriteTimeout:      httpServer.WriteTimeout,
+			IdleTimeout:       httpServer.IdleTimeout,
+			MaxHeaderBytes:    httpServer.MaxHeaderBytes,
+		}
+
+		go func() {
+			smslogger.WriteInfo("Admin listener on " + adminServer.Addr)
+			err := adminServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	}
 
-	// Listener for SIGINT so that it returns cleanly
+	// Listener for SIGINT and SIGTERM so that it returns cleanly.
+	// In-flight requests are given ShutdownTimeout to complete
 	connectionsClose := make(chan struct{})
 	go func() {
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		<-c
-		httpServer.Shutdown(context.Background())
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		sig := <-c
+		smslogger.WriteInfo("Received " + sig.String() + ". Draining connections...")
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			smsconfig.GetDuration(smsConf.ShutdownTimeout))
+		defer cancel()
+
+		if adminServer != nil {
+			adminServer.Shutdown(ctx)
+		}
+		err := httpServer.Shutdown(ctx)
+		if smslogger.CheckError(err, "Shutdown") != nil {
+			smslogger.WriteWarn("Drain timeout exceeded. Closing remaining connections")
+			httpServer.Close()
+		}
 		close(connectionsClose)
 	}()
 
 	// Start in TLS mode by default
-	if smsConf.DisableTLS == true {
-		smslogger.WriteWarn("TLS is Disabled")
-		err = httpServer.ListenAndServe()
-	} else {
-		// Populate TLSConfig with the certificates and privatekey
-		// information
-		tlsConfig, err := smsauth.GetTLSConfig(smsConf.CAFile, smsConf.ServerCert, smsConf.ServerKey)
+	var certReloader *smsauth.CertReloader
+	if smsConf.DisableTLS == false {
+		// The reloader serves the certificates and privatekey
+		// information and allows them to be rotated at runtime
+		certReloader, err = smsauth.NewCertReloader(smsConf.CAFile, smsConf.ServerCert, smsConf.ServerKey)
 		if smslogger.CheckError(err, "Get TLS Configuration") != nil {
 			log.Fatal(err)
 		}
 
-		httpServer.TLSConfig = tlsConfig
+		err = certReloader.SetClientAuth(smsauth.ClientAuthConfig{
+			RequireClientCert: smsConf.RequireClientCert,
+			CRLFile:           smsConf.CRLFile,
+			OCSPCheck:         smsConf.OCSPCheck,
+		})
+		if smslogger.CheckError(err, "Client Certificate Configuration") != nil {
+			log.Fatal(err)
+		}
+		smsauth.ClearConfigPassword(smsConf)
+		httpServer.TLSConfig = certReloader.TLSConfig()
+
+		if smsConf.CertReloadInterval != "" {
+			go certReloader.Watch(smsconfig.GetDuration(smsConf.CertReloadInterval), connectionsClose)
+		}
+	}
+
+	// Listener for SIGHUP to reload certificates and configuration
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
+		for range c {
+			smslogger.WriteInfo("Received SIGHUP. Reloading configuration...")
+			reloadConfiguration(*configFile, certReloader)
+		}
+	}()
+
+	if smsConf.DisableTLS == true {
+		smslogger.WriteWarn("TLS is Disabled")
+		err = httpServer.ListenAndServe()
+	} else {
 		// empty strings because tlsconfig already has this information
 		err = httpServer.ListenAndServeTLS("", "")
 	}
@@ -84,4 +253,10 @@ func main() {
 	}
 
 	<-connectionsClose
+
+	// Revoke backend tokens and flush logs before exiting
+	err = backendImpl.Close()
+	smslogger.CheckError(err, "Close Backend")
+	smslogger.WriteInfo("SMS shutdown complete")
+	smslogger.Close()
 }